@@ -0,0 +1,32 @@
+package file
+
+import (
+	"errors"
+	"io"
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// errorRecordingReader records the first error (other than io.EOF) returned by the wrapped reader.
+type errorRecordingReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (e *errorRecordingReader) Read(p []byte) (int, error) {
+	n, err := e.reader.Read(p)
+	if err != nil && e.err == nil && !errors.Is(err, io.EOF) {
+		e.err = err
+	}
+	return n, err
+}
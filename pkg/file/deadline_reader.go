@@ -0,0 +1,19 @@
+package file
+
+import (
+	"io"
+	"time"
+)
+
+// deadlineReader fails reads with ErrDeadlineExceeded once the deadline has passed.
+type deadlineReader struct {
+	reader   io.Reader
+	deadline time.Time
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	if time.Now().After(d.deadline) {
+		return 0, &ErrDeadlineExceeded{Deadline: d.deadline}
+	}
+	return d.reader.Read(p)
+}
@@ -0,0 +1,38 @@
+package file
+
+import (
+	"crypto/md5"  //nolint:gosec // used only for identifying the algorithm, not for security
+	"crypto/sha1" //nolint:gosec // used only for identifying the algorithm, not for security
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+)
+
+// knownHashAlgorithms maps the hex digest of empty input to the name of the algorithm that produces it, which allows
+// for identifying an algorithm from only its constructor.
+var knownHashAlgorithms = map[string]string{
+	emptyDigest(sha256.New):        "sha256",
+	emptyDigest(sha256.New224):     "sha224",
+	emptyDigest(sha512.New):        "sha512",
+	emptyDigest(sha512.New384):     "sha384",
+	emptyDigest(sha512.New512_256): "sha512_256",
+	emptyDigest(sha1.New):          "sha1",
+	emptyDigest(md5.New):           "md5",
+}
+
+func emptyDigest(newHash func() hash.Hash) string {
+	return fmt.Sprintf("%x", newHash().Sum(nil))
+}
+
+// hashAlgorithmName returns the name used to prefix digests from the given hash constructor, which is only known for
+// well-known algorithms (identified by their output).
+func hashAlgorithmName(newHash func() hash.Hash) (string, bool) {
+	name, ok := knownHashAlgorithms[emptyDigest(newHash)]
+	return name, ok
+}
+
+// formatDigest renders the current sum of the given hash prefixed with the algorithm name (e.g. "sha256:abc...").
+func formatDigest(algorithm string, h hash.Hash) string {
+	return fmt.Sprintf("%s:%x", algorithm, h.Sum(nil))
+}
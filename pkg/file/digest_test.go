@@ -0,0 +1,58 @@
+package file
+
+import (
+	"crypto/md5"  //nolint:gosec // identifying legacy algorithms, not for security
+	"crypto/sha1" //nolint:gosec // identifying legacy algorithms, not for security
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_hashAlgorithmName(t *testing.T) {
+	tests := []struct {
+		name    string
+		newHash func() hash.Hash
+		want    string
+		wantOK  bool
+	}{
+		{name: "sha256", newHash: sha256.New, want: "sha256", wantOK: true},
+		{name: "sha224", newHash: sha256.New224, want: "sha224", wantOK: true},
+		{name: "sha512", newHash: sha512.New, want: "sha512", wantOK: true},
+		{name: "sha1", newHash: sha1.New, want: "sha1", wantOK: true},
+		{name: "md5", newHash: md5.New, want: "md5", wantOK: true},
+		{name: "unknown algorithms are not named", newHash: func() hash.Hash { return fnv.New64a() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := hashAlgorithmName(tt.newHash)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_formatDigest(t *testing.T) {
+	h := sha256.New()
+	h.Write([]byte("hello"))
+	assert.Equal(t, "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", formatDigest("sha256", h))
+}
+
+func TestWithHashAlgorithm(t *testing.T) {
+	_, name := newUntarOptions().newHash()
+	assert.Equal(t, "sha256", name, "default algorithm")
+
+	h, name := newUntarOptions(WithHashAlgorithm("", sha512.New)).newHash()
+	assert.Equal(t, "sha512", name, "identified from output")
+	assert.Equal(t, sha512.Size, h.Size())
+
+	newFNV := func() hash.Hash { return fnv.New64a() }
+	h, name = newUntarOptions(WithHashAlgorithm("fnv64a", newFNV)).newHash()
+	assert.Equal(t, "fnv64a", name, "named explicitly")
+	assert.Equal(t, 8, h.Size())
+
+	assert.Panics(t, func() { WithHashAlgorithm("", newFNV) }, "unknown algorithms must be named")
+}
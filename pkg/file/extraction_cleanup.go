@@ -0,0 +1,70 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// createdPaths are the paths created by a single extraction, in the order they were created (see CleanupOnError).
+type createdPaths struct {
+	paths []string
+}
+
+// trackCreated records the given path, along with any of its missing parents, when it does not exist yet and is about
+// to be created (only when cleaning up on error). Paths that already exist are never recorded, so that cleaning up
+// cannot remove anything that was not created by the extraction.
+func (v tarVisitor) trackCreated(target string) {
+	if v.created == nil {
+		return
+	}
+	var missing []string
+	for p := filepath.Clean(target); ; p = filepath.Dir(p) {
+		if _, err := v.fs.Stat(p); !os.IsNotExist(err) {
+			break
+		}
+		missing = append(missing, p)
+		if p == filepath.Dir(p) {
+			break
+		}
+	}
+	// parents are created before their children
+	for i := len(missing) - 1; i >= 0; i-- {
+		v.created.paths = append(v.created.paths, missing[i])
+	}
+}
+
+// cleanUpAfter removes everything created by the extraction when it failed with the given error (only when cleaning up
+// on error), returning the error along with any raised while removing what was created.
+func (v tarVisitor) cleanUpAfter(err error) error {
+	if err == nil || v.created == nil {
+		return err
+	}
+	if cleanupErr := v.removeCreated(); cleanupErr != nil {
+		return errors.Join(err, fmt.Errorf("unable to clean up partial extraction: %w", cleanupErr))
+	}
+	return err
+}
+
+// removeCreated removes all created paths, children before their parents. Created directories are first made writable,
+// since their mode may have been applied from the archive (e.g. 0555) before extraction failed.
+func (v tarVisitor) removeCreated() error {
+	for _, p := range v.created.paths {
+		if info, err := v.fs.Stat(p); err == nil && info.IsDir() {
+			_ = v.fs.Chmod(p, 0700)
+		}
+	}
+
+	var errs []error
+	for i := len(v.created.paths) - 1; i >= 0; i-- {
+		p := v.created.paths[i]
+		// only the path itself is removed (not what it may contain), which fails for created directories holding
+		// anything written by others since
+		if err := v.fs.Remove(p); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("unable to remove created path (path=%s): %w", p, err))
+		}
+	}
+	v.created.paths = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,100 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUntarToDirectory_CleanupOnError(t *testing.T) {
+	newReader := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "existing/"}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "existing/kept", Mode: 0644}, content: "replaced"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "existing/new", Mode: 0644}, content: "new"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "created/nested/", Mode: 02555}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "created/file", Mode: 0644}, content: "new"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "../escape"}, content: "evil"},
+		)
+	}
+	// existing sets up the destination with content that must survive a cleanup
+	existing := func(t *testing.T, dst string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(dst, "existing"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dst, "existing", "kept"), []byte("original"), 0644))
+	}
+
+	tests := []struct {
+		name    string
+		dst     string
+		setup   func(t *testing.T, dst string)
+		options []Option
+		want    []string
+	}{
+		{
+			name:    "leaves partial content by default",
+			setup:   existing,
+			options: []Option{WithPreserveSpecialBits(true)},
+			want:    []string{".", "created", "created/file", "created/nested", "existing", "existing/kept", "existing/new"},
+		},
+		{
+			name:    "removes only what was created",
+			setup:   existing,
+			options: []Option{WithCleanupOnError(true), WithPreserveSpecialBits(true)},
+			want:    []string{".", "existing", "existing/kept"},
+		},
+		{
+			name:    "removes a created destination",
+			dst:     "missing/dst",
+			options: []Option{WithCleanupOnError(true)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dst := filepath.Join(root, tt.dst)
+			if tt.setup != nil {
+				tt.setup(t, dst)
+			}
+
+			err := UntarToDirectory(newReader(), dst, tt.options...)
+			var traversalErr *ErrPathTraversal
+			require.ErrorAs(t, err, &traversalErr)
+
+			if tt.want == nil {
+				entries, err := os.ReadDir(root)
+				require.NoError(t, err)
+				assert.Empty(t, entries)
+				return
+			}
+			var got []string
+			require.NoError(t, filepath.WalkDir(dst, func(p string, _ fs.DirEntry, err error) error {
+				rel, _ := filepath.Rel(dst, p)
+				got = append(got, filepath.ToSlash(rel))
+				return err
+			}))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestUntarToDirectory_CleanupOnErrorSucceeds(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "dir/"}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "dir/file"}, content: "content"},
+	)
+	dst := filepath.Join(t.TempDir(), "dst")
+	require.NoError(t, UntarToDirectory(reader, dst, WithCleanupOnError(true)))
+
+	content, err := os.ReadFile(filepath.Join(dst, "dir", "file"))
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
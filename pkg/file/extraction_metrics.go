@@ -0,0 +1,34 @@
+package file
+
+// Names of the metrics reported to a MetricsCollector during extraction.
+const (
+	// MetricEntriesProcessed counts every entry visited during extraction, whether or not it was written.
+	MetricEntriesProcessed = "entries_processed"
+	// MetricEntriesSkipped counts entries visited but deliberately not written, such as links and special files.
+	MetricEntriesSkipped = "entries_skipped"
+	// MetricBytesWritten is the total bytes of regular file content written to the destination.
+	MetricBytesWritten = "bytes_written"
+	// MetricBombsDetected counts entries whose content exceeded the per-file read limit (a potential decompression bomb).
+	MetricBombsDetected = "bombs_detected"
+	// MetricTraversalsBlocked counts entries rejected for names or link targets resolving outside of the destination.
+	MetricTraversalsBlocked = "traversals_blocked"
+)
+
+// MetricsCollector receives counters from extraction (see WithMetrics), allowing them to be exported to any metrics
+// system (e.g. as Prometheus counters) without this package depending on one. Implementations must be safe for
+// concurrent use when shared across concurrent extractions.
+type MetricsCollector interface {
+	// Inc increments the named counter by one.
+	Inc(name string)
+	// Add increments the named counter by the given value.
+	Add(name string, v int64)
+}
+
+var _ MetricsCollector = nopMetrics{}
+
+// nopMetrics is the MetricsCollector used when none is configured, which discards all metrics.
+type nopMetrics struct{}
+
+func (nopMetrics) Inc(string) {}
+
+func (nopMetrics) Add(string, int64) {}
@@ -0,0 +1,66 @@
+package file
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// ExtractionSink receives the writes made while extracting an archive, allowing callers to redirect or intercept them
+// (see WithExtractionSink). All paths given are within the extraction destination (or, for removing unsynced paths,
+// the tree synced against, see SyncAgainst). Only writes go through the sink: checks against the destination (such as
+// whether a target already exists or its current content when syncing) and materializing hardlinks operate on the
+// filesystem directly.
+type ExtractionSink interface {
+	// CreateFile creates (or truncates) the named regular file, returning a writer for its content.
+	CreateFile(name string, perm os.FileMode) (io.WriteCloser, error)
+	// MkdirAll creates the named directory along with any missing parents.
+	MkdirAll(path string, perm os.FileMode) error
+	// Chmod sets the mode of the named file or directory.
+	Chmod(name string, mode os.FileMode) error
+	// Chtimes sets the access and modification times of the named file or directory.
+	Chtimes(name string, atime, mtime time.Time) error
+	// Chown sets the owner of the named file or directory (only when ownership is preserved, see WithOwnership).
+	Chown(name string, uid, gid int) error
+	// Rename moves the named file into place (such as a file staged while syncing, see SyncCompareDigests), replacing
+	// any existing file.
+	Rename(oldname, newname string) error
+	// RemoveAll removes the named file or directory along with anything within it (such as staged files, or paths
+	// removed while syncing, see SyncDelete).
+	RemoveAll(path string) error
+}
+
+// fsSink is the ExtractionSink writing to an afero filesystem, which is used unless another sink is configured.
+type fsSink struct {
+	fs afero.Fs
+}
+
+func (s fsSink) CreateFile(name string, perm os.FileMode) (io.WriteCloser, error) {
+	return s.fs.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, perm)
+}
+
+func (s fsSink) MkdirAll(path string, perm os.FileMode) error {
+	return s.fs.MkdirAll(path, perm)
+}
+
+func (s fsSink) Chmod(name string, mode os.FileMode) error {
+	return s.fs.Chmod(name, mode)
+}
+
+func (s fsSink) Chtimes(name string, atime, mtime time.Time) error {
+	return s.fs.Chtimes(name, atime, mtime)
+}
+
+func (s fsSink) Chown(name string, uid, gid int) error {
+	return s.fs.Chown(name, uid, gid)
+}
+
+func (s fsSink) Rename(oldname, newname string) error {
+	return s.fs.Rename(oldname, newname)
+}
+
+func (s fsSink) RemoveAll(path string) error {
+	return s.fs.RemoveAll(path)
+}
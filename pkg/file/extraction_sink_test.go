@@ -0,0 +1,166 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingSink records every call made to it, along with the content written to each file.
+type recordingSink struct {
+	calls    []string
+	contents map[string]*bytes.Buffer
+}
+
+type recordingFile struct {
+	*bytes.Buffer
+	sink *recordingSink
+	name string
+}
+
+func (f recordingFile) Close() error {
+	f.sink.calls = append(f.sink.calls, "Close "+f.name)
+	return nil
+}
+
+func (s *recordingSink) CreateFile(name string, perm os.FileMode) (io.WriteCloser, error) {
+	s.calls = append(s.calls, fmt.Sprintf("CreateFile %s %o", name, perm))
+	s.contents[name] = &bytes.Buffer{}
+	return recordingFile{Buffer: s.contents[name], sink: s, name: name}, nil
+}
+
+func (s *recordingSink) MkdirAll(path string, perm os.FileMode) error {
+	s.calls = append(s.calls, fmt.Sprintf("MkdirAll %s %o", path, perm))
+	return nil
+}
+
+func (s *recordingSink) Chmod(name string, mode os.FileMode) error {
+	s.calls = append(s.calls, fmt.Sprintf("Chmod %s %o", name, mode))
+	return nil
+}
+
+func (s *recordingSink) Chtimes(name string, _, mtime time.Time) error {
+	s.calls = append(s.calls, fmt.Sprintf("Chtimes %s %d", name, mtime.Unix()))
+	return nil
+}
+
+func (s *recordingSink) Chown(name string, uid, gid int) error {
+	s.calls = append(s.calls, fmt.Sprintf("Chown %s %d:%d", name, uid, gid))
+	return nil
+}
+
+func (s *recordingSink) Rename(oldname, newname string) error {
+	s.calls = append(s.calls, fmt.Sprintf("Rename %s %s", oldname, newname))
+	return nil
+}
+
+func (s *recordingSink) RemoveAll(path string) error {
+	s.calls = append(s.calls, "RemoveAll "+path)
+	return nil
+}
+
+func TestUntarToDirectory_ExtractionSink(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0700, ModTime: mtime}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts", Mode: 0640, ModTime: mtime, Uid: 1, Gid: 2}, content: "127.0.0.1"},
+	)
+
+	sink := &recordingSink{contents: make(map[string]*bytes.Buffer)}
+	fs := afero.NewMemMapFs()
+	extractor := NewExtractor(
+		WithExtractionSink(sink),
+		WithDirectoryModes(true),
+		WithFileTimes(true),
+		WithOwnership(true),
+	)
+	extractor.fs = fs
+	require.NoError(t, extractor.Untar(reader, "/dst"))
+
+	assert.Equal(t, []string{
+		"MkdirAll /dst 755",
+		"MkdirAll /dst/etc 755",
+		"Chown /dst/etc 0:0",
+		"CreateFile /dst/etc/hosts 640",
+		"Close /dst/etc/hosts",
+		"Chtimes /dst/etc/hosts 1700000000",
+		"Chown /dst/etc/hosts 1:2",
+		"Chmod /dst/etc 700",
+	}, sink.calls)
+	assert.Equal(t, "127.0.0.1", sink.contents["/dst/etc/hosts"].String())
+
+	// nothing is written to the filesystem itself
+	exists, err := afero.Exists(fs, "/dst")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestUntarToDirectory_ExtractionSinkSync(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	newReader := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "hosts", Mode: 0640, ModTime: mtime}, content: "127.0.0.1"},
+		)
+	}
+	// staged files are named randomly
+	staged := regexp.MustCompile(`\.sync-hosts-\d+`)
+
+	tests := []struct {
+		name     string
+		existing string
+		want     []string
+	}{
+		{
+			name: "changed content is moved into place",
+			want: []string{
+				"CreateFile /dst/.sync-hosts 600",
+				"Close /dst/.sync-hosts",
+				"Chmod /dst/.sync-hosts 640",
+				"Rename /dst/.sync-hosts /dst/hosts",
+				"Chtimes /dst/hosts 1700000000",
+			},
+		},
+		{
+			name:     "unchanged content is discarded",
+			existing: "127.0.0.1",
+			want: []string{
+				"CreateFile /dst/.sync-hosts 600",
+				"Close /dst/.sync-hosts",
+				"RemoveAll /dst/.sync-hosts",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			if tt.existing != "" {
+				require.NoError(t, afero.WriteFile(fs, "/dst/hosts", []byte(tt.existing), 0640))
+			}
+			sink := &recordingSink{contents: make(map[string]*bytes.Buffer)}
+			extractor := NewExtractor(WithExtractionSink(sink), WithSyncAgainst("/dst", true, false))
+			extractor.fs = fs
+			require.NoError(t, extractor.Untar(newReader(), "/dst"))
+
+			var calls []string
+			for _, call := range sink.calls {
+				if !strings.HasPrefix(call, "MkdirAll") {
+					calls = append(calls, staged.ReplaceAllString(call, ".sync-hosts"))
+				}
+			}
+			assert.Equal(t, tt.want, calls)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+const copyBufferSize = 32 * KB
+
+var defaultExtractor = NewExtractor()
+
+// Extractor writes the contents of tar archives to directories using a shared configuration, reusing copy buffers
+// across extractions to amortize allocation when processing many archives. An Extractor is safe for concurrent use.
+type Extractor struct {
+	fs      afero.Fs
+	options UntarOptions
+	buffers *sync.Pool
+}
+
+// NewExtractor creates an Extractor configured with the given options.
+func NewExtractor(options ...Option) *Extractor {
+	return &Extractor{
+		fs:      afero.NewOsFs(),
+		options: newUntarOptions(options...),
+		buffers: &sync.Pool{
+			New: func() any {
+				buf := make([]byte, copyBufferSize)
+				return &buf
+			},
+		},
+	}
+}
+
+// Untar writes the contents of the given tar reader to the given destination (see UntarToDirectory).
+func (e *Extractor) Untar(reader io.Reader, dst string) error {
+	return e.untar(reader, e.newVisitor(dst))
+}
+
+// UntarWithResult writes the contents of the given tar reader to the given destination (see UntarToDirectory),
+// returning a summary of what was extracted. The summary reflects the entries read so far when failing partway
+// through the archive.
+func (e *Extractor) UntarWithResult(reader io.Reader, dst string) (UntarResult, error) {
+	v := e.newVisitor(dst)
+	v.result = &UntarResult{}
+	if e.options.CollectStats {
+		start := time.Now()
+		v.result.Stats = &ExtractStats{}
+		defer func() {
+			v.result.Stats.Duration = time.Since(start)
+		}()
+	}
+	if !e.options.IncludeHeaderOverhead {
+		err := e.untar(reader, v)
+		return *v.result, err
+	}
+
+	counter := &countingReader{reader: reader}
+	err := e.untar(counter, v)
+	if err == nil && !v.stoppedEarly() {
+		// the archive may be padded beyond the end-of-archive marker (e.g. to a multiple of the tar record size)
+		_, err = io.Copy(io.Discard, counter)
+	}
+	v.result.Size = counter.count
+	return *v.result, err
+}
+
+func (e *Extractor) untar(reader io.Reader, v tarVisitor) error {
+	v.trackCreated(v.destination)
+	return v.cleanUpAfter(e.extract(reader, v))
+}
+
+func (e *Extractor) extract(reader io.Reader, v tarVisitor) error {
+	if err := e.prepareDestination(v.destination); err != nil {
+		return err
+	}
+	if err := iterateTar(reader, v.visit, e.options); err != nil {
+		return err
+	}
+	// removing unsynced paths modifies their parent directories, so this must happen before directory headers are applied
+	if err := v.removeUnsynced(); err != nil {
+		return err
+	}
+	return v.applyDirectoryHeaders()
+}
+
+// prepareDestination creates the destination directory if it does not exist (mirroring "tar -x"), failing with
+// ErrDestinationNotDirectory if it exists as anything other than a directory.
+func (e *Extractor) prepareDestination(dst string) error {
+	info, err := e.fs.Stat(dst)
+	switch {
+	case os.IsNotExist(err):
+		if err := e.options.sink(e.fs).MkdirAll(dst, 0755); err != nil {
+			return fmt.Errorf("unable to create destination: %w", err)
+		}
+		return nil
+	case err != nil:
+		return fmt.Errorf("unable to stat destination: %w", err)
+	case !info.IsDir():
+		return &ErrDestinationNotDirectory{Path: dst}
+	}
+	return nil
+}
+
+func (e *Extractor) newVisitor(dst string) tarVisitor {
+	v := newTarVisitor(e.fs, dst, e.options)
+	v.buffers = e.buffers
+	return v
+}
@@ -0,0 +1,122 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractor_Untar(t *testing.T) {
+	extractor := NewExtractor(WithNoOverwrite(true))
+
+	for _, name := range []string{"first", "second"} {
+		dst := t.TempDir()
+		reader := newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file.txt"}, content: name})
+		require.NoError(t, extractor.Untar(reader, dst))
+
+		content, err := os.ReadFile(filepath.Join(dst, "file.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, name, string(content))
+
+		// the shared options apply to every extraction
+		reader = newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file.txt"}, content: "again"})
+		var overwrite *ErrWouldOverwrite
+		require.ErrorAs(t, extractor.Untar(reader, dst), &overwrite)
+	}
+}
+
+func TestExtractor_ConcurrentDigestManifest(t *testing.T) {
+	manifest := make(map[string]string)
+	extractor := NewExtractor(WithDigestManifest(manifest))
+
+	var readers []io.Reader
+	for i := 0; i < 8; i++ {
+		var entries []testTarEntry
+		for j := 0; j < 20; j++ {
+			entries = append(entries, testTarEntry{
+				header:  tar.Header{Typeflag: tar.TypeReg, Name: fmt.Sprintf("file-%d-%d", i, j)},
+				content: "content",
+			})
+		}
+		readers = append(readers, newTestTar(t, entries...))
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(readers))
+	for i, reader := range readers {
+		dst := t.TempDir()
+		wg.Add(1)
+		go func(i int, reader io.Reader) {
+			defer wg.Done()
+			errs[i] = extractor.Untar(reader, dst)
+		}(i, reader)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	assert.Len(t, manifest, 8*20)
+}
+
+func benchmarkSmallTars(b *testing.B) [][]byte {
+	b.Helper()
+	var archives [][]byte
+	for i := 0; i < 50; i++ {
+		var entries []testTarEntry
+		for j := 0; j < 5; j++ {
+			entries = append(entries, testTarEntry{
+				header:  tar.Header{Typeflag: tar.TypeReg, Name: strings.Repeat("f", j+1)},
+				content: strings.Repeat("x", 1024),
+			})
+		}
+		reader := newTestTar(b, entries...)
+		archive := make([]byte, reader.Len())
+		_, err := reader.Read(archive)
+		require.NoError(b, err)
+		archives = append(archives, archive)
+	}
+	return archives
+}
+
+func BenchmarkExtractor_Untar(b *testing.B) {
+	archives := benchmarkSmallTars(b)
+	extractor := NewExtractor(WithNoOverwrite(false))
+	dst := b.TempDir()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, archive := range archives {
+			if err := extractor.Untar(bytes.NewReader(archive), dst); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkUntarToDirectory(b *testing.B) {
+	archives := benchmarkSmallTars(b)
+	dst := b.TempDir()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, archive := range archives {
+			if err := UntarToDirectory(bytes.NewReader(archive), dst, WithNoOverwrite(false)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package file
+
+import "io"
+
+const tarBlockSize = 512
+
+// headerRecorder retains the last tar block worth of bytes read from the underlying reader. Immediately after
+// tar.Reader.Next returns, this is the raw (ustar) header block of the entry, which allows for checking fields that
+// the stdlib has already resolved against PAX records.
+type headerRecorder struct {
+	reader io.Reader
+	last   [tarBlockSize]byte
+	filled int
+}
+
+// seekingHeaderRecorder is a headerRecorder that preserves the io.Seeker of the underlying reader, which keeps the
+// tar reader's fast path for skipping content.
+type seekingHeaderRecorder struct {
+	*headerRecorder
+	io.Seeker
+}
+
+// newHeaderRecorder wraps the given reader, returning the reader to use in place of it and the recorder.
+func newHeaderRecorder(reader io.Reader) (io.Reader, *headerRecorder) {
+	recorder := &headerRecorder{reader: reader}
+	if seeker, ok := reader.(io.Seeker); ok {
+		return seekingHeaderRecorder{headerRecorder: recorder, Seeker: seeker}, recorder
+	}
+	return recorder, recorder
+}
+
+func (h *headerRecorder) Read(p []byte) (int, error) {
+	n, err := h.reader.Read(p)
+	h.record(p[:n])
+	return n, err
+}
+
+func (h *headerRecorder) record(p []byte) {
+	if len(p) >= tarBlockSize {
+		copy(h.last[:], p[len(p)-tarBlockSize:])
+		h.filled = tarBlockSize
+		return
+	}
+	// shift existing bytes to make room for the new ones
+	keep := tarBlockSize - len(p)
+	if keep > h.filled {
+		keep = h.filled
+	}
+	copy(h.last[tarBlockSize-len(p)-keep:], h.last[tarBlockSize-keep:])
+	copy(h.last[tarBlockSize-len(p):], p)
+	h.filled = keep + len(p)
+}
+
+// lastBlock returns the last full block read, or nil if less than a block has been read.
+func (h *headerRecorder) lastBlock() []byte {
+	if h.filled < tarBlockSize {
+		return nil
+	}
+	return h.last[:]
+}
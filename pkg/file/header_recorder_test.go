@@ -0,0 +1,43 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_headerRecorder(t *testing.T) {
+	content := make([]byte, 3*tarBlockSize+100)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	for _, chunk := range []int{1, 7, tarBlockSize, 1000} {
+		reader, recorder := newHeaderRecorder(bytes.NewReader(content))
+		_, isSeeker := reader.(io.Seeker)
+		assert.True(t, isSeeker, "seeker should be preserved")
+
+		buf := make([]byte, chunk)
+		var total int
+		for {
+			n, err := reader.Read(buf)
+			total += n
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+		}
+		assert.Equal(t, len(content), total)
+		assert.Equal(t, content[len(content)-tarBlockSize:], recorder.lastBlock(), "chunk=%d", chunk)
+	}
+}
+
+func Test_headerRecorder_short(t *testing.T) {
+	reader, recorder := newHeaderRecorder(io.MultiReader(bytes.NewReader([]byte("short"))))
+	_, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Nil(t, recorder.lastBlock())
+}
@@ -0,0 +1,41 @@
+package file
+
+import "fmt"
+
+// IDMapEntry maps a contiguous range of user or group IDs within an archive onto a range of IDs on the host, as
+// with /etc/subuid and /etc/subgid ranges or the uid_map of a user namespace.
+type IDMapEntry struct {
+	// ContainerID is the first ID of the range within the archive.
+	ContainerID int
+	// HostID is the first ID of the range on the host that ContainerID maps to.
+	HostID int
+	// Size is the number of IDs in the range.
+	Size int
+}
+
+// ErrUnmappedID is returned during extraction (when ownership is preserved with an ID mapping) for entries owned by
+// an ID that is not within any range of the mapping, unless unmapped IDs are mapped to an overflow ID.
+type ErrUnmappedID struct {
+	Name string
+	// Kind is either "uid" or "gid".
+	Kind string
+	ID   int
+}
+
+func (e *ErrUnmappedID) Error() string {
+	return fmt.Sprintf("unmapped %s=%d for tar entry=%q", e.Kind, e.ID, e.Name)
+}
+
+// mapID translates the given ID through the given mapping, where an empty mapping is the identity. This is false if
+// the ID is not within any range of the mapping.
+func mapID(mapping []IDMapEntry, id int) (int, bool) {
+	if len(mapping) == 0 {
+		return id, true
+	}
+	for _, m := range mapping {
+		if id >= m.ContainerID && id-m.ContainerID < m.Size {
+			return m.HostID + id - m.ContainerID, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,127 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chownRecordingFs records the owner given to each path.
+type chownRecordingFs struct {
+	afero.Fs
+	owners map[string][2]int
+}
+
+func (c *chownRecordingFs) Chown(name string, uid, gid int) error {
+	c.owners[name] = [2]int{uid, gid}
+	return c.Fs.Chown(name, uid, gid)
+}
+
+func Test_mapID(t *testing.T) {
+	mapping := []IDMapEntry{
+		{ContainerID: 0, HostID: 100000, Size: 1000},
+		{ContainerID: 65534, HostID: 200000, Size: 1},
+	}
+	tests := []struct {
+		name    string
+		mapping []IDMapEntry
+		id      int
+		want    int
+		wantOk  bool
+	}{
+		{name: "identity without a mapping", id: 1000, want: 1000, wantOk: true},
+		{name: "start of range", mapping: mapping, id: 0, want: 100000, wantOk: true},
+		{name: "end of range", mapping: mapping, id: 999, want: 100999, wantOk: true},
+		{name: "past end of range", mapping: mapping, id: 1000},
+		{name: "second range", mapping: mapping, id: 65534, want: 200000, wantOk: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := mapID(tt.mapping, tt.id)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestUntarToDirectory_IDMapping(t *testing.T) {
+	uidMap := []IDMapEntry{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	gidMap := []IDMapEntry{{ContainerID: 0, HostID: 200000, Size: 65536}}
+	tests := []struct {
+		name       string
+		fileUID    int
+		options    []Option
+		wantErr    require.ErrorAssertionFunc
+		wantOwners map[string][2]int
+	}{
+		{
+			name:       "ownership is not preserved by default",
+			options:    []Option{WithIDMapping(uidMap, gidMap)},
+			wantErr:    require.NoError,
+			wantOwners: map[string][2]int{},
+		},
+		{
+			name:    "ownership without a mapping",
+			fileUID: 1000,
+			options: []Option{WithOwnership(true)},
+			wantErr: require.NoError,
+			wantOwners: map[string][2]int{
+				"/dst/dir":      {0, 0},
+				"/dst/dir/file": {1000, 50},
+			},
+		},
+		{
+			name:    "mapped ownership",
+			fileUID: 1000,
+			options: []Option{WithOwnership(true), WithIDMapping(uidMap, gidMap)},
+			wantErr: require.NoError,
+			wantOwners: map[string][2]int{
+				"/dst/dir":      {100000, 200000},
+				"/dst/dir/file": {101000, 200050},
+			},
+		},
+		{
+			name:    "unmapped ids are rejected",
+			fileUID: 70000,
+			options: []Option{WithOwnership(true), WithIDMapping(uidMap, gidMap)},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var unmapped *ErrUnmappedID
+				require.ErrorAs(t, err, &unmapped)
+				assert.Equal(t, &ErrUnmappedID{Name: "dir/file", Kind: "uid", ID: 70000}, unmapped)
+			},
+			wantOwners: map[string][2]int{
+				"/dst/dir": {100000, 200000},
+			},
+		},
+		{
+			name:    "unmapped ids are given the overflow ids",
+			fileUID: 70000,
+			options: []Option{WithOwnership(true), WithIDMapping(uidMap, gidMap), WithOverflowIDs(65534, 65533)},
+			wantErr: require.NoError,
+			wantOwners: map[string][2]int{
+				"/dst/dir":      {100000, 200000},
+				"/dst/dir/file": {65534, 200050},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &chownRecordingFs{Fs: afero.NewMemMapFs(), owners: make(map[string][2]int)}
+			extractor := NewExtractor(tt.options...)
+			extractor.fs = fs
+
+			reader := newTestTar(t,
+				testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "dir/"}},
+				testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "dir/file", Uid: tt.fileUID, Gid: 50}, content: "content"},
+			)
+			tt.wantErr(t, extractor.Untar(reader, "/dst"))
+			assert.Equal(t, tt.wantOwners, fs.owners)
+		})
+	}
+}
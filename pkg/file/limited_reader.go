@@ -0,0 +1,45 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// ErrReadLimitExceeded is returned from a LimitedReader once the underlying reader has more content than allowed.
+var ErrReadLimitExceeded = fmt.Errorf("read limit exceeded")
+
+var _ io.Reader = (*LimitedReader)(nil)
+
+// LimitedReader reads from R but fails with ErrReadLimitExceeded once more than N bytes are available, unlike
+// io.LimitedReader which silently truncates. This is useful for guarding against decompression bomb attacks.
+type LimitedReader struct {
+	R io.Reader
+	// N is the number of bytes remaining before the limit is exceeded
+	N int64
+}
+
+// NewLimitedReader returns a LimitedReader allowing at most limit bytes to be read from r.
+func NewLimitedReader(r io.Reader, limit int64) *LimitedReader {
+	return &LimitedReader{R: r, N: limit}
+}
+
+func (l *LimitedReader) Read(p []byte) (int, error) {
+	if l.N < 0 {
+		return 0, ErrReadLimitExceeded
+	}
+
+	// read one byte beyond the remaining allowance to detect content beyond the limit
+	// (as long as that can be done without overflowing, beyond which the limit cannot be reached anyway)
+	if l.N < math.MaxInt64 && int64(len(p)) > l.N+1 {
+		p = p[:l.N+1]
+	}
+	n, err := l.R.Read(p)
+	if int64(n) > l.N {
+		n = int(l.N)
+		l.N = -1
+		return n, ErrReadLimitExceeded
+	}
+	l.N -= int64(n)
+	return n, err
+}
@@ -0,0 +1,78 @@
+package file
+
+import (
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitedReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		limit   int64
+		want    string
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "under the limit",
+			content: "hello",
+			limit:   10,
+			want:    "hello",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "maximum limit",
+			content: "hello",
+			limit:   math.MaxInt64,
+			want:    "hello",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "exactly at the limit",
+			content: "hello",
+			limit:   5,
+			want:    "hello",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "over the limit",
+			content: "hello world",
+			limit:   5,
+			want:    "hello",
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				require.ErrorIs(t, err, ErrReadLimitExceeded)
+			},
+		},
+		{
+			name:    "zero limit with content",
+			content: "x",
+			limit:   0,
+			want:    "",
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				require.ErrorIs(t, err, ErrReadLimitExceeded)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := io.ReadAll(NewLimitedReader(strings.NewReader(tt.content), tt.limit))
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestLimitedReader_StaysFailed(t *testing.T) {
+	r := NewLimitedReader(strings.NewReader("hello world"), 2)
+	_, err := io.ReadAll(r)
+	require.ErrorIs(t, err, ErrReadLimitExceeded)
+
+	n, err := r.Read(make([]byte, 10))
+	assert.Zero(t, n)
+	require.ErrorIs(t, err, ErrReadLimitExceeded)
+}
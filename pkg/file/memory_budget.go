@@ -0,0 +1,87 @@
+package file
+
+import "sync"
+
+// MemoryBudget bounds the total bytes buffered in memory across all operations configured with it (see
+// WithMemoryBudget), such as many concurrent extractions on a server. Operations that can fall back to disk do so
+// when the budget is exhausted, while all others block until memory is released. A nil budget does not limit, while
+// the zero value is a budget of zero bytes (see NewMemoryBudget).
+//
+// The budget covers the copy buffers used while extracting, and the entry content held by ConcatTarEntries,
+// ReadersFromTar, RewriteTar, NormalizeTar, SquashLayers, and TransformTar. It does not cover the content cache of a
+// TarIndex (which is bounded on its own by WithContentCache), the tree built by TarToFS (which may be bounded with
+// WithExtractBudget), the leading bytes held while detecting content types (see WithContentTypeDetection), or headers
+// and other metadata.
+type MemoryBudget struct {
+	lock sync.Mutex
+	// released is signaled whenever bytes are released, using lock (which is set when first waiting, so that the zero
+	// value is usable)
+	released sync.Cond
+	max      int64
+	used     int64
+}
+
+// NewMemoryBudget creates a budget allowing at most maxBytes to be buffered in memory at once.
+func NewMemoryBudget(maxBytes int64) *MemoryBudget {
+	return &MemoryBudget{max: maxBytes}
+}
+
+// InUse returns the number of bytes currently acquired from the budget.
+func (b *MemoryBudget) InUse() int64 {
+	if b == nil {
+		return 0
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.used
+}
+
+// tryAcquire acquires n bytes if they are available without blocking, returning false otherwise (always for a nil
+// budget, as there is no budget to spend).
+func (b *MemoryBudget) tryAcquire(n int64) bool {
+	if b == nil {
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.used+n > b.max {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// acquire blocks until n bytes are available. Requests larger than the entire budget are capped to the budget so
+// that they can eventually be satisfied.
+func (b *MemoryBudget) acquire(n int64) {
+	if b == nil {
+		return
+	}
+	n = b.capped(n)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for b.used+n > b.max {
+		b.released.L = &b.lock
+		b.released.Wait()
+	}
+	b.used += n
+}
+
+// release returns n bytes previously acquired (with acquire or tryAcquire) to the budget.
+func (b *MemoryBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+	n = b.capped(n)
+	b.lock.Lock()
+	b.used -= n
+	b.lock.Unlock()
+	b.released.Broadcast()
+}
+
+func (b *MemoryBudget) capped(n int64) int64 {
+	if n > b.max {
+		return b.max
+	}
+	return n
+}
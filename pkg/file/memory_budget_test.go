@@ -0,0 +1,66 @@
+package file
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	b := NewMemoryBudget(100)
+
+	assert.True(t, b.tryAcquire(60))
+	assert.False(t, b.tryAcquire(60), "should not exceed the budget")
+	assert.Equal(t, int64(60), b.InUse())
+
+	acquired := make(chan struct{})
+	go func() {
+		b.acquire(60)
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquire should block while the budget is exhausted")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	b.release(60)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("acquire should proceed once memory is released")
+	}
+	assert.Equal(t, int64(60), b.InUse())
+
+	b.release(60)
+	assert.Equal(t, int64(0), b.InUse())
+}
+
+func TestMemoryBudget_oversizedRequestsAreCapped(t *testing.T) {
+	b := NewMemoryBudget(10)
+	// must not block forever
+	b.acquire(1000)
+	assert.Equal(t, int64(10), b.InUse())
+	b.release(1000)
+	assert.Equal(t, int64(0), b.InUse())
+}
+
+func TestMemoryBudget_nilDoesNotLimit(t *testing.T) {
+	var b *MemoryBudget
+	// must not block
+	b.acquire(1000)
+	b.release(1000)
+	assert.False(t, b.tryAcquire(1), "there is nothing to spend in a nil budget")
+	assert.Equal(t, int64(0), b.InUse())
+}
+
+func TestMemoryBudget_zeroValue(t *testing.T) {
+	b := &MemoryBudget{}
+	assert.False(t, b.tryAcquire(1), "there is nothing to spend in a zero budget")
+	// must not block or panic
+	b.acquire(1000)
+	b.release(1000)
+	assert.Equal(t, int64(0), b.InUse())
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package file
+
+func mmapSection(string, int64, int64) (*mmapReadCloser, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmap([]byte) error {
+	return errMmapUnsupported
+}
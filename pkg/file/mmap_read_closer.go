@@ -0,0 +1,38 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+var _ io.ReadCloser = (*mmapReadCloser)(nil)
+var _ io.ReaderAt = (*mmapReadCloser)(nil)
+var _ io.Seeker = (*mmapReadCloser)(nil)
+
+var errMmapUnsupported = errors.New("memory mapping is not supported on this platform")
+
+// mmapReadCloser reads a section of a file through a read-only memory mapping, which is unmapped on Close.
+type mmapReadCloser struct {
+	*bytes.Reader
+	// mapping is the entire mapped region, which may start before the section being read (for page alignment)
+	mapping []byte
+}
+
+func newMmapReadCloser(mapping []byte, offset, size int64) *mmapReadCloser {
+	return &mmapReadCloser{
+		Reader:  bytes.NewReader(mapping[offset : offset+size]),
+		mapping: mapping,
+	}
+}
+
+func (m *mmapReadCloser) Close() error {
+	if m.mapping == nil {
+		return nil
+	}
+	err := munmap(m.mapping)
+	m.mapping = nil
+	// any further reads must not touch the unmapped memory
+	m.Reader = bytes.NewReader(nil)
+	return err
+}
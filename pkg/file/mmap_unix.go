@@ -0,0 +1,38 @@
+//go:build unix
+
+package file
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"syscall"
+)
+
+// mmapSection memory maps size bytes of the file at the given path, starting at offset.
+func mmapSection(path string, offset, size int64) (*mmapReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// the mapping remains valid after the file is closed
+	defer f.Close()
+
+	// mappings must start at a page boundary
+	pageSize := int64(os.Getpagesize())
+	aligned := offset - offset%pageSize
+	delta := offset - aligned
+	if size+delta > math.MaxInt {
+		return nil, fmt.Errorf("unable to mmap %q: section too large (size=%d)", path, size)
+	}
+
+	mapping, err := syscall.Mmap(int(f.Fd()), aligned, int(size+delta), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("unable to mmap %q: %w", path, err)
+	}
+	return newMmapReadCloser(mapping, delta, size), nil
+}
+
+func munmap(mapping []byte) error {
+	return syscall.Munmap(mapping)
+}
@@ -0,0 +1,50 @@
+package file
+
+import "sync"
+
+// multiAcquire serializes acquiring several slots at once (across all limiters), so that concurrent acquisitions cannot
+// each hold part of what the other needs. Slots are released without it.
+var multiAcquire sync.Mutex
+
+// openFileLimiter is a semaphore bounding the number of files that may be open at once. A nil limiter does not limit.
+type openFileLimiter chan struct{}
+
+func newOpenFileLimiter(n int) openFileLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(openFileLimiter, n)
+}
+
+func (l openFileLimiter) acquire() {
+	if l != nil {
+		l <- struct{}{}
+	}
+}
+
+func (l openFileLimiter) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// acquireN acquires n slots at once, returning the number acquired (to be given to releaseN), which is at most the
+// bound of the limiter.
+func (l openFileLimiter) acquireN(n int) int {
+	if l == nil {
+		return 0
+	}
+	n = min(n, cap(l))
+	multiAcquire.Lock()
+	defer multiAcquire.Unlock()
+	for i := 0; i < n; i++ {
+		l <- struct{}{}
+	}
+	return n
+}
+
+func (l openFileLimiter) releaseN(n int) {
+	for i := 0; i < n; i++ {
+		l.release()
+	}
+}
@@ -0,0 +1,48 @@
+package file
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_openFileLimiter(t *testing.T) {
+	const limit = 3
+	l := newOpenFileLimiter(limit)
+
+	var current, highest int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.acquire()
+			defer l.release()
+
+			n := atomic.AddInt32(&current, 1)
+			for {
+				h := atomic.LoadInt32(&highest)
+				if n <= h || atomic.CompareAndSwapInt32(&highest, h, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&current, -1)
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, highest, int32(limit))
+}
+
+func Test_openFileLimiter_nilDoesNotLimit(t *testing.T) {
+	l := newOpenFileLimiter(0)
+	assert.Nil(t, l)
+	// must not block
+	l.acquire()
+	l.acquire()
+	l.release()
+}
@@ -12,6 +12,36 @@ const (
 	DirSeparator   = "/"
 )
 
+// WhiteoutKind classifies names by the OCI (overlayfs-style) whiteout naming rules used within layer tars.
+type WhiteoutKind int
+
+const (
+	// WhiteoutNone is a regular name, which is not a whiteout.
+	WhiteoutNone WhiteoutKind = iota
+	// WhiteoutFile (".wh.<name>") removes <name> (and everything beneath it) contributed by lower layers.
+	WhiteoutFile
+	// WhiteoutOpaque (".wh..wh..opq") removes all contents contributed by lower layers to the directory it is within.
+	WhiteoutOpaque
+)
+
+// ClassifyWhiteout returns the kind of whiteout the given (tar entry) name is, along with the path it applies to: the
+// removed path for WhiteoutFile, the directory whose lower contents are removed for WhiteoutOpaque ("." or "/" for the
+// root), or the cleaned name itself for WhiteoutNone.
+func ClassifyWhiteout(name string) (string, WhiteoutKind) {
+	cleaned := path.Clean(name)
+	dir, basename := path.Split(cleaned)
+	switch {
+	case basename == OpaqueWhiteout:
+		return path.Clean(dir), WhiteoutOpaque
+	case strings.HasPrefix(basename, WhiteoutPrefix):
+		// the removed name must be a name within the directory, not the directory itself or its parent
+		if removed := strings.TrimPrefix(basename, WhiteoutPrefix); removed != "" && removed != "." && removed != ".." {
+			return path.Join(dir, removed), WhiteoutFile
+		}
+	}
+	return cleaned, WhiteoutNone
+}
+
 // Path represents a file path
 type Path string
 
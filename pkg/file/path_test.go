@@ -155,3 +155,85 @@ func TestPath_UnWhiteoutPath(t *testing.T) {
 		t.Fatal("path should be a whiteout")
 	}
 }
+
+func TestClassifyWhiteout(t *testing.T) {
+	cases := []struct {
+		name         string
+		entry        string
+		expectedBase string
+		expectedKind WhiteoutKind
+	}{
+		{
+			name:         "regular file",
+			entry:        "etc/passwd",
+			expectedBase: "etc/passwd",
+			expectedKind: WhiteoutNone,
+		},
+		{
+			name:         "file whiteout",
+			entry:        "etc/.wh.passwd",
+			expectedBase: "etc/passwd",
+			expectedKind: WhiteoutFile,
+		},
+		{
+			name:         "file whiteout with dot prefix",
+			entry:        "./etc/.wh.passwd",
+			expectedBase: "etc/passwd",
+			expectedKind: WhiteoutFile,
+		},
+		{
+			name:         "absolute file whiteout",
+			entry:        "/etc/.wh.passwd",
+			expectedBase: "/etc/passwd",
+			expectedKind: WhiteoutFile,
+		},
+		{
+			name:         "opaque whiteout",
+			entry:        "var/cache/.wh..wh..opq",
+			expectedBase: "var/cache",
+			expectedKind: WhiteoutOpaque,
+		},
+		{
+			name:         "opaque whiteout at the root",
+			entry:        ".wh..wh..opq",
+			expectedBase: ".",
+			expectedKind: WhiteoutOpaque,
+		},
+		{
+			name:         "whiteout prefix within a parent is not a whiteout",
+			entry:        ".wh.etc/passwd",
+			expectedBase: ".wh.etc/passwd",
+			expectedKind: WhiteoutNone,
+		},
+		{
+			name:         "bare whiteout prefix is not a whiteout",
+			entry:        "etc/.wh.",
+			expectedBase: "etc/.wh.",
+			expectedKind: WhiteoutNone,
+		},
+		{
+			name:         "whiteout of the directory itself is not a whiteout",
+			entry:        "etc/.wh..",
+			expectedBase: "etc/.wh..",
+			expectedKind: WhiteoutNone,
+		},
+		{
+			name:         "whiteout of the parent directory is not a whiteout",
+			entry:        "etc/.wh...",
+			expectedBase: "etc/.wh...",
+			expectedKind: WhiteoutNone,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			base, kind := ClassifyWhiteout(c.entry)
+			if kind != c.expectedKind {
+				t.Errorf("unexpected kind ('%v' != '%v')", kind, c.expectedKind)
+			}
+			if base != c.expectedBase {
+				t.Errorf("unexpected base ('%v' != '%v')", base, c.expectedBase)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/anchore/stereoscope/internal/log"
+)
+
+// retryableTarSource is a tar stream supporting random access, which allows for reading again from any offset.
+type retryableTarSource interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// retryingTarSource re-reads a tar stream from the offset reached when a read fails transiently (such as a dropped
+// connection to a network-backed source), so that neither the tar reader nor visitors see errors resolved by retrying.
+type retryingTarSource struct {
+	source   retryableTarSource
+	offset   int64
+	attempts int
+	backoff  time.Duration
+}
+
+// newRetryingTarSource wraps the given tar stream to retry transiently failing reads as configured, returning the
+// stream as-is when retries are disabled or the stream does not support random access.
+func newRetryingTarSource(reader io.Reader, cfg UntarOptions) io.Reader {
+	if cfg.ReadRetryAttempts <= 1 {
+		return reader
+	}
+	source, ok := reader.(retryableTarSource)
+	if !ok {
+		log.Debug("read retries require a tar stream implementing io.ReaderAt and io.Seeker, reading without retries")
+		return reader
+	}
+	offset, err := source.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return reader
+	}
+	return &retryingTarSource{
+		source:   source,
+		offset:   offset,
+		attempts: cfg.ReadRetryAttempts,
+		backoff:  cfg.ReadRetryBackoff,
+	}
+}
+
+// Read reads from the stream, retrying from the current offset when failing transiently until the attempts are used
+// up. The wait between attempts starts at the backoff and doubles after each attempt.
+func (s *retryingTarSource) Read(p []byte) (int, error) {
+	n, err := s.source.Read(p)
+	s.offset += int64(n)
+	if err == nil || !isTransientReadError(err) {
+		return n, err
+	}
+
+	wait := s.backoff
+	for attempt := 1; attempt < s.attempts && err != nil && isTransientReadError(err); attempt++ {
+		log.WithFields("offset", s.offset, "attempt", attempt, "error", err).Trace("retrying transient read error")
+		time.Sleep(wait)
+		wait *= 2
+
+		var read int
+		read, err = s.source.ReadAt(p[n:], s.offset)
+		n += read
+		s.offset += int64(read)
+	}
+	// the position of the stream is unknown after a failed read, so it is moved to just after the bytes returned
+	if _, seekErr := s.source.Seek(s.offset, io.SeekStart); seekErr != nil && err == nil {
+		err = seekErr
+	}
+	return n, err
+}
+
+func (s *retryingTarSource) Seek(offset int64, whence int) (int64, error) {
+	pos, err := s.source.Seek(offset, whence)
+	if err == nil {
+		s.offset = pos
+	}
+	return pos, err
+}
+
+// isTransientReadError reports whether the given error from reading a tar stream may succeed when retried, which is
+// limited to interrupted, unavailable, reset, and timed out reads (as with isTransientWriteError). Reaching the end
+// of the stream, reading from a closed stream, being denied permission, cancellation, and running past the deadline
+// are never resolved by retrying.
+func isTransientReadError(err error) bool {
+	var deadlineErr *ErrDeadlineExceeded
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, os.ErrClosed),
+		errors.Is(err, fs.ErrPermission), errors.Is(err, context.Canceled), errors.As(err, &deadlineErr):
+		return false
+	case isTransientWriteError(err), errors.Is(err, syscall.ECONNRESET):
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,192 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errConnectionReset = fmt.Errorf("read tcp: %w", syscall.ECONNRESET)
+
+// flakyTarSource fails the first reads crossing the given offset (after reading up to it), along with the first reads
+// at an offset, as a network-backed source would when its connection drops.
+type flakyTarSource struct {
+	*bytes.Reader
+	failAt     int64
+	readErrs   int
+	readAtErrs int
+	readAts    int
+	// err is the error reads fail with, defaulting to errConnectionReset
+	err error
+}
+
+func (s *flakyTarSource) readErr() error {
+	if s.err != nil {
+		return s.err
+	}
+	return errConnectionReset
+}
+
+func (s *flakyTarSource) Read(p []byte) (int, error) {
+	pos := s.Size() - int64(s.Len())
+	if s.readErrs > 0 && pos <= s.failAt && pos+int64(len(p)) > s.failAt {
+		s.readErrs--
+		n, _ := s.Reader.Read(p[:s.failAt-pos])
+		// the position of a stream is unreliable after it fails
+		_, _ = s.Reader.Seek(0, io.SeekStart)
+		return n, s.readErr()
+	}
+	return s.Reader.Read(p)
+}
+
+func (s *flakyTarSource) ReadAt(p []byte, off int64) (int, error) {
+	s.readAts++
+	if s.readAtErrs > 0 {
+		s.readAtErrs--
+		return 0, s.readErr()
+	}
+	return s.Reader.ReadAt(p, off)
+}
+
+func TestIterateTar_ReadRetry(t *testing.T) {
+	archive, err := io.ReadAll(newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "first"}, content: strings.Repeat("a", 600)},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "second"}, content: "second"},
+	))
+	require.NoError(t, err)
+	// content of the first entry starts after its header, and the header of the second entry after the padded content
+	const firstContent, secondHeader = tarBlockSize + 100, 3*tarBlockSize + 100
+
+	tests := []struct {
+		name string
+		// source returns the stream to iterate, given the flaky source of the archive
+		source      func(*flakyTarSource) io.Reader
+		failAt      int64
+		readAtErrs  int
+		err         error
+		options     []Option
+		wantReadAts int
+		wantErr     require.ErrorAssertionFunc
+	}{
+		{
+			name:    "fails without retries",
+			failAt:  firstContent,
+			wantErr: require.Error,
+		},
+		{
+			name:        "retries reading content",
+			failAt:      firstContent,
+			options:     []Option{WithReadRetry(3, 0)},
+			wantReadAts: 1,
+			wantErr:     require.NoError,
+		},
+		{
+			name:        "retries reading a header",
+			failAt:      secondHeader,
+			options:     []Option{WithReadRetry(3, 0)},
+			wantReadAts: 1,
+			wantErr:     require.NoError,
+		},
+		{
+			name:        "attempts are bounded",
+			failAt:      firstContent,
+			readAtErrs:  5,
+			options:     []Option{WithReadRetry(3, 0)},
+			wantReadAts: 2,
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				require.ErrorIs(t, err, errConnectionReset)
+			},
+		},
+		{
+			name:    "permanent errors are not retried",
+			failAt:  firstContent,
+			err:     fs.ErrPermission,
+			options: []Option{WithReadRetry(3, 0)},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				require.ErrorIs(t, err, fs.ErrPermission)
+			},
+		},
+		{
+			name: "streams without random access are not retried",
+			source: func(s *flakyTarSource) io.Reader {
+				return struct{ io.Reader }{s}
+			},
+			failAt:  firstContent,
+			options: []Option{WithReadRetry(3, 0)},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				require.ErrorIs(t, err, errConnectionReset)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &flakyTarSource{Reader: bytes.NewReader(archive), failAt: tt.failAt, readErrs: 1, readAtErrs: tt.readAtErrs, err: tt.err}
+			var reader io.Reader = source
+			if tt.source != nil {
+				reader = tt.source(source)
+			}
+
+			var entries []string
+			err := IterateTar(reader, func(entry TarFileEntry) error {
+				content, err := io.ReadAll(entry.Reader)
+				if err != nil {
+					return err
+				}
+				entries = append(entries, entry.Header.Name+"="+string(content))
+				return nil
+			}, tt.options...)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.wantReadAts, source.readAts)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, []string{"first=" + strings.Repeat("a", 600), "second=second"}, entries)
+		})
+	}
+}
+
+// timeoutError is a net.Error for a timed out read.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func Test_isTransientReadError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "connection reset", err: errConnectionReset, want: true},
+		{name: "interrupted", err: syscall.EINTR, want: true},
+		{name: "unavailable", err: syscall.EAGAIN, want: true},
+		{name: "timeout", err: fmt.Errorf("read: %w", timeoutError{}), want: true},
+		{name: "end of stream", err: io.ErrUnexpectedEOF},
+		{name: "closed", err: os.ErrClosed},
+		{name: "permission", err: fs.ErrPermission},
+		{name: "canceled", err: context.Canceled},
+		{name: "deadline", err: &ErrDeadlineExceeded{Deadline: time.Now()}},
+		{name: "unknown", err: errors.New("bad checksum")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isTransientReadError(tt.err))
+		})
+	}
+}
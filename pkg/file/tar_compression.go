@@ -0,0 +1,228 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// ustarMagic is shared by the POSIX ("ustar\x00") and GNU ("ustar ") header magic fields
+	ustarMagic = []byte("ustar")
+)
+
+// ustarMagicOffset is the offset of the magic field within a tar header block.
+const ustarMagicOffset = 257
+
+// formats reported by DetectFormat
+const (
+	FormatGzip    = "gzip"
+	FormatBzip2   = "bzip2"
+	FormatXz      = "xz"
+	FormatZstd    = "zstd"
+	FormatTar     = "tar"
+	FormatUnknown = "unknown"
+)
+
+// decompressingReadCloser presents the decompressed view of a (possibly) compressed stream. Closing it closes both
+// the decompressor and the underlying stream.
+type decompressingReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decompressingReadCloser) Close() error {
+	var err error
+	for _, c := range d.closers {
+		if cErr := c.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+// DetectFormat peeks at the leading bytes of the given stream to identify it as gzip, bzip2, xz, zstd, or an
+// (uncompressed) tar, returning FormatUnknown otherwise. The returned reader must be used in place of the given one,
+// as it re-presents the peeked bytes.
+func DetectFormat(r io.Reader) (string, io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(ustarMagicOffset + len(ustarMagic))
+	if err != nil && err != io.EOF {
+		return "", nil, fmt.Errorf("unable to read format magic: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return FormatGzip, buffered, nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return FormatBzip2, buffered, nil
+	case bytes.HasPrefix(magic, xzMagic):
+		return FormatXz, buffered, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return FormatZstd, buffered, nil
+	case len(magic) > ustarMagicOffset && bytes.HasPrefix(magic[ustarMagicOffset:], ustarMagic):
+		return FormatTar, buffered, nil
+	}
+	return FormatUnknown, buffered, nil
+}
+
+// IsTar peeks at the first header block of the given stream to determine whether it is an (uncompressed) tar. The
+// returned reader must be used in place of the given one, as it re-presents the peeked bytes. Unlike DetectFormat,
+// this verifies the header checksum rather than the magic, so tars in the original (pre-POSIX) format are recognized
+// while other data that happens to contain the magic is not. A stream starting with a zero block is an empty tar, and
+// streams shorter than a header block are not a tar (rather than failing), so this can be used for dispatching input
+// before iterating it, instead of relying on the errors archive/tar returns for input that is not a tar.
+func IsTar(reader io.Reader) (bool, io.Reader, error) {
+	buffered := bufio.NewReader(reader)
+	block, err := buffered.Peek(tarBlockSize)
+	if err != nil && err != io.EOF {
+		return false, nil, fmt.Errorf("unable to read tar header: %w", err)
+	}
+	if len(block) < tarBlockSize {
+		return false, buffered, nil
+	}
+	return isTarHeaderBlock(block), buffered, nil
+}
+
+// isTarHeaderBlock reports whether the given raw block is a tar header with a valid checksum, or a zero block. As with
+// archive/tar, the checksum may be the sum of the header bytes as either unsigned or signed values.
+func isTarHeaderBlock(block []byte) bool {
+	if bytes.Count(block, []byte{0}) == len(block) {
+		return true
+	}
+	checksum, ok := parseTarNumeric(block[148:156])
+	if !ok {
+		return false
+	}
+	var unsigned, signed int64
+	for i, b := range block {
+		if i >= 148 && i < 156 {
+			// the checksum field itself is summed as spaces
+			b = ' '
+		}
+		unsigned += int64(b)
+		signed += int64(int8(b))
+	}
+	return checksum == unsigned || checksum == signed
+}
+
+// gzipMembersReader reads every member of a gzip stream consisting of several concatenated members (as produced by
+// some tools, e.g. when compressing in parallel). Members are read one at a time so that what follows each member can
+// be checked explicitly: data that is not another gzip member is an error (as with gzip.Reader), or marks the end of
+// the stream when ignoreTrailing is set.
+type gzipMembersReader struct {
+	source         *bufio.Reader
+	gz             *gzip.Reader
+	ignoreTrailing bool
+}
+
+func newGzipMembersReader(source *bufio.Reader, ignoreTrailing bool) (*gzipMembersReader, error) {
+	gz, err := gzip.NewReader(source)
+	if err != nil {
+		return nil, err
+	}
+	gz.Multistream(false)
+	return &gzipMembersReader{source: source, gz: gz, ignoreTrailing: ignoreTrailing}, nil
+}
+
+// Read reads from the current member, advancing through the following members (any number of which may be empty)
+// until content is read or the stream ends.
+func (g *gzipMembersReader) Read(p []byte) (int, error) {
+	for {
+		n, err := g.gz.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		if err := g.nextMember(); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// nextMember advances to the member following the current one, returning io.EOF at the end of the stream.
+func (g *gzipMembersReader) nextMember() error {
+	magic, err := g.source.Peek(len(gzipMagic))
+	switch {
+	case err != nil && err != io.EOF:
+		return err
+	case len(magic) == 0:
+		return io.EOF
+	case !bytes.Equal(magic, gzipMagic):
+		if g.ignoreTrailing {
+			return io.EOF
+		}
+		return fmt.Errorf("unexpected data after gzip member: %w", gzip.ErrHeader)
+	}
+
+	// resetting also restores multistream mode, which must be disabled again
+	if err := g.gz.Reset(g.source); err != nil {
+		return err
+	}
+	g.gz.Multistream(false)
+	return nil
+}
+
+func (g *gzipMembersReader) Close() error {
+	return g.gz.Close()
+}
+
+// newDecompressingReadCloser sniffs the compression format of the given stream (gzip, bzip2, xz, or zstd) and returns
+// a reader of the decompressed content. Streams that are not recognized as compressed are passed through as-is.
+func newDecompressingReadCloser(reader io.ReadCloser, cfg UntarOptions) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+	// an error here indicates a short stream, which can only be passed through
+	magic, _ := buffered.Peek(len(xzMagic))
+
+	result := &decompressingReadCloser{closers: []io.Closer{reader}}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := newGzipMembersReader(buffered, cfg.TrailingGarbageAsEOF)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read gzip stream: %w", err)
+		}
+		result.Reader = gz
+		result.closers = append([]io.Closer{gz}, result.closers...)
+	case bytes.HasPrefix(magic, bzip2Magic):
+		result.Reader = bzip2.NewReader(buffered)
+	case bytes.HasPrefix(magic, xzMagic):
+		xzReader, err := xz.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read xz stream: %w", err)
+		}
+		result.Reader = xzReader
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read zstd stream: %w", err)
+		}
+		zr := decoder.IOReadCloser()
+		result.Reader = zr
+		result.closers = append([]io.Closer{zr}, result.closers...)
+	default:
+		result.Reader = buffered
+	}
+	return result, nil
+}
+
+// ReaderFromCompressedTar returns a io.ReadCloser for the Path within a tar file that may be compressed (gzip, bzip2,
+// xz, or zstd). Closing the returned reader closes both the decompressor and the given reader.
+func ReaderFromCompressedTar(reader io.ReadCloser, tarPath string, options ...Option) (io.ReadCloser, error) {
+	decompressed, err := newDecompressingReadCloser(reader, newUntarOptions(options...))
+	if err != nil {
+		return nil, err
+	}
+	return ReaderFromTar(decompressed, tarPath)
+}
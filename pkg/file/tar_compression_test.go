@@ -0,0 +1,243 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderFromCompressedTar(t *testing.T) {
+	plain, err := io.ReadAll(newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/os-release"}, content: "ID=test\n"},
+	))
+	require.NoError(t, err)
+
+	gzipped := &bytes.Buffer{}
+	gw := gzip.NewWriter(gzipped)
+	_, err = gw.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	zstded := &bytes.Buffer{}
+	zw, err := zstd.NewWriter(zstded)
+	require.NoError(t, err)
+	_, err = zw.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{name: "plain", archive: plain},
+		{name: "gzip", archive: gzipped.Bytes()},
+		{name: "zstd", archive: zstded.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := ReaderFromCompressedTar(io.NopCloser(bytes.NewReader(tt.archive)), "etc/os-release")
+			require.NoError(t, err)
+			content, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			require.NoError(t, rc.Close())
+			assert.Equal(t, "ID=test\n", string(content))
+		})
+	}
+}
+
+func TestReaderFromCompressedTar_MissingFile(t *testing.T) {
+	_, err := ReaderFromCompressedTar(io.NopCloser(newTestTar(t)), "missing")
+	var notFound *ErrFileNotFound
+	require.ErrorAs(t, err, &notFound)
+}
+
+func TestReaderFromCompressedTar_MultipleGzipMembers(t *testing.T) {
+	plain, err := io.ReadAll(newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "first"}, content: "1"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/os-release"}, content: "ID=test\n"},
+	))
+	require.NoError(t, err)
+
+	// split within the first entry, so that the wanted entry is only within the second member
+	archive := append(gzipMember(t, plain[:100]), gzipMember(t, plain[100:])...)
+
+	rc, err := ReaderFromCompressedTar(io.NopCloser(bytes.NewReader(archive)), "etc/os-release")
+	require.NoError(t, err)
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "ID=test\n", string(content))
+}
+
+func Test_newDecompressingReadCloser_gzipMembers(t *testing.T) {
+	members := append(gzipMember(t, []byte("first,")), gzipMember(t, []byte("second"))...)
+	emptyMembers := bytes.Repeat(gzipMember(t, nil), 10000)
+
+	tests := []struct {
+		name    string
+		stream  []byte
+		options []Option
+		want    string
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "reads all members",
+			stream:  members,
+			want:    "first,second",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "skips empty members",
+			stream:  bytes.Join([][]byte{gzipMember(t, []byte("first,")), emptyMembers, gzipMember(t, []byte("second"))}, nil),
+			want:    "first,second",
+			wantErr: require.NoError,
+		},
+		{
+			name:   "trailing garbage is an error by default",
+			stream: append(bytes.Clone(members), make([]byte, 512)...),
+			want:   "first,second",
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				require.ErrorIs(t, err, gzip.ErrHeader)
+			},
+		},
+		{
+			name:    "trailing garbage treated as EOF",
+			stream:  append(bytes.Clone(members), make([]byte, 512)...),
+			options: []Option{WithTrailingGarbageAsEOF(true)},
+			want:    "first,second",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "trailing byte shorter than the gzip magic treated as EOF",
+			stream:  append(bytes.Clone(members), 0x1f),
+			options: []Option{WithTrailingGarbageAsEOF(true)},
+			want:    "first,second",
+			wantErr: require.NoError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := newDecompressingReadCloser(io.NopCloser(bytes.NewReader(tt.stream)), newUntarOptions(tt.options...))
+			require.NoError(t, err)
+			content, err := io.ReadAll(rc)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, string(content))
+			require.NoError(t, rc.Close())
+		})
+	}
+}
+
+func gzipMember(t *testing.T, content []byte) []byte {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	_, err := gw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func TestDetectFormat(t *testing.T) {
+	plain, err := io.ReadAll(newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/os-release"}, content: "ID=test\n"},
+	))
+	require.NoError(t, err)
+
+	gnu := &bytes.Buffer{}
+	tw := tar.NewWriter(gnu)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Format: tar.FormatGNU}))
+	require.NoError(t, tw.Close())
+
+	tests := []struct {
+		name string
+		blob []byte
+		want string
+	}{
+		{name: "gzip", blob: append([]byte{0x1f, 0x8b, 0x08}, make([]byte, 20)...), want: FormatGzip},
+		{name: "bzip2", blob: []byte("BZh91AY&SY"), want: FormatBzip2},
+		{name: "xz", blob: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00, 0x04}, want: FormatXz},
+		{name: "zstd", blob: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x04, 0x00}, want: FormatZstd},
+		{name: "ustar tar", blob: plain, want: FormatTar},
+		{name: "gnu tar", blob: gnu.Bytes(), want: FormatTar},
+		{name: "unknown", blob: []byte("definitely not an archive"), want: FormatUnknown},
+		{name: "empty", blob: nil, want: FormatUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, reader, err := DetectFormat(bytes.NewReader(tt.blob))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, format)
+
+			// the peeked bytes must be restored
+			restored, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.blob), len(restored))
+			assert.True(t, bytes.Equal(tt.blob, restored))
+		})
+	}
+}
+
+func TestIsTar(t *testing.T) {
+	plain, err := io.ReadAll(newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/os-release"}, content: "ID=test\n"},
+	))
+	require.NoError(t, err)
+
+	// the original tar format has no magic, only the checksum identifies the header
+	v7 := bytes.Clone(plain[:tarBlockSize])
+	copy(v7[257:265], make([]byte, 8))
+	copy(v7[148:156], "        ")
+	var sum int64
+	for _, b := range v7 {
+		sum += int64(b)
+	}
+	copy(v7[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	corrupt := bytes.Clone(plain)
+	corrupt[0] ^= 0xff
+
+	random := make([]byte, 4*tarBlockSize)
+	_, err = rand.New(rand.NewSource(1)).Read(random)
+	require.NoError(t, err)
+	// the magic alone is not enough to be considered a tar
+	randomWithMagic := bytes.Clone(random)
+	copy(randomWithMagic[ustarMagicOffset:], "ustar\x0000")
+
+	tests := []struct {
+		name string
+		blob []byte
+		want bool
+	}{
+		{name: "ustar tar", blob: plain, want: true},
+		{name: "original tar format", blob: v7, want: true},
+		{name: "empty tar", blob: make([]byte, 2*tarBlockSize), want: true},
+		{name: "invalid checksum", blob: corrupt},
+		{name: "gzip", blob: gzipMember(t, plain)},
+		{name: "random bytes", blob: random},
+		{name: "random bytes with the ustar magic", blob: randomWithMagic},
+		{name: "shorter than a header", blob: plain[:tarBlockSize-1]},
+		{name: "empty", blob: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reader, err := IsTar(bytes.NewReader(tt.blob))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			// the peeked bytes must be restored
+			restored, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(tt.blob, restored))
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package file
+
+import "io"
+
+// ConcatTarEntries returns a reader yielding the contents of the entries with the given (distinct) names in the given
+// order, such as for split files whose logical content is spread across several entries. The tar is read as the
+// returned reader is consumed, where pieces found in order are streamed through directly. Since the tar can only be
+// read forward, pieces found before all preceding pieces are buffered until their turn: by default in temporary files
+// (bounded by disk space only), or in memory for as long as they fit within the budget given by WithMemoryBudget
+// (which is faster, at the cost of memory). A piece that is not present fails the read with ErrFileNotFound once all
+// preceding content has been read.
+//
+// The returned reader must be closed, which stops reading the tar and removes any temporary files.
+func ConcatTarEntries(reader io.Reader, names []string, options ...Option) io.ReadCloser {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(concatTarEntries(reader, names, pw, newUntarOptions(options...)))
+	}()
+	return &concatReader{PipeReader: pr, done: done}
+}
+
+// concatReader is the read side of a concatenation, where closing waits for the concatenation to stop.
+type concatReader struct {
+	*io.PipeReader
+	done chan struct{}
+}
+
+func (c *concatReader) Close() error {
+	err := c.PipeReader.Close()
+	<-c.done
+	return err
+}
+
+func concatTarEntries(reader io.Reader, names []string, out io.Writer, cfg UntarOptions) error {
+	if len(names) == 0 {
+		return nil
+	}
+	indexes := make(map[string]int, len(names))
+	for i, name := range names {
+		indexes[cleanTarEntryName(name)] = i
+	}
+
+	buffer, err := newEntryBuffer(cfg.MemoryBudget)
+	if err != nil {
+		return err
+	}
+	defer buffer.close()
+
+	// next is the index of the piece to be written next, where pending pieces are those found out of order
+	next := 0
+	pending := make(map[int]*bufferedEntry)
+	defer func() {
+		for _, entry := range pending {
+			buffer.release(entry)
+		}
+	}()
+
+	visitor := func(entry TarFileEntry) error {
+		i, ok := indexes[cleanTarEntryName(entry.Header.Name)]
+		if !ok || i < next || pending[i] != nil {
+			return nil
+		}
+		if i > next {
+			buffered := &bufferedEntry{header: entry.Header}
+			pending[i] = buffered
+			return buffer.hold(buffered, entry.Reader)
+		}
+
+		if err := copyWithReadLimit(out, entry.Reader, nil); err != nil {
+			return err
+		}
+		for next++; pending[next] != nil; next++ {
+			piece := pending[next]
+			delete(pending, next)
+			err := piece.writeContent(out)
+			buffer.release(piece)
+			if err != nil {
+				return err
+			}
+		}
+		if next == len(names) {
+			return ErrTarStopIteration
+		}
+		return nil
+	}
+	if err := iterateTar(reader, visitor, cfg); err != nil {
+		return err
+	}
+	if next < len(names) {
+		return &ErrFileNotFound{Path: names[next]}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcatTarEntries(t *testing.T) {
+	reg := func(name, content string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name}, content: content}
+	}
+	newReader := func() io.Reader {
+		return newTestTar(t,
+			reg("data.part2", "world"),
+			reg("other", "ignored"),
+			reg("data.part1", "hello, "),
+			reg("./data.part4", "!"),
+			reg("data.part3", " and more"),
+		)
+	}
+	names := []string{"data.part1", "data.part2", "data.part3", "data.part4"}
+
+	tests := []struct {
+		name    string
+		names   []string
+		options []Option
+		want    string
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "out of order pieces buffered in temp files",
+			names:   names,
+			want:    "hello, world and more!",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "out of order pieces buffered in memory",
+			names:   names,
+			options: []Option{WithMemoryBudget(NewMemoryBudget(1 * MB))},
+			want:    "hello, world and more!",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "pieces in tar order",
+			names:   []string{"data.part2", "other"},
+			want:    "worldignored",
+			wantErr: require.NoError,
+		},
+		{
+			name:  "missing piece",
+			names: []string{"data.part1", "data.part2", "missing"},
+			want:  "hello, world",
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var notFound *ErrFileNotFound
+				require.ErrorAs(t, err, &notFound)
+				assert.Equal(t, "missing", notFound.Path)
+			},
+		},
+		{
+			name:    "no pieces",
+			want:    "",
+			wantErr: require.NoError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := ConcatTarEntries(newReader(), tt.names, tt.options...)
+			content, err := io.ReadAll(rc)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, string(content))
+			require.NoError(t, rc.Close())
+		})
+	}
+}
+
+func TestConcatTarEntries_MemoryReleased(t *testing.T) {
+	budget := NewMemoryBudget(1 * MB)
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "b"}, content: "b"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "c"}, content: "c"},
+	)
+
+	// closing early (with "b" and "c" still pending, as "a" is missing) stops the concatenation
+	rc := ConcatTarEntries(reader, []string{"a", "b", "c"}, WithMemoryBudget(budget))
+	require.NoError(t, rc.Close())
+	assert.Equal(t, int64(0), budget.InUse())
+}
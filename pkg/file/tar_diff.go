@@ -0,0 +1,90 @@
+package file
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// tarDiffRecord is the subset of an entry used to determine if a path changed between two archives.
+type tarDiffRecord struct {
+	typeflag byte
+	size     int64
+	mode     int64
+	modTime  time.Time
+	linkname string
+	digest   string
+}
+
+// DiffTars compares the entries of two tar archives, reporting the paths that were added to b, removed from a, or
+// changed in size, mode, modification time, type, or link target. Only header metadata is held in memory; content
+// digests are compared only when WithContentDigestComparison is given. When a path appears multiple times within
+// an archive the last occurrence wins. All results are sorted. The given options also apply to reading both
+// archives (such as WithDeadline), and content larger than the per-file read limit fails the comparison rather than
+// being compared in part.
+func DiffTars(a, b io.Reader, options ...Option) (added, removed, changed []string, err error) {
+	cfg := newUntarOptions(options...)
+
+	before, err := tarDiffRecords(a, cfg)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to read first archive: %w", err)
+	}
+	after, err := tarDiffRecords(b, cfg)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to read second archive: %w", err)
+	}
+
+	for name, record := range after {
+		previous, ok := before[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case !previous.equal(record):
+			changed = append(changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed, nil
+}
+
+func tarDiffRecords(reader io.Reader, cfg UntarOptions) (map[string]tarDiffRecord, error) {
+	records := make(map[string]tarDiffRecord)
+	visitor := func(entry TarFileEntry) error {
+		record := tarDiffRecord{
+			typeflag: entry.Header.Typeflag,
+			size:     entry.Header.Size,
+			mode:     entry.Header.Mode,
+			modTime:  entry.Header.ModTime,
+			linkname: entry.Header.Linkname,
+		}
+		if cfg.CompareContentDigests && entry.Header.Typeflag == tar.TypeReg {
+			h, algorithm := cfg.newHash()
+			if err := copyWithReadLimit(h, entry.Reader, nil); err != nil {
+				return fmt.Errorf("unable to digest content: %w", err)
+			}
+			record.digest = formatDigest(algorithm, h)
+		}
+		records[cleanTarEntryName(entry.Header.Name)] = record
+		return nil
+	}
+	return records, iterateTar(reader, visitor, cfg)
+}
+
+func (r tarDiffRecord) equal(other tarDiffRecord) bool {
+	return r.typeflag == other.typeflag &&
+		r.size == other.size &&
+		r.mode == other.mode &&
+		r.modTime.Equal(other.modTime) &&
+		r.linkname == other.linkname &&
+		r.digest == other.digest
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffTars(t *testing.T) {
+	modTime := time.Date(2019, time.September, 16, 0, 0, 0, 0, time.UTC)
+	reg := func(name, content string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name, ModTime: modTime}, content: content}
+	}
+
+	before := []testTarEntry{
+		{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", ModTime: modTime, Mode: 0755}},
+		reg("etc/unchanged", "same"),
+		reg("etc/resized", "short"),
+		reg("etc/same-size", "aaaa"),
+		reg("etc/removed", "gone"),
+	}
+	after := []testTarEntry{
+		{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", ModTime: modTime, Mode: 0700}},
+		reg("etc/unchanged", "same"),
+		reg("etc/resized", "much longer"),
+		reg("etc/same-size", "bbbb"),
+		reg("etc/added", "new"),
+	}
+
+	tests := []struct {
+		name        string
+		options     []Option
+		wantChanged []string
+	}{
+		{
+			name:        "metadata only",
+			wantChanged: []string{"etc", "etc/resized"},
+		},
+		{
+			name:        "with content digests",
+			options:     []Option{WithContentDigestComparison(true)},
+			wantChanged: []string{"etc", "etc/resized", "etc/same-size"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed, changed, err := DiffTars(newTestTar(t, before...), newTestTar(t, after...), tt.options...)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"etc/added"}, added)
+			assert.Equal(t, []string{"etc/removed"}, removed)
+			assert.Equal(t, tt.wantChanged, changed)
+		})
+	}
+}
+
+func TestDiffTars_Options(t *testing.T) {
+	unsorted := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "b"}, content: "b"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "a"}, content: "a"},
+	)
+	_, _, _, err := DiffTars(newTestTar(t), unsorted, WithRequireSorted(true))
+	var unsortedErr *ErrUnsortedArchive
+	require.ErrorAs(t, err, &unsortedErr)
+}
@@ -0,0 +1,133 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// bufferedEntry is a tar entry held back until it is written out.
+type bufferedEntry struct {
+	header tar.Header
+	// content is the path of the temporary file holding the content of the entry
+	content string
+	// data holds the content of the entry instead when it fits within the memory budget
+	data []byte
+}
+
+// entryBuffer holds the content of tar entries until they are written, in memory for as long as it fits within the
+// memory budget and otherwise spilling to temporary files.
+type entryBuffer struct {
+	tempDir string
+	budget  *MemoryBudget
+}
+
+func newEntryBuffer(budget *MemoryBudget) (*entryBuffer, error) {
+	tempDir, err := os.MkdirTemp("", "stereoscope-buffer-")
+	if err != nil {
+		return nil, err
+	}
+	return &entryBuffer{tempDir: tempDir, budget: budget}, nil
+}
+
+// close removes all temporary files. Memory held for entries must be released separately (see release).
+func (b *entryBuffer) close() {
+	os.RemoveAll(b.tempDir)
+}
+
+func (b *entryBuffer) hold(entry *bufferedEntry, reader io.Reader) error {
+	if size := entry.header.Size; size > 0 && b.budget.tryAcquire(size) {
+		buf := bytes.NewBuffer(make([]byte, 0, size))
+		if err := copyWithReadLimit(buf, reader, make([]byte, copyBufferSize)); err != nil {
+			b.budget.release(size)
+			return err
+		}
+		// the tar reader yields exactly the header size, so this matches what was acquired
+		entry.data = buf.Bytes()
+		return nil
+	}
+
+	f, err := os.CreateTemp(b.tempDir, "content-")
+	if err != nil {
+		return fmt.Errorf("unable to buffer content: %w", err)
+	}
+	defer f.Close()
+
+	if err := copyWithReadLimit(f, reader, make([]byte, copyBufferSize)); err != nil {
+		return err
+	}
+	entry.content = f.Name()
+	return nil
+}
+
+// holdUnsized holds content whose size is not known up front, in memory for as long as each chunk read fits within the
+// memory budget, spilling everything held so far (and the remainder) to a temporary file once it does not.
+func (b *entryBuffer) holdUnsized(entry *bufferedEntry, reader io.Reader) error {
+	reader = NewLimitedReader(reader, perFileReadLimit)
+	var buf bytes.Buffer
+	chunk := make([]byte, copyBufferSize)
+	for {
+		n, err := reader.Read(chunk)
+		if n > 0 {
+			if !b.budget.tryAcquire(int64(n)) {
+				held := io.MultiReader(bytes.NewReader(buf.Bytes()), bytes.NewReader(chunk[:n]), reader)
+				b.budget.release(int64(buf.Len()))
+				return b.hold(entry, held)
+			}
+			buf.Write(chunk[:n])
+		}
+		if err == io.EOF {
+			entry.data = buf.Bytes()
+			return nil
+		}
+		if err != nil {
+			b.budget.release(int64(buf.Len()))
+			if errors.Is(err, ErrReadLimitExceeded) {
+				return fmt.Errorf("zip read limit hit (potential decompression bomb attack): %w", err)
+			}
+			return fmt.Errorf("unable to buffer content: %w", err)
+		}
+	}
+}
+
+// release returns any memory held for the content of the given entry to the budget.
+func (b *entryBuffer) release(entry *bufferedEntry) {
+	b.budget.release(int64(len(entry.data)))
+	entry.data = nil
+}
+
+func writeBufferedEntry(tw *tar.Writer, entry *bufferedEntry) error {
+	header := entry.header
+	if err := tw.WriteHeader(&header); err != nil {
+		return fmt.Errorf("unable to write header for %q: %w", header.Name, err)
+	}
+	return entry.writeContent(tw)
+}
+
+// writeContent writes the held content of the entry (if any) to w.
+func (e *bufferedEntry) writeContent(w io.Writer) error {
+	var err error
+	switch {
+	case e.data != nil:
+		_, err = w.Write(e.data)
+	case e.content != "":
+		err = copyFileTo(w, e.content)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write content for %q: %w", e.header.Name, err)
+	}
+	return nil
+}
+
+func copyFileTo(w io.Writer, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,57 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_entryBuffer_hold_spillsToDisk(t *testing.T) {
+	buffer := &entryBuffer{
+		tempDir: t.TempDir(),
+		budget:  NewMemoryBudget(10),
+	}
+
+	inMemory := &bufferedEntry{header: tar.Header{Typeflag: tar.TypeReg, Size: 8}}
+	require.NoError(t, buffer.hold(inMemory, bytes.NewReader([]byte("12345678"))))
+	assert.Equal(t, []byte("12345678"), inMemory.data)
+	assert.Empty(t, inMemory.content)
+
+	// the budget is now exhausted
+	spilled := &bufferedEntry{header: tar.Header{Typeflag: tar.TypeReg, Size: 8}}
+	require.NoError(t, buffer.hold(spilled, bytes.NewReader([]byte("87654321"))))
+	assert.Nil(t, spilled.data)
+	assert.FileExists(t, spilled.content)
+	assert.Equal(t, int64(8), buffer.budget.InUse())
+
+	buffer.release(inMemory)
+	assert.Equal(t, int64(0), buffer.budget.InUse())
+}
+
+func Test_entryBuffer_holdUnsized_spillsToDisk(t *testing.T) {
+	buffer := &entryBuffer{
+		tempDir: t.TempDir(),
+		budget:  NewMemoryBudget(10),
+	}
+
+	inMemory := &bufferedEntry{header: tar.Header{Typeflag: tar.TypeReg, Size: -1}}
+	require.NoError(t, buffer.holdUnsized(inMemory, bytes.NewReader([]byte("12345678"))))
+	assert.Equal(t, []byte("12345678"), inMemory.data)
+	assert.Empty(t, inMemory.content)
+	assert.Equal(t, int64(8), buffer.budget.InUse())
+	buffer.release(inMemory)
+
+	// content is read a byte at a time, so that the budget is exhausted part way through
+	spilled := &bufferedEntry{header: tar.Header{Typeflag: tar.TypeReg, Size: -1}}
+	require.NoError(t, buffer.holdUnsized(spilled, iotest.OneByteReader(bytes.NewReader([]byte("123456789012")))))
+	assert.Nil(t, spilled.data)
+	content, err := os.ReadFile(spilled.content)
+	require.NoError(t, err)
+	assert.Equal(t, "123456789012", string(content))
+	assert.Equal(t, int64(0), buffer.budget.InUse())
+}
@@ -0,0 +1,41 @@
+package file
+
+import (
+	"io"
+	"io/fs"
+)
+
+// FileInfo returns the file info described by the entry header (as with tar.Header.FileInfo), where the name is the
+// base name of the entry and Sys returns the *tar.Header.
+func (e TarFileEntry) FileInfo() fs.FileInfo {
+	return e.Header.FileInfo()
+}
+
+// File returns the entry as an fs.File, for passing entries to APIs expecting one. Reading the file reads the content
+// of the entry, which (as with Reader) may only be read within the visitor, and Stat returns FileInfo. Closing the file
+// does nothing, since the content belongs to the tar stream being iterated.
+func (e TarFileEntry) File() fs.File {
+	return &tarEntryFile{entry: e}
+}
+
+// tarEntryFile is the fs.File presented for a tar entry by TarFileEntry.File.
+type tarEntryFile struct {
+	entry TarFileEntry
+}
+
+var _ fs.File = (*tarEntryFile)(nil)
+
+func (f *tarEntryFile) Stat() (fs.FileInfo, error) {
+	return f.entry.FileInfo(), nil
+}
+
+func (f *tarEntryFile) Read(p []byte) (int, error) {
+	if f.entry.Reader == nil {
+		return 0, io.EOF
+	}
+	return f.entry.Reader.Read(p)
+}
+
+func (f *tarEntryFile) Close() error {
+	return nil
+}
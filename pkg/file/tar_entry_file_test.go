@@ -0,0 +1,66 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarFileEntry_FileInfo(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755, ModTime: modTime}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts", Mode: 0644, ModTime: modTime}, content: "127.0.0.1 localhost"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/localtime", Linkname: "/usr/share/zoneinfo/UTC", Mode: 0777, ModTime: modTime}},
+	)
+
+	type info struct {
+		name    string
+		size    int64
+		mode    fs.FileMode
+		modTime time.Time
+		isDir   bool
+	}
+	var got []info
+	require.NoError(t, IterateTar(reader, func(entry TarFileEntry) error {
+		fi := entry.FileInfo()
+		got = append(got, info{name: fi.Name(), size: fi.Size(), mode: fi.Mode(), modTime: fi.ModTime().UTC(), isDir: fi.IsDir()})
+
+		header, ok := fi.Sys().(*tar.Header)
+		require.True(t, ok)
+		assert.Equal(t, entry.Header.Name, header.Name)
+		return nil
+	}))
+	assert.Equal(t, []info{
+		{name: "etc", mode: fs.ModeDir | 0755, modTime: modTime, isDir: true},
+		{name: "hosts", size: 19, mode: 0644, modTime: modTime},
+		{name: "localtime", mode: fs.ModeSymlink | 0777, modTime: modTime},
+	}, got)
+}
+
+func TestTarFileEntry_File(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts", Mode: 0644}, content: "127.0.0.1 localhost"},
+	)
+
+	require.NoError(t, IterateTar(reader, func(entry TarFileEntry) error {
+		f := entry.File()
+		stat, err := f.Stat()
+		require.NoError(t, err)
+		assert.Equal(t, entry.FileInfo(), stat)
+
+		content, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, "127.0.0.1 localhost", string(content))
+		require.NoError(t, f.Close())
+		return nil
+	}))
+}
@@ -0,0 +1,167 @@
+package file
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrCaseCollision is returned during extraction when two entries differ only by case and would clobber each other
+// on a case-insensitive filesystem.
+type ErrCaseCollision struct {
+	Path     string
+	Conflict string
+}
+
+func (e *ErrCaseCollision) Error() string {
+	return fmt.Sprintf("case-insensitive path collision (path=%s conflict=%s)", e.Path, e.Conflict)
+}
+
+// ErrPathTraversal is returned for entry names that would resolve outside of the extraction destination.
+type ErrPathTraversal struct {
+	Name string
+}
+
+func (e *ErrPathTraversal) Error() string {
+	return fmt.Sprintf("potential path traversal attack with entry: %q", e.Name)
+}
+
+// ErrUnsafeLinkTarget is returned during iteration (when unsafe links are rejected) for hardlinks and symlinks whose
+// target is absolute or would resolve outside of the root of the archive.
+type ErrUnsafeLinkTarget struct {
+	Name     string
+	Linkname string
+}
+
+func (e *ErrUnsafeLinkTarget) Error() string {
+	return fmt.Sprintf("potential path traversal attack with link target (entry=%q target=%q)", e.Name, e.Linkname)
+}
+
+// ErrDeviceEntry is returned during iteration (when devices are rejected) for character and block device entries.
+type ErrDeviceEntry struct {
+	Name string
+	Type Type
+}
+
+func (e *ErrDeviceEntry) Error() string {
+	return fmt.Sprintf("archive contains a device entry (entry=%q type=%s)", e.Name, e.Type)
+}
+
+// ErrUnsortedArchive is returned during iteration (when sorted entries are required) for the first entry whose name
+// sorts before the name of the entry preceding it. Both names are cleaned as they are compared.
+type ErrUnsortedArchive struct {
+	Previous string
+	Name     string
+}
+
+func (e *ErrUnsortedArchive) Error() string {
+	return fmt.Sprintf("archive entries are not sorted (entry=%q follows %q)", e.Name, e.Previous)
+}
+
+// ErrWouldOverwrite is returned during extraction (when WithNoOverwrite is enabled) if a regular file already
+// exists at the target path.
+type ErrWouldOverwrite struct {
+	Path string
+}
+
+func (e *ErrWouldOverwrite) Error() string {
+	return fmt.Sprintf("refusing to overwrite existing file (path=%s)", e.Path)
+}
+
+// ErrTypeConflict is returned during extraction (unless another OnTypeConflict policy is set) when the target path
+// already exists as a different kind of node than the entry, e.g. a directory where the archive has a regular file.
+type ErrTypeConflict struct {
+	Path     string
+	Existing Type
+	Entry    Type
+}
+
+func (e *ErrTypeConflict) Error() string {
+	return fmt.Sprintf("existing node has a conflicting type (path=%s existing=%s entry=%s)", e.Path, e.Existing, e.Entry)
+}
+
+// ErrInvalidUTF8Name is returned during extraction (when RequireUTF8Names is enabled) for entry names that are not
+// valid UTF-8 once remapped by any sanitizer. Name is the name of the entry within the archive.
+type ErrInvalidUTF8Name struct {
+	Name string
+}
+
+func (e *ErrInvalidUTF8Name) Error() string {
+	return fmt.Sprintf("entry name is not valid UTF-8 (name=%q)", e.Name)
+}
+
+// ErrDeadlineExceeded is returned when iteration or extraction runs past the configured deadline.
+type ErrDeadlineExceeded struct {
+	Deadline time.Time
+}
+
+func (e *ErrDeadlineExceeded) Error() string {
+	return fmt.Sprintf("tar processing deadline exceeded (deadline=%s)", e.Deadline.Format(time.RFC3339Nano))
+}
+
+// ErrExtractionDeadlineExceeded is the error returned when extraction runs past the deadline set by WithDeadline. It is
+// the same type as ErrDeadlineExceeded, so either may be used as the target of errors.As.
+type ErrExtractionDeadlineExceeded = ErrDeadlineExceeded
+
+// ErrExtractBudgetExceeded is returned when extraction (configured with WithExtractBudget to error) would write more
+// content than the budget allows.
+type ErrExtractBudgetExceeded struct {
+	Budget int64
+}
+
+func (e *ErrExtractBudgetExceeded) Error() string {
+	return fmt.Sprintf("extraction budget exceeded (budget=%d bytes)", e.Budget)
+}
+
+// ErrDestinationNotDirectory is returned when the extraction destination exists but is not a directory.
+type ErrDestinationNotDirectory struct {
+	Path string
+}
+
+func (e *ErrDestinationNotDirectory) Error() string {
+	return fmt.Sprintf("extraction destination is not a directory (path=%s)", e.Path)
+}
+
+// ErrSizeDisagreement is returned during iteration (when PAX size validation is enabled) for entries whose PAX size
+// record disagrees with the size field of the ustar header. A USTARSize of -1 indicates an unparsable size field.
+type ErrSizeDisagreement struct {
+	Name      string
+	PAXSize   int64
+	USTARSize int64
+}
+
+func (e *ErrSizeDisagreement) Error() string {
+	return fmt.Sprintf("PAX size record disagrees with ustar size (name=%q pax=%d ustar=%d)", e.Name, e.PAXSize, e.USTARSize)
+}
+
+// ErrMissingTerminator is returned from iteration (when the terminator is required) for archives that end without the
+// end-of-archive marker of two zero blocks, indicating the archive may have been truncated.
+type ErrMissingTerminator struct {
+	// ZeroBlocks is the number of zero blocks found after the last entry.
+	ZeroBlocks int
+}
+
+func (e *ErrMissingTerminator) Error() string {
+	return fmt.Sprintf("tar archive ends without the end-of-archive marker (zero blocks=%d, want 2)", e.ZeroBlocks)
+}
+
+// ErrMalformedArchive is returned from iteration for archives that cannot be read in a way that is not otherwise
+// reported by archive/tar.
+type ErrMalformedArchive struct {
+	Reason string
+}
+
+func (e *ErrMalformedArchive) Error() string {
+	return fmt.Sprintf("malformed tar archive: %s", e.Reason)
+}
+
+// ErrInvalidSparseMap is returned from iteration for sparse entries whose map of data regions is out of order,
+// overlapping, or beyond the size of the entry. The map can only be inspected for seekable streams, otherwise such
+// entries fail with the tar.ErrHeader returned by archive/tar.
+type ErrInvalidSparseMap struct {
+	Name   string
+	Reason string
+}
+
+func (e *ErrInvalidSparseMap) Error() string {
+	return fmt.Sprintf("invalid sparse map for tar entry=%q: %s", e.Name, e.Reason)
+}
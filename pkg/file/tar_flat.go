@@ -0,0 +1,100 @@
+package file
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/scylladb/go-set/strset"
+)
+
+// UntarFlat writes all regular files within the given tar reader directly into the given destination, discarding the
+// directory structure of the archive (see Extractor.UntarFlat).
+func UntarFlat(reader io.Reader, dst string, options ...Option) (map[string]string, error) {
+	if len(options) == 0 {
+		return defaultExtractor.UntarFlat(reader, dst)
+	}
+	return NewExtractor(options...).UntarFlat(reader, dst)
+}
+
+// UntarFlat writes all regular files within the given tar reader directly into the given destination, discarding the
+// directory structure of the archive, which is useful when only the content of files matters (such as for scanning).
+// Files sharing a base name are written with a counter appended (e.g. "a/config.yaml" and "b/config.yaml" are written
+// as "config.yaml" and "config-1.yaml"), and all other entry types are skipped. The returned map is from each entry
+// name to the path its content was written to. As with Untar, the files written so far are returned when failing
+// partway through the archive.
+func (e *Extractor) UntarFlat(reader io.Reader, dst string) (map[string]string, error) {
+	if err := e.prepareDestination(dst); err != nil {
+		return nil, err
+	}
+	f := flatVisitor{
+		tarVisitor: e.newVisitor(dst),
+		used:       strset.New(),
+		written:    make(map[string]string),
+	}
+	err := iterateTar(reader, f.visit, e.options)
+	return f.written, err
+}
+
+// flatVisitor extracts regular files into a single directory, tracking the names already used within it.
+type flatVisitor struct {
+	tarVisitor
+	used    *strset.Set
+	written map[string]string
+}
+
+func (f flatVisitor) visit(entry TarFileEntry) error {
+	f.options.metrics().Inc(MetricEntriesProcessed)
+	if entry.Header.Typeflag != tar.TypeReg {
+		skipEntry(f.options.metrics(), entry, "non-regular file")
+		return nil
+	}
+	original := entry.Header.Name
+	name, err := f.entryName(entry.Header.Name)
+	if err != nil {
+		return err
+	}
+	entry.Header.Name = name
+
+	base := path.Base(cleanTarEntryName(name))
+	if base == "." {
+		skipEntry(f.options.metrics(), entry, "root entry")
+		return nil
+	}
+	// the base name is only free of separators and relative elements for slash separated paths, so it is still joined
+	// safely (e.g. "..\evil" on Windows)
+	target, err := SafeJoin(f.destination, f.uniqueName(base))
+	if err != nil {
+		f.options.metrics().Inc(MetricTraversalsBlocked)
+		return err
+	}
+	if err := f.visitRegularFile(entry, target); err != nil {
+		return err
+	}
+	f.written[original] = target
+	return nil
+}
+
+// uniqueName returns the given base name, with a counter appended before any extension when the name has already been
+// used, and marks the returned name as used.
+func (f flatVisitor) uniqueName(base string) string {
+	name := base
+	stem, ext := splitExtension(base)
+	for i := 1; f.used.Has(name); i++ {
+		name = fmt.Sprintf("%s-%d%s", stem, i, ext)
+	}
+	f.used.Add(name)
+	return name
+}
+
+// splitExtension splits the extension from the given base name, where names starting with a dot and without any other
+// dot (such as ".bashrc") have no extension.
+func splitExtension(base string) (string, string) {
+	idx := strings.Index(base[1:], ".")
+	if idx < 0 {
+		return base, ""
+	}
+	return base[:idx+1], base[idx+1:]
+}
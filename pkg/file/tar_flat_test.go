@@ -0,0 +1,108 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUntarFlat(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "a/"}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "a/config.yaml"}, content: "a"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "b/c/config.yaml"}, content: "b"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "config-1.yaml"}, content: "c"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "a/.bashrc"}, content: "d"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "b/.bashrc"}, content: "e"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "a/link", Linkname: "config.yaml"}},
+	)
+	dst := t.TempDir()
+
+	written, err := UntarFlat(reader, dst)
+	require.NoError(t, err)
+
+	want := map[string]string{
+		"a/config.yaml":   filepath.Join(dst, "config.yaml"),
+		"b/c/config.yaml": filepath.Join(dst, "config-1.yaml"),
+		"config-1.yaml":   filepath.Join(dst, "config-1-1.yaml"),
+		"a/.bashrc":       filepath.Join(dst, ".bashrc"),
+		"b/.bashrc":       filepath.Join(dst, ".bashrc-1"),
+	}
+	assert.Equal(t, want, written)
+
+	contents := map[string]string{
+		"a/config.yaml":   "a",
+		"b/c/config.yaml": "b",
+		"config-1.yaml":   "c",
+		"a/.bashrc":       "d",
+		"b/.bashrc":       "e",
+	}
+	for name, content := range contents {
+		got, err := os.ReadFile(written[name])
+		require.NoError(t, err)
+		assert.Equal(t, content, string(got), "name=%q", name)
+	}
+
+	// only the regular files were written, without any directories
+	dirEntries, err := os.ReadDir(dst)
+	require.NoError(t, err)
+	assert.Len(t, dirEntries, len(want))
+}
+
+func TestUntarFlat_EntryNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string
+		options []Option
+		want    string
+		wantErr require.ErrorAssertionFunc
+		metrics map[string]int64
+	}{
+		{
+			name:  "names are remapped by the name sanitizer",
+			entry: "a/Config.YAML",
+			options: []Option{WithNameSanitizer(func(name string) string {
+				return strings.ToLower(name)
+			})},
+			want:    "config.yaml",
+			wantErr: require.NoError,
+			metrics: map[string]int64{MetricEntriesProcessed: 1, MetricBytesWritten: 7},
+		},
+		{
+			name:    "invalid names are rejected when required",
+			entry:   "a/caf\xe9.txt",
+			options: []Option{WithRequireUTF8Names(true)},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var invalid *ErrInvalidUTF8Name
+				require.ErrorAs(t, err, &invalid)
+			},
+			metrics: map[string]int64{MetricEntriesProcessed: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newTestTar(t, testTarEntry{
+				header:  tar.Header{Typeflag: tar.TypeReg, Name: tt.entry, Format: tar.FormatGNU},
+				content: "content",
+			})
+			dst := t.TempDir()
+			metrics := &fakeMetrics{}
+
+			written, err := UntarFlat(reader, dst, append(tt.options, WithMetrics(metrics))...)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.metrics, metrics.counters)
+			if tt.want != "" {
+				assert.Equal(t, map[string]string{tt.entry: filepath.Join(dst, tt.want)}, written)
+				assert.FileExists(t, filepath.Join(dst, tt.want))
+			}
+		})
+	}
+}
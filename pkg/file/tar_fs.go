@@ -0,0 +1,23 @@
+package file
+
+import (
+	"io"
+	"io/fs"
+
+	"github.com/spf13/afero"
+)
+
+// TarToFS extracts the given tar entirely into memory, returning a read-only fs.FS of its content (suitable for
+// fs.WalkDir, fs.ReadFile, etc.). All extraction options and safety guards apply, including the per-file read limit
+// (consider WithExtractBudget to bound the total memory used). Paths within the returned fs.FS are relative to the root
+// of the archive.
+func TarToFS(reader io.Reader, options ...Option) (fs.FS, error) {
+	mem := afero.NewMemMapFs()
+	extractor := NewExtractor(options...)
+	extractor.fs = mem
+	if err := extractor.Untar(reader, DirSeparator); err != nil {
+		return nil, err
+	}
+	// fs.FS paths are unrooted, while the extracted content is rooted
+	return afero.NewIOFS(afero.NewBasePathFs(afero.NewReadOnlyFs(mem), DirSeparator)), nil
+}
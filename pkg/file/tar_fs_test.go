@@ -0,0 +1,70 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarToFS(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "./etc/", Mode: 0755}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "./etc/os-release"}, content: "ID=test\n"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "./usr/", Mode: 0755}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "./usr/bin/", Mode: 0755}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "./usr/bin/tool", Mode: 0755}, content: "#!/bin/sh\n"},
+	)
+
+	fsys, err := TarToFS(reader)
+	require.NoError(t, err)
+
+	content, err := fs.ReadFile(fsys, "etc/os-release")
+	require.NoError(t, err)
+	assert.Equal(t, "ID=test\n", string(content))
+
+	var walked []string
+	require.NoError(t, fs.WalkDir(fsys, ".", func(p string, _ fs.DirEntry, err error) error {
+		walked = append(walked, p)
+		return err
+	}))
+	assert.Equal(t, []string{".", "etc", "etc/os-release", "usr", "usr/bin", "usr/bin/tool"}, walked)
+
+	_, err = fs.Stat(fsys, "missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestTarToFS_ReadOnly(t *testing.T) {
+	fsys, err := TarToFS(newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file.txt"}, content: "hi"}))
+	require.NoError(t, err)
+
+	_, writable := fsys.(interface {
+		Create(name string) (fs.File, error)
+	})
+	assert.False(t, writable)
+
+	f, err := fsys.Open("file.txt")
+	require.NoError(t, err)
+	defer f.Close()
+	if w, ok := f.(io.Writer); ok {
+		_, err := w.Write([]byte("changed"))
+		assert.Error(t, err, "files should be read-only")
+	}
+}
+
+func TestTarToFS_Traversal(t *testing.T) {
+	// there is nothing above the root of the in-memory tree, so escaping names are anchored to the root
+	fsys, err := TarToFS(newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "../escape"}, content: "bad"}))
+	require.NoError(t, err)
+
+	content, err := fs.ReadFile(fsys, "escape")
+	require.NoError(t, err)
+	assert.Equal(t, "bad", string(content))
+}
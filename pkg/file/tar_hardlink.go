@@ -0,0 +1,121 @@
+package file
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+
+	"github.com/spf13/afero"
+
+	"github.com/anchore/stereoscope/internal/log"
+)
+
+// HardlinkStrategy is how extraction materializes hardlink entries.
+type HardlinkStrategy int
+
+const (
+	// HardlinkSkip does not extract hardlinks, as with symlinks.
+	HardlinkSkip HardlinkStrategy = iota
+	// HardlinkLink creates a hardlink to the file extracted for the link target, saving the disk space of a copy. The
+	// content is copied instead where the filesystem does not support hardlinks.
+	HardlinkLink
+	// HardlinkCopy writes a copy of the content of the file extracted for the link target.
+	HardlinkCopy
+)
+
+// hardLinker is implemented by filesystems that support creating hardlinks. afero has no such interface, so the OS
+// filesystem is handled separately.
+type hardLinker interface {
+	Link(oldname, newname string) error
+}
+
+// visitHardlink materializes a hardlink entry as configured by the HardlinkStrategy. Only links to regular files
+// extracted earlier from the same archive (and thus within the destination) are materialized, all others are skipped.
+func (v tarVisitor) visitHardlink(entry TarFileEntry, target string) error {
+	linkTarget, err := v.targetPath(entry.Header.Linkname)
+	if err != nil {
+		v.options.metrics().Inc(MetricTraversalsBlocked)
+		return &ErrUnsafeLinkTarget{Name: entry.Header.Name, Linkname: entry.Header.Linkname}
+	}
+	canonical, ok := v.linkTargets[linkTarget]
+	if !ok || linkTarget == target {
+		skipEntry(v.options.metrics(), entry, "hardlink to an unextracted file")
+		return nil
+	}
+	if extract, err := v.resolveTypeConflict(entry.Header, target); !extract {
+		return err
+	}
+
+	if v.options.Hardlinks == HardlinkLink {
+		linked, err := v.createHardlink(linkTarget, target)
+		if err != nil || linked {
+			return err
+		}
+	}
+	return v.copyHardlink(entry, canonical, linkTarget, target)
+}
+
+// createHardlink links newname to oldname, replacing any existing file, returning false if the filesystem does not
+// support hardlinks (such that the content should be copied instead).
+func (v tarVisitor) createHardlink(oldname, newname string) (bool, error) {
+	var link func(oldname, newname string) error
+	switch fs := v.fs.(type) {
+	case *afero.OsFs:
+		link = os.Link
+	case hardLinker:
+		link = fs.Link
+	default:
+		return false, nil
+	}
+
+	if v.options.NoOverwrite {
+		if _, err := v.fs.Stat(newname); err == nil {
+			return false, &ErrWouldOverwrite{Path: newname}
+		}
+	}
+	if err := v.fs.Remove(newname); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("unable to replace existing file (path=%s): %w", newname, err)
+	}
+	v.trackCreated(newname)
+	if err := link(oldname, newname); err != nil {
+		// e.g. filesystems without hardlink support, or linking across devices
+		log.WithFields("path", newname, "target", oldname, "error", err).Debug("unable to create hardlink, copying content instead")
+		return false, nil
+	}
+	v.hardlinked[oldname] = struct{}{}
+	v.hardlinked[newname] = struct{}{}
+	return true, nil
+}
+
+// copyHardlink writes the content of the file extracted for the link target to the target, with the header of the
+// file that was extracted (as a hardlink shares all attributes with its target). Both files are open while copying,
+// which holds two slots of the open file limit for the entire copy.
+func (v tarVisitor) copyHardlink(entry TarFileEntry, canonical tar.Header, linkTarget, target string) error {
+	held := v.options.openFiles.acquireN(2)
+	defer v.options.openFiles.releaseN(held)
+	// the slots for writing the target are already held
+	v.options.openFiles = nil
+
+	f, err := v.fs.Open(linkTarget)
+	if err != nil {
+		return fmt.Errorf("unable to open hardlink target (path=%s): %w", linkTarget, err)
+	}
+	defer f.Close()
+
+	header := canonical
+	header.Name = entry.Header.Name
+	return v.visitRegularFile(TarFileEntry{Sequence: entry.Sequence, Header: header, Reader: f}, target)
+}
+
+// unlinkShared removes the target if it shares its content with other files through a hardlink, so that writing to
+// it does not modify the others along with it.
+func (v tarVisitor) unlinkShared(target string) error {
+	if _, ok := v.hardlinked[target]; !ok {
+		return nil
+	}
+	delete(v.hardlinked, target)
+	if err := v.fs.Remove(target); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to replace hardlinked file (path=%s): %w", target, err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUntarToDirectory_HardlinkStrategy(t *testing.T) {
+	newReader := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "dir/"}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "dir/file", Mode: 0640}, content: "content"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeLink, Name: "dir/link", Linkname: "dir/file"}},
+			// links to anything other than an extracted regular file are never materialized
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeLink, Name: "dir/missing", Linkname: "dir/nothing"}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeLink, Name: "dir/self", Linkname: "dir/self"}},
+		)
+	}
+
+	tests := []struct {
+		name       string
+		strategy   HardlinkStrategy
+		wantLink   bool
+		wantShared bool
+	}{
+		{
+			name:     "skips hardlinks by default",
+			strategy: HardlinkSkip,
+		},
+		{
+			name:       "links to the extracted file",
+			strategy:   HardlinkLink,
+			wantLink:   true,
+			wantShared: true,
+		},
+		{
+			name:     "copies the extracted file",
+			strategy: HardlinkCopy,
+			wantLink: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			require.NoError(t, UntarToDirectory(newReader(), dst, WithHardlinkStrategy(tt.strategy)))
+
+			_, err := os.Stat(filepath.Join(dst, "dir", "missing"))
+			assert.True(t, os.IsNotExist(err))
+			_, err = os.Stat(filepath.Join(dst, "dir", "self"))
+			assert.True(t, os.IsNotExist(err))
+
+			link, err := os.Stat(filepath.Join(dst, "dir", "link"))
+			if !tt.wantLink {
+				assert.True(t, os.IsNotExist(err))
+				return
+			}
+			require.NoError(t, err)
+			content, err := os.ReadFile(filepath.Join(dst, "dir", "link"))
+			require.NoError(t, err)
+			assert.Equal(t, "content", string(content))
+			assert.Equal(t, os.FileMode(0640), link.Mode().Perm())
+
+			file, err := os.Stat(filepath.Join(dst, "dir", "file"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantShared, os.SameFile(file, link))
+		})
+	}
+}
+
+func TestUntarToDirectory_HardlinkReplacedWithoutModifyingTarget(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file"}, content: "original"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeLink, Name: "link", Linkname: "file"}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "link"}, content: "replaced"},
+	)
+	dst := t.TempDir()
+	require.NoError(t, UntarToDirectory(reader, dst, WithHardlinkStrategy(HardlinkLink)))
+
+	content, err := os.ReadFile(filepath.Join(dst, "file"))
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(content))
+	content, err = os.ReadFile(filepath.Join(dst, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "replaced", string(content))
+}
+
+func TestUntarToDirectory_HardlinkFallsBackToCopy(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file"}, content: "content"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeLink, Name: "link", Linkname: "file"}},
+	)
+	// the in-memory filesystem does not support hardlinks
+	fs := afero.NewMemMapFs()
+	extractor := NewExtractor(WithHardlinkStrategy(HardlinkLink))
+	extractor.fs = fs
+	require.NoError(t, extractor.Untar(reader, "/dst"))
+
+	content, err := afero.ReadFile(fs, "/dst/link")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
+
+func TestUntarToDirectory_HardlinkTraversal(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeLink, Name: "shadow", Linkname: "../../etc/shadow"}},
+	)
+	err := UntarToDirectory(reader, t.TempDir(), WithHardlinkStrategy(HardlinkLink))
+	var linkErr *ErrUnsafeLinkTarget
+	require.ErrorAs(t, err, &linkErr)
+	assert.Equal(t, "../../etc/shadow", linkErr.Linkname)
+}
@@ -0,0 +1,48 @@
+package file
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// DefaultSizeBuckets are the bucket bounds used by TarSizeHistogram when none are given.
+var DefaultSizeBuckets = []int64{1 * KB, 4 * KB, 64 * KB, 1 * MB, 16 * MB, 256 * MB, 1 * GB}
+
+// TarSizeHistogram counts the regular files within the given tar by the number of content bytes actually read for
+// each (which is not necessarily the size declared in the header). Each bucket is an inclusive upper bound, so a file
+// is counted in the smallest bucket it fits within, and files larger than all buckets are counted under
+// math.MaxInt64. Every bucket is present in the result, even when no file falls into it. DefaultSizeBuckets are used
+// when no buckets are given.
+func TarSizeHistogram(reader io.Reader, buckets []int64) (map[int64]int, error) {
+	if len(buckets) == 0 {
+		buckets = DefaultSizeBuckets
+	}
+	bounds := append([]int64(nil), buckets...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	bounds = append(bounds, math.MaxInt64)
+
+	histogram := make(map[int64]int, len(bounds))
+	for _, bound := range bounds {
+		histogram[bound] = 0
+	}
+
+	visitor := func(entry TarFileEntry) error {
+		if entry.Header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		n, err := io.Copy(io.Discard, entry.Reader)
+		if err != nil {
+			return fmt.Errorf("unable to read tar entry=%q: %w", entry.Header.Name, err)
+		}
+		i := sort.Search(len(bounds), func(i int) bool { return bounds[i] >= n })
+		histogram[bounds[i]]++
+		return nil
+	}
+	if err := IterateTar(reader, visitor); err != nil {
+		return nil, err
+	}
+	return histogram, nil
+}
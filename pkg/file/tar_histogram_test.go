@@ -0,0 +1,53 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarSizeHistogram(t *testing.T) {
+	reg := func(name string, size int) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name}, content: strings.Repeat("x", size)}
+	}
+	entries := []testTarEntry{
+		{header: tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755}},
+		reg("dir/empty", 0),
+		reg("dir/tiny", 10),
+		reg("dir/exactly-bound", 100),
+		reg("dir/small", 101),
+		reg("dir/medium", 1000),
+		reg("dir/large", 5000),
+		{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "dir/link", Linkname: "large"}},
+	}
+
+	tests := []struct {
+		name    string
+		buckets []int64
+		want    map[int64]int
+	}{
+		{
+			name:    "custom buckets (in any order)",
+			buckets: []int64{1000, 100},
+			want:    map[int64]int{100: 3, 1000: 2, math.MaxInt64: 1},
+		},
+		{
+			name: "default buckets",
+			want: map[int64]int{1 * KB: 5, 4 * KB: 0, 64 * KB: 1, 1 * MB: 0, 16 * MB: 0, 256 * MB: 0, 1 * GB: 0, math.MaxInt64: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			histogram, err := TarSizeHistogram(newTestTar(t, entries...), tt.buckets)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, histogram)
+		})
+	}
+}
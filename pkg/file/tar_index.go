@@ -1,45 +1,78 @@
 package file
 
 import (
+	"archive/tar"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+
+	"github.com/anchore/stereoscope/internal/log"
 )
 
+// maxHardlinkDepth bounds how many hardlinks are followed when resolving an entry within the index.
+const maxHardlinkDepth = 32
+
+// defaultMmapThreshold is the entry size from which Open memory maps content instead of reading it from the file.
+const defaultMmapThreshold = 8 * MB
+
 type TarIndexVisitor func(TarIndexEntry) error
 
-// TarIndex is a tar reader capable of O(1) fetching of entry contents after the first read.
+// TarIndex is a tar reader capable of O(1) fetching of entry contents after the first read. Once created, a TarIndex
+// is safe for concurrent use, with every reader returned from Open being independent of all others.
 type TarIndex struct {
 	indexByName map[string][]TarIndexEntry
+	// mmapThreshold is the entry size from which Open memory maps content (0 disables memory mapping)
+	mmapThreshold int64
+	// cache holds the content of recently opened entries (only when configured, see WithContentCache)
+	cache *contentCache
 }
 
 // NewTarIndex creates a new TarIndex that is already indexed.
-func NewTarIndex(tarFilePath string, onIndex TarIndexVisitor) (*TarIndex, error) {
-	t := &TarIndex{
-		indexByName: make(map[string][]TarIndexEntry),
-	}
+func NewTarIndex(tarFilePath string, onIndex TarIndexVisitor, options ...TarIndexOption) (*TarIndex, error) {
 	tarFileHandle, err := os.Open(tarFilePath)
 	if err != nil {
 		return nil, err
 	}
 	defer tarFileHandle.Close()
 
+	return newTarIndex(tarFileHandle, TarIndexEntry{path: tarFileHandle.Name()}, onIndex, options...)
+}
+
+// NewTarIndexFromReaderAt creates a new TarIndex over the tar of the given size within the given reader. Since entry
+// contents are read with independent section readers, entries may be opened and read from multiple goroutines
+// concurrently (provided the underlying reader supports concurrent ReadAt calls, as files do).
+func NewTarIndexFromReaderAt(reader io.ReaderAt, size int64, onIndex TarIndexVisitor, options ...TarIndexOption) (*TarIndex, error) {
+	return newTarIndex(io.NewSectionReader(reader, 0, size), TarIndexEntry{readerAt: reader}, onIndex, options...)
+}
+
+// newTarIndex indexes the tar read from the given seeker, where each entry refers to the same content source as the
+// given source entry.
+func newTarIndex(tarSeeker io.ReadSeeker, source TarIndexEntry, onIndex TarIndexVisitor, options ...TarIndexOption) (*TarIndex, error) {
+	t := &TarIndex{
+		indexByName:   make(map[string][]TarIndexEntry),
+		mmapThreshold: defaultMmapThreshold,
+	}
+	for _, option := range options {
+		if option != nil {
+			option(t)
+		}
+	}
+
 	visitor := func(entry TarFileEntry) error {
 		// keep track of the current location (just after reading the tar header) as this is the file content for the
 		// current entry being processed.
-		entrySeekPosition, err := tarFileHandle.Seek(0, io.SeekCurrent)
+		entrySeekPosition, err := tarSeeker.Seek(0, io.SeekCurrent)
 		if err != nil {
 			return fmt.Errorf("unable to read current position in tar: %v", err)
 		}
 
-		// keep track of the header position for this entry; the current tarFileHandle position is where the entry
+		// keep track of the header position for this entry; the current tarSeeker position is where the entry
 		// body payload starts (after the header has been read).
-		indexEntry := TarIndexEntry{
-			path:         tarFileHandle.Name(),
-			sequence:     entry.Sequence,
-			header:       entry.Header,
-			seekPosition: entrySeekPosition,
-		}
+		indexEntry := source
+		indexEntry.sequence = entry.Sequence
+		indexEntry.header = entry.Header
+		indexEntry.seekPosition = entrySeekPosition
 		t.indexByName[entry.Header.Name] = append(t.indexByName[entry.Header.Name], indexEntry)
 
 		// run though the visitors
@@ -52,7 +85,7 @@ func NewTarIndex(tarFilePath string, onIndex TarIndexVisitor) (*TarIndex, error)
 		return nil
 	}
 
-	return t, IterateTar(tarFileHandle, visitor)
+	return t, IterateTar(tarSeeker, visitor)
 }
 
 // EntriesByName fetches all TarFileEntries for the given tar header name.
@@ -66,3 +99,83 @@ func (t *TarIndex) EntriesByName(name string) ([]TarFileEntry, error) {
 	}
 	return nil, nil
 }
+
+// Open returns the content of the last entry with the given tar header name. Hardlink entries are resolved to the
+// content of the entry they link to (the most recent entry with the link name that precedes the hardlink), which must
+// be within the index. Large entries are read through a memory mapping where supported, which speeds up repeated reads
+// (the mapping is released on Close), and small entries are served from memory when a content cache is configured
+// (see WithContentCache).
+func (t *TarIndex) Open(name string) (io.ReadCloser, error) {
+	if t.cache != nil {
+		if content, ok := t.cache.get(name); ok {
+			return io.NopCloser(bytes.NewReader(content)), nil
+		}
+	}
+
+	entry, ok := t.lastEntry(name, -1)
+	if !ok {
+		return nil, &ErrFileNotFound{Path: name}
+	}
+
+	for depth := 0; entry.header.Typeflag == tar.TypeLink; depth++ {
+		if depth >= maxHardlinkDepth {
+			return nil, fmt.Errorf("exceeded max hardlink depth of %d resolving %q", maxHardlinkDepth, name)
+		}
+		target, ok := t.lastEntry(entry.header.Linkname, entry.sequence)
+		if !ok {
+			return nil, fmt.Errorf("unable to resolve hardlink %q: %w", entry.header.Name, &ErrFileNotFound{Path: entry.header.Linkname})
+		}
+		entry = target
+	}
+	if t.cache != nil && t.cacheable(entry) {
+		return t.openCached(name, entry)
+	}
+	return t.open(entry), nil
+}
+
+// cacheable reports whether the content of the given entry is kept in the content cache once read.
+func (t *TarIndex) cacheable(entry TarIndexEntry) bool {
+	if t.mmapThreshold > 0 && entry.header.Size >= t.mmapThreshold {
+		return false
+	}
+	return t.cache.cacheable(entry.header.Size)
+}
+
+// openCached reads the entire content of the given entry into the content cache under the name it was opened with.
+func (t *TarIndex) openCached(name string, entry TarIndexEntry) (io.ReadCloser, error) {
+	reader := t.open(entry)
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %q from tar: %w", name, err)
+	}
+	t.cache.add(name, content)
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// open returns the content of the given entry, memory mapped when it is at least the threshold size and indexed from a
+// file (falling back to reading from the file when mapping is not possible).
+func (t *TarIndex) open(entry TarIndexEntry) io.ReadCloser {
+	if entry.path == "" || t.mmapThreshold <= 0 || entry.header.Size < t.mmapThreshold {
+		return entry.Open()
+	}
+	mapped, err := mmapSection(entry.path, entry.seekPosition, entry.header.Size)
+	if err != nil {
+		log.WithFields("path", entry.header.Name, "error", err).Trace("unable to memory map tar entry, reading from file")
+		return entry.Open()
+	}
+	return mapped
+}
+
+// lastEntry returns the last entry with the given name that precedes the given sequence (or the last entry overall for
+// a negative sequence).
+func (t *TarIndex) lastEntry(name string, before int64) (TarIndexEntry, bool) {
+	entries := t.indexByName[name]
+	for i := len(entries) - 1; i >= 0; i-- {
+		if before < 0 || entries[i].sequence < before {
+			return entries[i], true
+		}
+	}
+	return TarIndexEntry{}, false
+}
@@ -0,0 +1,86 @@
+package file
+
+import (
+	"container/list"
+	"sync"
+)
+
+// TarIndexOption configures a TarIndex when it is created.
+type TarIndexOption func(*TarIndex)
+
+// WithContentCache keeps the content of recently opened entries in memory, up to the given total number of bytes, so
+// that opening the same entry again (such as config files read repeatedly while resolving paths) does not read it from
+// the archive again. Only entries smaller than the memory mapping threshold are cached, since larger entries are
+// memory mapped instead. The least recently opened entries are evicted once the budget is exceeded.
+func WithContentCache(maxBytes int64) TarIndexOption {
+	return func(t *TarIndex) {
+		if maxBytes > 0 {
+			t.cache = newContentCache(maxBytes)
+		}
+	}
+}
+
+// contentCache is an LRU cache of entry content keyed by the name the entry was opened with, bounded by the total size
+// of the cached content. A contentCache is safe for concurrent use.
+type contentCache struct {
+	lock     sync.Mutex
+	maxBytes int64
+	size     int64
+	entries  map[string]*list.Element
+	// order holds the cached content from most to least recently used
+	order *list.List
+}
+
+type cachedContent struct {
+	name    string
+	content []byte
+}
+
+func newContentCache(maxBytes int64) *contentCache {
+	return &contentCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// cacheable reports whether content of the given size fits within the cache at all.
+func (c *contentCache) cacheable(size int64) bool {
+	return size <= c.maxBytes
+}
+
+// get returns the cached content for the given name, marking it as the most recently used. The content is shared by
+// all readers of the entry, so must not be modified.
+func (c *contentCache) get(name string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[name]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedContent).content, true
+}
+
+// add caches the content for the given name as the most recently used, evicting the least recently used content
+// until the cache is within its budget.
+func (c *contentCache) add(name string, content []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[name]; ok {
+		// another reader cached the same entry in the meantime
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[name] = c.order.PushFront(&cachedContent{name: name, content: content})
+	c.size += int64(len(content))
+
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		evicted := c.order.Remove(oldest).(*cachedContent)
+		delete(c.entries, evicted.name)
+		c.size -= int64(len(evicted.content))
+	}
+}
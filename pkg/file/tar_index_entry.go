@@ -6,7 +6,9 @@ import (
 )
 
 type TarIndexEntry struct {
-	path         string
+	path string
+	// readerAt is the source of the entry content when indexed from an io.ReaderAt instead of a file path
+	readerAt     io.ReaderAt
 	sequence     int64
 	header       tar.Header
 	seekPosition int64
@@ -20,6 +22,10 @@ func (t *TarIndexEntry) ToTarFileEntry() TarFileEntry {
 	}
 }
 
+// Open returns a new reader of the entry content, which shares no state with other readers of the same entry.
 func (t *TarIndexEntry) Open() io.ReadCloser {
+	if t.readerAt != nil {
+		return io.NopCloser(io.NewSectionReader(t.readerAt, t.seekPosition, t.header.Size))
+	}
 	return newLazyBoundedReadCloser(t.path, t.seekPosition, t.header.Size)
 }
@@ -0,0 +1,76 @@
+//go:build linux
+// +build linux
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTarIndex_OpenMemoryMapped(t *testing.T) {
+	// the large entry does not start at a page boundary, as it is preceded by a small entry
+	large := bytes.Repeat([]byte("0123456789abcdef"), 3*os.Getpagesize()/16+1)
+	tarPath := filepath.Join(t.TempDir(), "large.tar")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatalf("could not create tar: %+v", err)
+	}
+	tarWriter := tar.NewWriter(f)
+	addFileToTarWriter(t, "small", "small contents", tarWriter)
+	addFileToTarWriter(t, "large", string(large), tarWriter)
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("could not close tar: %+v", err)
+	}
+	f.Close()
+
+	index, err := NewTarIndex(tarPath, nil)
+	if err != nil {
+		t.Fatalf("could not index tar: %+v", err)
+	}
+	index.mmapThreshold = int64(os.Getpagesize())
+
+	reader, err := index.Open("large")
+	if err != nil {
+		t.Fatalf("could not open entry: %+v", err)
+	}
+	mapped, ok := reader.(*mmapReadCloser)
+	if !ok {
+		t.Fatalf("expected a memory mapped reader, got %T", reader)
+	}
+
+	contents, err := io.ReadAll(mapped)
+	if err != nil {
+		t.Fatalf("could not read entry: %+v", err)
+	}
+	if !bytes.Equal(contents, large) {
+		t.Errorf("unexpected contents (len=%d, expected len=%d)", len(contents), len(large))
+	}
+
+	if err := mapped.Close(); err != nil {
+		t.Fatalf("could not close entry: %+v", err)
+	}
+	if mapped.mapping != nil {
+		t.Error("expected the mapping to be released on close")
+	}
+	if n, err := mapped.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("expected reads after close to hit EOF, got n=%d err=%v", n, err)
+	}
+	if err := mapped.Close(); err != nil {
+		t.Errorf("expected closing twice to be a no-op: %+v", err)
+	}
+
+	// small entries are read from the file as usual
+	small, err := index.Open("small")
+	if err != nil {
+		t.Fatalf("could not open entry: %+v", err)
+	}
+	defer small.Close()
+	if _, ok := small.(*mmapReadCloser); ok {
+		t.Error("expected entries below the threshold to not be memory mapped")
+	}
+}
@@ -5,10 +5,15 @@ package file
 
 import (
 	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -106,6 +111,210 @@ func TestIndexedTarReader_DuplicateEntries(t *testing.T) {
 
 }
 
+func TestTarIndex_OpenHardlink(t *testing.T) {
+	fixture := hardlinkTarballFixture(t)
+
+	index, err := NewTarIndex(fixture.Name(), nil)
+	if err != nil {
+		t.Fatal("could not index tar:", err)
+	}
+
+	for _, name := range []string{"a/target", "a/link", "a/link-to-link"} {
+		rc, err := index.Open(name)
+		if err != nil {
+			t.Fatalf("unable to open %q: %+v", name, err)
+		}
+		contents, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("could not read %q: %+v", name, err)
+		}
+		rc.Close()
+
+		if string(contents) != "target contents" {
+			t.Errorf("unexpected contents for name=%q: '%s'", name, string(contents))
+		}
+	}
+
+	var notFound *ErrFileNotFound
+	if _, err := index.Open("a/dangling"); !errors.As(err, &notFound) || notFound.Path != "a/missing" {
+		t.Errorf("expected the missing hardlink target to be reported, got: %+v", err)
+	}
+
+	if _, err := index.Open("a/nonexistent"); !errors.As(err, &notFound) {
+		t.Errorf("expected ErrFileNotFound, got: %+v", err)
+	}
+}
+
+func TestTarIndex_ConcurrentOpenFromReaderAt(t *testing.T) {
+	const entries = 100
+	const workers = 16
+
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for i := 0; i < entries; i++ {
+		addFileToTarWriter(t, fmt.Sprintf("file-%d", i), strings.Repeat(fmt.Sprintf("contents-%d;", i), i+1), tarWriter)
+	}
+	addHardlinkToTarWriter(t, "link", "file-0", tarWriter)
+	tarWriter.Close()
+
+	index, err := NewTarIndexFromReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil)
+	if err != nil {
+		t.Fatal("could not index tar:", err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for j := 0; j < entries; j++ {
+				// each worker starts at a different entry so that reads of the same entry overlap across workers
+				i := (j + w) % entries
+				name := fmt.Sprintf("file-%d", i)
+				rc, err := index.Open(name)
+				if err != nil {
+					t.Errorf("unable to open %q: %+v", name, err)
+					return
+				}
+				// reading a byte at a time interleaves reads across workers as much as possible
+				contents, err := io.ReadAll(iotest.OneByteReader(rc))
+				rc.Close()
+				if err != nil {
+					t.Errorf("could not read %q: %+v", name, err)
+					return
+				}
+				if expected := strings.Repeat(fmt.Sprintf("contents-%d;", i), i+1); string(contents) != expected {
+					t.Errorf("unexpected contents for name=%q: '%s'", name, string(contents))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	rc, err := index.Open("link")
+	if err != nil {
+		t.Fatalf("unable to open link: %+v", err)
+	}
+	defer rc.Close()
+	if contents, _ := io.ReadAll(rc); string(contents) != "contents-0;" {
+		t.Errorf("unexpected contents for link: '%s'", string(contents))
+	}
+}
+
+// countingReaderAt counts the reads of distinct offsets of the underlying reader.
+type countingReaderAt struct {
+	reader  io.ReaderAt
+	offsets map[int64]struct{}
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.offsets[off] = struct{}{}
+	return c.reader.ReadAt(p, off)
+}
+
+func TestTarIndex_OpenWithContentCache(t *testing.T) {
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for _, name := range []string{"a", "b", "c"} {
+		addFileToTarWriter(t, name, strings.Repeat(name, 10), tarWriter)
+	}
+	addFileToTarWriter(t, "large", strings.Repeat("l", 30), tarWriter)
+	addHardlinkToTarWriter(t, "link", "b", tarWriter)
+	tarWriter.Close()
+
+	reader := &countingReaderAt{reader: bytes.NewReader(buf.Bytes()), offsets: make(map[int64]struct{})}
+	index, err := NewTarIndexFromReaderAt(reader, int64(buf.Len()), nil, WithContentCache(20))
+	if err != nil {
+		t.Fatal("could not index tar:", err)
+	}
+
+	for _, test := range []struct {
+		name string
+		// read is whether the content is expected to be read from the archive (rather than the cache)
+		read bool
+	}{
+		{name: "a", read: true},
+		{name: "b", read: true},
+		{name: "a"},
+		// evicts b, the least recently used
+		{name: "c", read: true},
+		{name: "a"},
+		{name: "b", read: true},
+		// hardlinks are cached by their own name
+		{name: "link", read: true},
+		{name: "link"},
+		// entries larger than the budget are never cached
+		{name: "large", read: true},
+		{name: "large", read: true},
+	} {
+		reader.offsets = make(map[int64]struct{})
+		rc, err := index.Open(test.name)
+		if err != nil {
+			t.Fatalf("unable to open %q: %+v", test.name, err)
+		}
+		contents, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("could not read %q: %+v", test.name, err)
+		}
+
+		expected := strings.Repeat(test.name, 10)
+		switch test.name {
+		case "link":
+			expected = strings.Repeat("b", 10)
+		case "large":
+			expected = strings.Repeat("l", 30)
+		}
+		if string(contents) != expected {
+			t.Errorf("unexpected contents for name=%q: '%s'", test.name, string(contents))
+		}
+		if read := len(reader.offsets) > 0; read != test.read {
+			t.Errorf("unexpected read from the archive for name=%q: read=%t", test.name, read)
+		}
+	}
+}
+
+func hardlinkTarballFixture(t *testing.T) *os.File {
+	tempFile, err := os.CreateTemp("", "stereoscope-hardlink-tar-fixture-XXXXXX")
+	if err != nil {
+		t.Fatalf("could not create tempfile: %+v", err)
+	}
+	t.Cleanup(func() {
+		os.Remove(tempFile.Name())
+	})
+
+	tarWriter := tar.NewWriter(tempFile)
+
+	addFileToTarWriter(t, "a/target", "target contents", tarWriter)
+	for name, target := range map[string]string{"a/link": "a/target", "a/dangling": "a/missing"} {
+		addHardlinkToTarWriter(t, name, target, tarWriter)
+	}
+	addHardlinkToTarWriter(t, "a/link-to-link", "a/link", tarWriter)
+
+	tarWriter.Close()
+	tempFile.Close()
+
+	fh, err := os.Open(tempFile.Name())
+	if err != nil {
+		t.Fatalf("failed to open tar: %+v", err)
+	}
+
+	return fh
+}
+
+func addHardlinkToTarWriter(t *testing.T, path, target string, tarWriter *tar.Writer) {
+	header := &tar.Header{
+		Typeflag: tar.TypeLink,
+		Name:     path,
+		Linkname: target,
+		Mode:     44,
+		ModTime:  time.Now(),
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		t.Fatalf("could not write hardlink header: %+v", err)
+	}
+}
+
 func duplicateEntryTarballFixture(t *testing.T) *os.File {
 	tempFile, err := os.CreateTemp("", "stereoscope-dup-tar-entry-fixture-XXXXXX")
 	if err != nil {
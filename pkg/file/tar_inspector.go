@@ -0,0 +1,169 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// maxUSTARSize is the largest size representable by the 11 octal digits of the ustar size field.
+const maxUSTARSize = 1<<33 - 1
+
+// tarInspector performs the per-header checks of a single iteration over a tar, holding any state those checks need.
+type tarInspector struct {
+	cfg UntarOptions
+	// seen maps the cleaned name of every entry to the sequence it first appeared at (only when detecting duplicates)
+	seen map[string]int64
+	// headers retains the raw header block of the current entry (only when validating PAX sizes)
+	headers *headerRecorder
+	// previous is the cleaned name of the last entry inspected (only when requiring sorted entries)
+	previous *string
+}
+
+func newTarInspector(cfg UntarOptions, headers *headerRecorder) *tarInspector {
+	i := &tarInspector{cfg: cfg, headers: headers}
+	if cfg.OnDuplicatePath != nil {
+		i.seen = make(map[string]int64)
+	}
+	return i
+}
+
+// inspect is called for every header read, before the entry is visited. Returning an error aborts iteration.
+func (i *tarInspector) inspect(sequence int64, hdr *tar.Header) error {
+	if i.cfg.OnWarning != nil {
+		if warning := headerWarning(hdr); warning != nil {
+			i.cfg.OnWarning(sequence, warning)
+		}
+	}
+
+	if i.seen != nil {
+		name := cleanTarEntryName(hdr.Name)
+		if first, ok := i.seen[name]; ok {
+			i.cfg.OnDuplicatePath(name, first, sequence)
+		} else {
+			i.seen[name] = sequence
+		}
+	}
+
+	if i.cfg.RejectUnsafeLinks && isUnsafeLinkTarget(hdr) {
+		i.cfg.metrics().Inc(MetricTraversalsBlocked)
+		return &ErrUnsafeLinkTarget{Name: hdr.Name, Linkname: hdr.Linkname}
+	}
+
+	if i.cfg.RejectDevices && isDevice(hdr) {
+		return &ErrDeviceEntry{Name: hdr.Name, Type: TypeFromTarType(hdr.Typeflag)}
+	}
+
+	if i.cfg.RequireSorted {
+		if err := i.checkSorted(hdr); err != nil {
+			return err
+		}
+	}
+
+	if i.headers != nil {
+		return i.checkPAXSize(hdr)
+	}
+	return nil
+}
+
+// isUnsafeLinkTarget indicates if the given entry is a link whose target is absolute or resolves outside of the root
+// of the archive. Hardlink targets are relative to the root of the archive, while symlink targets are relative to the
+// directory containing the symlink.
+func isUnsafeLinkTarget(hdr *tar.Header) bool {
+	var base string
+	switch hdr.Typeflag {
+	case tar.TypeLink:
+		base = "."
+	case tar.TypeSymlink:
+		base = path.Dir(cleanTarEntryName(hdr.Name))
+	default:
+		return false
+	}
+	if path.IsAbs(hdr.Linkname) {
+		return true
+	}
+	resolved := path.Join(base, hdr.Linkname)
+	return resolved == ".." || strings.HasPrefix(resolved, "../")
+}
+
+// isDevice indicates if the given entry is a character or block device.
+func isDevice(hdr *tar.Header) bool {
+	return hdr.Typeflag == tar.TypeChar || hdr.Typeflag == tar.TypeBlock
+}
+
+// checkSorted verifies that the given entry does not sort before the entry preceding it, in the same order as entries
+// are written when sorting (see WithSortEntries), such that duplicate names are permitted. Global headers describe the
+// archive rather than an entry, so are not part of the order.
+func (i *tarInspector) checkSorted(hdr *tar.Header) error {
+	if hdr.Typeflag == tar.TypeXGlobalHeader {
+		return nil
+	}
+	name := cleanTarEntryName(hdr.Name)
+	if i.previous != nil && name < *i.previous {
+		return &ErrUnsortedArchive{Previous: *i.previous, Name: name}
+	}
+	i.previous = &name
+	return nil
+}
+
+// checkPAXSize compares a PAX size record against the size field of the raw ustar header block. Readers that ignore
+// PAX records will use the ustar size, so any disagreement allows for presenting different content (or entirely
+// different entries) to different tools. The ustar field is permitted to be zero only when the PAX size would not fit,
+// which is how writers encode very large files.
+func (i *tarInspector) checkPAXSize(hdr *tar.Header) error {
+	record, ok := hdr.PAXRecords["size"]
+	if !ok || hdr.Typeflag == tar.TypeGNUSparse {
+		// sparse headers may be followed by extension blocks, so the last block read is not the header
+		return nil
+	}
+	block := i.headers.lastBlock()
+	if block == nil {
+		return nil
+	}
+	paxSize, err := strconv.ParseInt(record, 10, 64)
+	if err != nil {
+		// the tar reader would have already rejected this
+		return nil
+	}
+	ustarSize, ok := parseUSTARSize(block)
+	if !ok {
+		return &ErrSizeDisagreement{Name: hdr.Name, PAXSize: paxSize, USTARSize: -1}
+	}
+	if ustarSize == paxSize || (ustarSize == 0 && paxSize > maxUSTARSize) {
+		return nil
+	}
+	return &ErrSizeDisagreement{Name: hdr.Name, PAXSize: paxSize, USTARSize: ustarSize}
+}
+
+// parseUSTARSize parses the size field of a raw header block.
+func parseUSTARSize(block []byte) (int64, bool) {
+	return parseTarNumeric(block[124:136])
+}
+
+// parseTarNumeric parses a numeric field of a raw header block, which is either octal or (as a GNU extension) base-256.
+func parseTarNumeric(field []byte) (int64, bool) {
+	if field[0]&0x80 != 0 {
+		var size int64
+		for idx, b := range field {
+			if idx == 0 {
+				b &= 0x7f
+			}
+			if size > (1<<55)-1 {
+				return 0, false
+			}
+			size = size<<8 | int64(b)
+		}
+		return size, true
+	}
+	field = bytes.Trim(field, " \x00")
+	if len(field) == 0 {
+		return 0, true
+	}
+	size, err := strconv.ParseInt(string(field), 8, 64)
+	if err != nil {
+		return 0, false
+	}
+	return size, true
+}
@@ -0,0 +1,33 @@
+//go:build go1.23
+// +build go1.23
+
+package file
+
+import (
+	"io"
+	"iter"
+)
+
+// TarEntries returns an iterator over the entries of the given tar, which can be used in place of IterateTar as:
+//
+//	for entry, err := range TarEntries(reader) {
+//		...
+//	}
+//
+// As with visitors, the Reader of each entry is only valid until the next entry is requested. Iteration is lazy: no
+// more of the tar is read than what was iterated over, so breaking out of the loop leaves the underlying reader
+// positioned within the archive (it should not be used for any further tar reading). A failure is yielded once,
+// with a zero entry, after which iteration ends.
+func TarEntries(reader io.Reader, options ...Option) iter.Seq2[TarFileEntry, error] {
+	return func(yield func(TarFileEntry, error) bool) {
+		visitor := func(entry TarFileEntry) error {
+			if !yield(entry, nil) {
+				return ErrTarStopIteration
+			}
+			return nil
+		}
+		if err := IterateTar(reader, visitor, options...); err != nil {
+			yield(TarFileEntry{}, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+//go:build go1.23 && !windows
+// +build go1.23,!windows
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarEntries(t *testing.T) {
+	newReader := func() *bytes.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts"}, content: "localhost"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/passwd"}, content: "root"},
+		)
+	}
+
+	t.Run("full iteration", func(t *testing.T) {
+		got := make(map[string]string)
+		var names []string
+		for entry, err := range TarEntries(newReader()) {
+			require.NoError(t, err)
+			content, err := io.ReadAll(entry.Reader)
+			require.NoError(t, err)
+			names = append(names, entry.Header.Name)
+			got[entry.Header.Name] = string(content)
+		}
+		assert.Equal(t, []string{"etc/", "etc/hosts", "etc/passwd"}, names)
+		assert.Equal(t, "localhost", got["etc/hosts"])
+		assert.Equal(t, "root", got["etc/passwd"])
+	})
+
+	t.Run("early break stops reading", func(t *testing.T) {
+		reader := newReader()
+		var names []string
+		for entry, err := range TarEntries(reader) {
+			require.NoError(t, err)
+			names = append(names, entry.Header.Name)
+			if entry.Header.Name == "etc/hosts" {
+				break
+			}
+		}
+		assert.Equal(t, []string{"etc/", "etc/hosts"}, names)
+		assert.Greater(t, reader.Len(), 0, "the remainder of the archive should not be read")
+	})
+
+	t.Run("errors are yielded once", func(t *testing.T) {
+		var errs []error
+		for entry, err := range TarEntries(bytes.NewReader([]byte("not a tar archive, but long enough to fail parsing the header block............"))) {
+			if err != nil {
+				errs = append(errs, err)
+				assert.Empty(t, entry.Header.Name)
+			}
+		}
+		require.Len(t, errs, 1)
+	})
+}
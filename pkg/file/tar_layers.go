@@ -0,0 +1,200 @@
+package file
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scylladb/go-set/strset"
+	"github.com/spf13/afero"
+)
+
+// UntarLayers extracts each of the given layer tars into its own subdirectory of baseDst (named "layer-<index>"),
+// returning the created directories in layer order. Extraction stops at the first layer that fails.
+func UntarLayers(readers []io.Reader, baseDst string, options ...Option) ([]string, error) {
+	extractor := NewExtractor(options...)
+	var dirs []string
+	for i, reader := range readers {
+		dst := filepath.Join(baseDst, fmt.Sprintf("layer-%d", i))
+		dirs = append(dirs, dst)
+
+		if err := extractor.Untar(reader, dst); err != nil {
+			return dirs, fmt.Errorf("unable to extract layer index=%d: %w", i, err)
+		}
+	}
+	return dirs, nil
+}
+
+// ExtractLayersSeparately extracts each layer tar into its own directory under root, preserving layer boundaries for
+// tools that diff or scan individual layers. This is UntarLayers with the default options.
+func ExtractLayersSeparately(layers []io.Reader, root string) ([]string, error) {
+	return UntarLayers(layers, root)
+}
+
+// UntarLayersSquashed extracts the given layer tars in order into a single destination, producing the squashed
+// filesystem on disk. Entries from later layers replace those from earlier layers (of any kind, regardless of
+// WithTypeConflictPolicy), and OCI whiteouts (".wh.<name>") and opaque directory markers (".wh..wh..opq") remove content
+// contributed by earlier layers. All safety guards of UntarToDirectory apply to each layer, and entry names are
+// remapped (see WithNameSanitizer) before whiteouts are recognized.
+//
+// The returned map attributes each extracted path in the squashed result (relative to dst, slash separated) to the
+// index of the layer that ultimately produced it.
+func UntarLayersSquashed(readers []io.Reader, dst string, options ...Option) (map[string]int, error) {
+	extractor := NewExtractor(options...)
+	if err := extractor.prepareDestination(dst); err != nil {
+		return nil, err
+	}
+	sources := make(map[string]int)
+	for i, reader := range readers {
+		tv := extractor.newVisitor(dst)
+		// later layers replace the nodes of earlier layers, whatever their kind
+		tv.options.OnTypeConflict = TypeConflictReplace
+		v := squashVisitor{
+			tarVisitor: tv,
+			written:    strset.New(),
+			layer:      i,
+			sources:    sources,
+		}
+		if err := iterateTar(reader, v.visit, extractor.options); err != nil {
+			return sources, fmt.Errorf("unable to extract layer index=%d: %w", i, err)
+		}
+		if err := v.applyDirectoryHeaders(); err != nil {
+			return sources, fmt.Errorf("unable to extract layer index=%d: %w", i, err)
+		}
+	}
+	return sources, nil
+}
+
+// squashVisitor extracts a single layer on top of the content of previously extracted layers.
+type squashVisitor struct {
+	tarVisitor
+	// written are the targets written by the current layer, which whiteouts within the same layer must not remove
+	written *strset.Set
+	// layer is the index of the layer being extracted
+	layer int
+	// sources maps each extracted path (relative to the destination) to the layer that produced it, across all layers
+	sources map[string]int
+}
+
+func (v squashVisitor) visit(entry TarFileEntry) error {
+	// the name is resolved once, so that whiteouts and the attribution of content apply to the path actually written
+	entry, err := v.named(entry)
+	if err != nil {
+		return err
+	}
+	target, err := v.targetPath(entry.Header.Name)
+	if err != nil {
+		v.options.metrics().Inc(MetricTraversalsBlocked)
+		return err
+	}
+
+	switch base, kind := ClassifyWhiteout(entry.Header.Name); kind {
+	case WhiteoutOpaque:
+		_, err := v.removeOpaqueChildren(filepath.Dir(target))
+		return err
+	case WhiteoutFile:
+		lowerTarget, err := v.targetPath(base)
+		if err != nil {
+			v.options.metrics().Inc(MetricTraversalsBlocked)
+			return err
+		}
+		// whiteouts only apply to lower layers, and never to the destination itself
+		if lowerTarget == filepath.Clean(v.destination) || v.written.Has(lowerTarget) {
+			return nil
+		}
+		return v.remove(lowerTarget)
+	}
+
+	// nodes of lower layers replaced by a different kind of node are removed only once the entry passes validation
+	// (see UntarLayersSquashed, which replaces them on type conflicts)
+	if err := v.visitNamed(entry); err != nil {
+		return err
+	}
+	v.written.Add(target)
+	rel, ok := v.relativePath(target)
+	if !ok {
+		return nil
+	}
+	if entry.Header.Typeflag != tar.TypeDir {
+		// entries that are not extracted (such as symlinks) still replace a lower directory at their path
+		if info, err := v.fs.Stat(target); err == nil && info.IsDir() {
+			if err := v.remove(target); err != nil {
+				return err
+			}
+		}
+		v.dropSources(rel + "/")
+	}
+	if entry.Header.Typeflag == tar.TypeDir || entry.Header.Typeflag == tar.TypeReg {
+		v.sources[rel] = v.layer
+	}
+	return nil
+}
+
+// remove deletes the given target (and everything beneath it), dropping the attribution of all removed paths.
+func (v squashVisitor) remove(target string) error {
+	if err := v.fs.RemoveAll(target); err != nil {
+		return err
+	}
+	rel, ok := v.relativePath(target)
+	if !ok {
+		return nil
+	}
+	delete(v.sources, rel)
+	v.dropSources(rel + "/")
+	return nil
+}
+
+// dropSources drops the attribution of all paths with the given prefix (relative to the destination).
+func (v squashVisitor) dropSources(prefix string) {
+	for p := range v.sources {
+		if strings.HasPrefix(p, prefix) {
+			delete(v.sources, p)
+		}
+	}
+}
+
+// relativePath returns the slash separated path of the given target relative to the destination, which is false for
+// the destination itself.
+func (v squashVisitor) relativePath(target string) (string, bool) {
+	rel, err := filepath.Rel(v.destination, target)
+	if err != nil || rel == "." {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// removeOpaqueChildren removes all content within the given directory (at any depth) that was contributed by earlier
+// layers, reporting whether any content written by the current layer remains within it. Directories re-created by the
+// current layer (or holding content it wrote) are kept, while their lower content is removed.
+func (v squashVisitor) removeOpaqueChildren(dir string) (bool, error) {
+	children, err := afero.ReadDir(v.fs, dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	var keptAny bool
+	for _, child := range children {
+		childPath := filepath.Join(dir, child.Name())
+		kept := v.written.Has(childPath)
+		if child.IsDir() {
+			within, err := v.removeOpaqueChildren(childPath)
+			if err != nil {
+				return false, err
+			}
+			kept = kept || within
+		}
+		if !kept {
+			if err := v.remove(childPath); err != nil {
+				return false, err
+			}
+			continue
+		}
+		keptAny = true
+	}
+	return keptAny, nil
+}
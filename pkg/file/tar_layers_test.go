@@ -0,0 +1,249 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUntarLayers(t *testing.T) {
+	base := t.TempDir()
+	layers := []io.Reader{
+		newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "a.txt"}, content: "layer 0"}),
+		newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "b.txt"}, content: "layer 1"}),
+	}
+
+	dirs, err := UntarLayers(layers, base)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(base, "layer-0"), filepath.Join(base, "layer-1")}, dirs)
+
+	content, err := os.ReadFile(filepath.Join(dirs[0], "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "layer 0", string(content))
+
+	content, err = os.ReadFile(filepath.Join(dirs[1], "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "layer 1", string(content))
+}
+
+func TestUntarLayers_ReportsFailingLayer(t *testing.T) {
+	layers := []io.Reader{
+		newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "a.txt"}, content: "fine"}),
+		newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "../escape.txt"}, content: "bad"}),
+		newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "c.txt"}, content: "never"}),
+	}
+
+	dirs, err := UntarLayers(layers, t.TempDir())
+	require.ErrorContains(t, err, "layer index=1")
+	assert.Len(t, dirs, 2)
+}
+
+func TestExtractLayersSeparately(t *testing.T) {
+	layers := []io.Reader{
+		newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/file"}, content: "lower"},
+		),
+		newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/file"}, content: "upper"},
+		),
+	}
+
+	dirs, err := ExtractLayersSeparately(layers, t.TempDir())
+	require.NoError(t, err)
+	require.Len(t, dirs, 2)
+	assert.NotEqual(t, dirs[0], dirs[1])
+
+	for i, expected := range []string{"lower", "upper"} {
+		content, err := os.ReadFile(filepath.Join(dirs[i], "etc", "file"))
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+}
+
+func TestUntarLayersSquashed(t *testing.T) {
+	dir := func(name string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755}}
+	}
+	reg := func(name, content string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name}, content: content}
+	}
+
+	layers := []io.Reader{
+		newTestTar(t,
+			dir("etc/"),
+			reg("etc/removed", "gone"),
+			reg("etc/overwritten", "original longer content"),
+			dir("opt/"),
+			reg("opt/lower", "hidden by opaque"),
+			dir("swap/"),
+		),
+		newTestTar(t,
+			reg("etc/.wh.removed", ""),
+			reg("etc/overwritten", "new"),
+			reg("opt/upper", "same layer as opaque"),
+			reg("opt/.wh..wh..opq", ""),
+			reg("swap", "directory replaced by file"),
+		),
+	}
+
+	dst := t.TempDir()
+	sources, err := UntarLayersSquashed(layers, dst)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{
+		"etc":             0,
+		"etc/overwritten": 1,
+		"opt":             0,
+		"opt/upper":       1,
+		"swap":            1,
+	}, sources)
+
+	assert.NoFileExists(t, filepath.Join(dst, "etc", "removed"))
+	assert.NoFileExists(t, filepath.Join(dst, "etc", ".wh.removed"))
+
+	content, err := os.ReadFile(filepath.Join(dst, "etc", "overwritten"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+
+	children, err := os.ReadDir(filepath.Join(dst, "opt"))
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	assert.Equal(t, "upper", children[0].Name())
+
+	assert.FileExists(t, filepath.Join(dst, "swap"))
+}
+
+func TestUntarLayersSquashed_WhiteoutTraversal(t *testing.T) {
+	layers := []io.Reader{
+		newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "../.wh.outside"}}),
+	}
+	_, err := UntarLayersSquashed(layers, t.TempDir())
+	require.Error(t, err)
+}
+
+func TestUntarLayersSquashed_Whiteouts(t *testing.T) {
+	dir := func(name string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755}}
+	}
+	reg := func(name string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644}, content: name}
+	}
+
+	tests := []struct {
+		name   string
+		layers [][]testTarEntry
+		want   []string
+	}{
+		{
+			name:   "bare whiteout prefix is not a whiteout",
+			layers: [][]testTarEntry{{reg(".wh.")}},
+			want:   []string{".wh.", "existing"},
+		},
+		{
+			name: "bare whiteout prefix within a directory is not a whiteout",
+			layers: [][]testTarEntry{
+				{dir("dir/"), reg("dir/file")},
+				{reg("dir/.wh.")},
+			},
+			want: []string{"dir", "dir/.wh.", "dir/file", "existing"},
+		},
+		{
+			name:   "whiteout does not apply to the same layer",
+			layers: [][]testTarEntry{{reg("b"), reg(".wh.b")}},
+			want:   []string{"b", "existing"},
+		},
+		{
+			name: "whiteout applies to lower layers only",
+			layers: [][]testTarEntry{
+				{dir("dir/"), reg("dir/lower")},
+				{dir("dir/"), reg("dir/upper"), reg("dir/.wh.lower"), reg("dir/.wh.upper")},
+			},
+			want: []string{"dir", "dir/upper", "existing"},
+		},
+		{
+			name: "opaque directory removes nested lower content",
+			layers: [][]testTarEntry{
+				{dir("dir/"), dir("dir/sub/"), reg("dir/sub/old"), dir("dir/kept/"), reg("dir/kept/old"), dir("dir/gone/"), reg("dir/gone/old")},
+				// the upper layer writes within kept without re-creating it
+				{dir("dir/"), dir("dir/sub/"), reg("dir/sub/new"), reg("dir/kept/new"), reg("dir/.wh..wh..opq")},
+			},
+			want: []string{"dir", "dir/kept", "dir/kept/new", "dir/sub", "dir/sub/new", "existing"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var layers []io.Reader
+			for _, entries := range tt.layers {
+				layers = append(layers, newTestTar(t, entries...))
+			}
+			dst := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dst, "existing"), []byte("existing"), 0644))
+
+			_, err := UntarLayersSquashed(layers, dst)
+			require.NoError(t, err)
+
+			var got []string
+			require.NoError(t, filepath.WalkDir(dst, func(p string, _ fs.DirEntry, err error) error {
+				if p != dst {
+					rel, _ := filepath.Rel(dst, p)
+					got = append(got, filepath.ToSlash(rel))
+				}
+				return err
+			}))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestUntarLayersSquashed_EntryNames(t *testing.T) {
+	layers := []io.Reader{
+		newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/removed"}, content: "gone"},
+		),
+		newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "ETC/.wh.REMOVED"}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "ETC/ADDED"}, content: "added"},
+		),
+	}
+	lower := WithNameSanitizer(strings.ToLower)
+
+	dst := t.TempDir()
+	sources, err := UntarLayersSquashed(layers, dst, lower)
+	require.NoError(t, err)
+	// whiteouts and attribution apply to the sanitized names
+	assert.Equal(t, map[string]int{"etc": 0, "etc/added": 1}, sources)
+	assert.NoFileExists(t, filepath.Join(dst, "etc", "removed"))
+	assert.FileExists(t, filepath.Join(dst, "etc", "added"))
+}
+
+func TestUntarLayersSquashed_RejectedEntryKeepsLowerContent(t *testing.T) {
+	layers := []io.Reader{
+		newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "swap/", Mode: 0755}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "swap/kept"}, content: "kept"},
+		),
+		newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "Swap/", Mode: 0755}},
+			// replaces the lower directory, but collides with the directory above
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "swap"}, content: "file"},
+		),
+	}
+
+	dst := t.TempDir()
+	_, err := UntarLayersSquashed(layers, dst, WithCaseCollisionDetection(false))
+	var collision *ErrCaseCollision
+	require.ErrorAs(t, err, &collision)
+	assert.FileExists(t, filepath.Join(dst, "swap", "kept"))
+}
@@ -0,0 +1,42 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// tarMetadataRecord is the JSON form of an entry written by TarMetadataJSON.
+type tarMetadataRecord struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Mode     int64     `json:"mode"`
+	ModTime  time.Time `json:"mtime"`
+	Type     string    `json:"type"`
+	Linkname string    `json:"linkname,omitempty"`
+}
+
+// TarMetadataJSON writes the metadata of every entry of the given tar to the given writer as newline-delimited JSON,
+// in archive order, so that the structure of an archive can be consumed without reading it with Go. Each line is an
+// object with the name, size, mode (as recorded in the header), mtime (in UTC, as RFC 3339), type
+// (as with Type.String), and linkname (for links only) of an entry. Content is not read.
+func TarMetadataJSON(reader io.Reader, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	visitor := func(entry TarFileEntry) error {
+		record := tarMetadataRecord{
+			Name:     entry.Header.Name,
+			Size:     entry.Header.Size,
+			Mode:     entry.Header.Mode,
+			ModTime:  entry.Header.ModTime.UTC(),
+			Type:     TypeFromTarType(entry.Header.Typeflag).String(),
+			Linkname: entry.Header.Linkname,
+		}
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("unable to write metadata for tar entry=%q: %w", entry.Header.Name, err)
+		}
+		return nil
+	}
+	return IterateTar(reader, visitor)
+}
@@ -0,0 +1,47 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarMetadataJSON(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("test", 3600))
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755, ModTime: modTime}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts", Mode: 0644, ModTime: modTime}, content: "127.0.0.1 localhost"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/localtime", Linkname: "/usr/share/zoneinfo/UTC", Mode: 0777, ModTime: modTime}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeLink, Name: "etc/hosts.bak", Linkname: "etc/hosts", Mode: 0644, ModTime: modTime}},
+	)
+
+	var out bytes.Buffer
+	require.NoError(t, TarMetadataJSON(reader, &out))
+	assert.Equal(t, `{"name":"etc/","size":0,"mode":493,"mtime":"2020-01-02T02:04:05Z","type":"Directory"}
+{"name":"etc/hosts","size":19,"mode":420,"mtime":"2020-01-02T02:04:05Z","type":"RegularFile"}
+{"name":"etc/localtime","size":0,"mode":511,"mtime":"2020-01-02T02:04:05Z","type":"SymbolicLink","linkname":"/usr/share/zoneinfo/UTC"}
+{"name":"etc/hosts.bak","size":0,"mode":420,"mtime":"2020-01-02T02:04:05Z","type":"HardLink","linkname":"etc/hosts"}
+`, out.String())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestTarMetadataJSON_WriteError(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file"}, content: "content"},
+	)
+	err := TarMetadataJSON(reader, failingWriter{})
+	require.ErrorContains(t, err, "disk full")
+}
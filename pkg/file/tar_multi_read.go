@@ -0,0 +1,172 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// MultiReadResult is the outcome of reading several paths from a tar at once (see ReadersFromTar).
+type MultiReadResult struct {
+	// Found maps each requested path present within the tar to a reader of its content. All readers must be closed
+	// (see Close) once read.
+	Found map[string]io.ReadCloser
+	// Missing are the requested paths not present within the tar, in the order they were requested.
+	Missing []string
+}
+
+// Close closes all found readers.
+func (r MultiReadResult) Close() error {
+	var errs []error
+	for _, reader := range r.Found {
+		errs = append(errs, reader.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// ReadersFromTar returns readers of the content of the given paths within a tar, read in a single pass over the tar
+// that stops once all paths have been found. Paths must match entry names exactly (as with ReaderFromTar), where the
+// first entry for a path is returned. Paths that are not found are reported as missing rather than failing, so that
+// callers can handle partial success. Since the tar can only be read forward, found content is buffered until it is
+// read: by default in temporary files, or in memory for as long as it fits within the budget given by
+// WithMemoryBudget. Everything buffered (memory and temporary files) is released once all found readers are closed.
+func ReadersFromTar(reader io.Reader, paths []string, options ...Option) (MultiReadResult, error) {
+	cfg := newUntarOptions(options...)
+	buffer, err := newEntryBuffer(cfg.MemoryBudget)
+	if err != nil {
+		return MultiReadResult{}, err
+	}
+
+	pending := make(map[string]tarPathMatcher, len(paths))
+	for _, p := range paths {
+		pending[p] = newTarPathMatcher(p)
+	}
+	found := make(map[string]*bufferedEntry)
+	visitor := func(entry TarFileEntry) error {
+		var buffered *bufferedEntry
+		for p, matcher := range pending {
+			if !matcher.matches(entry.Header.Name) {
+				continue
+			}
+			if buffered == nil {
+				buffered = &bufferedEntry{header: entry.Header}
+				if err := buffer.hold(buffered, entry.Reader); err != nil {
+					return err
+				}
+			}
+			found[p] = buffered
+			delete(pending, p)
+		}
+		if len(pending) == 0 {
+			return ErrTarStopIteration
+		}
+		return nil
+	}
+
+	err = iterateTar(reader, visitor, cfg)
+	held := &heldContent{buffer: buffer, entries: uniqueEntries(found), remaining: len(found)}
+	if err != nil || held.remaining == 0 {
+		held.release()
+		return MultiReadResult{Missing: missingPaths(paths, found)}, err
+	}
+
+	result := MultiReadResult{Found: make(map[string]io.ReadCloser, len(found)), Missing: missingPaths(paths, found)}
+	for p, entry := range found {
+		result.Found[p] = held.newReader(entry)
+	}
+	return result, nil
+}
+
+// missingPaths returns the given paths that were not found, in order and without duplicates.
+func missingPaths(paths []string, found map[string]*bufferedEntry) []string {
+	var missing []string
+	seen := make(map[string]struct{})
+	for _, p := range paths {
+		if _, ok := found[p]; ok {
+			continue
+		}
+		if _, ok := seen[p]; !ok {
+			seen[p] = struct{}{}
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// heldContent is the content buffered for the readers of a single ReadersFromTar call, which is released once the
+// last of the readers is closed.
+type heldContent struct {
+	lock      sync.Mutex
+	buffer    *entryBuffer
+	entries   []*bufferedEntry
+	remaining int
+}
+
+func (h *heldContent) newReader(entry *bufferedEntry) io.ReadCloser {
+	reader := &heldContentReader{held: h}
+	switch {
+	case entry.data != nil:
+		reader.reader = bytes.NewReader(entry.data)
+	case entry.content != "":
+		file := NewLazyReadCloser(entry.content)
+		reader.reader = file
+		reader.closer = file
+	default:
+		reader.reader = bytes.NewReader(nil)
+	}
+	return reader
+}
+
+// closed accounts for one of the readers being closed, releasing all held content after the last one.
+func (h *heldContent) closed() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.remaining--; h.remaining == 0 {
+		h.release()
+	}
+}
+
+func (h *heldContent) release() {
+	for _, entry := range h.entries {
+		h.buffer.release(entry)
+	}
+	h.buffer.close()
+}
+
+// heldContentReader reads content held for a ReadersFromTar call.
+type heldContentReader struct {
+	reader io.Reader
+	closer io.Closer
+	held   *heldContent
+	once   sync.Once
+}
+
+func (r *heldContentReader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+func (r *heldContentReader) Close() error {
+	var err error
+	r.once.Do(func() {
+		if r.closer != nil {
+			err = r.closer.Close()
+		}
+		r.held.closed()
+	})
+	return err
+}
+
+// uniqueEntries returns the distinct entries found, since a single entry may be found for several paths (such as
+// different forms of the root of the archive).
+func uniqueEntries(found map[string]*bufferedEntry) []*bufferedEntry {
+	seen := make(map[*bufferedEntry]struct{}, len(found))
+	var entries []*bufferedEntry
+	for _, entry := range found {
+		if _, ok := seen[entry]; !ok {
+			seen[entry] = struct{}{}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,67 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadersFromTar(t *testing.T) {
+	newReader := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/"}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts"}, content: "127.0.0.1 localhost"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/os-release"}, content: "ID=test"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/passwd"}, content: "root:x:0:0"},
+		)
+	}
+	budget := NewMemoryBudget(1024)
+
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{
+			name: "buffered in temporary files",
+		},
+		{
+			name:    "buffered in memory",
+			options: []Option{WithMemoryBudget(budget)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ReadersFromTar(newReader(), []string{"etc/passwd", "etc/shadow", "etc/hosts"}, tt.options...)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"etc/shadow"}, result.Missing)
+
+			got := make(map[string]string)
+			for name, reader := range result.Found {
+				content, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				got[name] = string(content)
+			}
+			assert.Equal(t, map[string]string{"etc/passwd": "root:x:0:0", "etc/hosts": "127.0.0.1 localhost"}, got)
+
+			require.NoError(t, result.Close())
+			assert.Zero(t, budget.InUse())
+		})
+	}
+}
+
+func TestReadersFromTar_NoneFound(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts"}, content: "127.0.0.1 localhost"},
+	)
+	result, err := ReadersFromTar(reader, []string{"etc/shadow", "etc/group", "etc/shadow"})
+	require.NoError(t, err)
+	assert.Empty(t, result.Found)
+	assert.Equal(t, []string{"etc/shadow", "etc/group"}, result.Missing)
+	require.NoError(t, result.Close())
+}
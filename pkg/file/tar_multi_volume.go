@@ -0,0 +1,119 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+const (
+	// typeGNUVolumeLabel is the type of the header GNU tar writes at the start of a volume labeled with --label.
+	typeGNUVolumeLabel = 'V'
+	// typeGNUMultiVolume is the type of the header GNU tar writes at the start of a volume continuing an entry whose
+	// content spans from the previous volume, which records the remaining size and the offset of the continued content.
+	typeGNUMultiVolume = 'M'
+)
+
+// IterateMultiVolumeTar iterates the entries of a GNU multi-volume tar (as written by `tar --multi-volume`), as with
+// IterateTar. Volumes are found by formatting the given pattern with the volume number, starting at 1 (such as
+// "archive.tar.%d" for archive.tar.1, archive.tar.2, and so on), and are read in sequence until the next volume does
+// not exist. The volumes are presented to the tar reader as a single stream, where the headers GNU tar writes at the
+// start of each volume (volume labels, and the headers continuing an entry spanning from the previous volume) are
+// dropped, so that entries spanning volumes are read as one.
+func IterateMultiVolumeTar(pattern string, visitor TarFileVisitor, options ...Option) error {
+	volumes := &multiVolumeReader{pattern: pattern}
+	defer volumes.Close()
+
+	if err := volumes.next(); err != nil {
+		return err
+	}
+	return iterateTar(volumes, visitor, newUntarOptions(options...))
+}
+
+// multiVolumeReader reads the volumes of a multi-volume tar as a single stream.
+type multiVolumeReader struct {
+	pattern string
+	// volume is the number of the volume being read, where 0 is before the first volume
+	volume int
+	file   *os.File
+	reader io.Reader
+}
+
+func (m *multiVolumeReader) Read(p []byte) (int, error) {
+	for m.reader != nil {
+		n, err := m.reader.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		if err := m.next(); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return 0, io.EOF
+}
+
+// next moves on to the next volume, which ends the stream (with the reader unset) when the volume does not exist. The
+// first volume must exist.
+func (m *multiVolumeReader) next() error {
+	if err := m.Close(); err != nil {
+		return err
+	}
+	m.volume++
+	path := fmt.Sprintf(m.pattern, m.volume)
+	file, err := os.Open(path)
+	if err != nil {
+		if m.volume > 1 && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("unable to open tar volume (path=%s): %w", path, err)
+	}
+	m.file = file
+
+	reader, err := skipVolumeHeaders(file)
+	if err != nil {
+		return fmt.Errorf("unable to read tar volume (path=%s): %w", path, err)
+	}
+	m.reader = reader
+	return nil
+}
+
+func (m *multiVolumeReader) Close() error {
+	m.reader = nil
+	if m.file == nil {
+		return nil
+	}
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
+
+// skipVolumeHeaders reads past the volume label and continuation header at the start of the given volume, returning a
+// reader of what follows (including the first block read, when it is none of these headers).
+func skipVolumeHeaders(volume io.Reader) (io.Reader, error) {
+	block := make([]byte, tarBlockSize)
+	for {
+		if _, err := io.ReadFull(volume, block); err != nil {
+			if err == io.EOF {
+				return volume, nil
+			}
+			return nil, err
+		}
+		if !isTarHeaderBlock(block) {
+			break
+		}
+		switch block[156] {
+		case typeGNUVolumeLabel:
+			continue
+		case typeGNUMultiVolume:
+			return volume, nil
+		}
+		break
+	}
+	return io.MultiReader(bytes.NewReader(block), volume), nil
+}
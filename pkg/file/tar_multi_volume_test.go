@@ -0,0 +1,79 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterateMultiVolumeTar(t *testing.T) {
+	archive, err := io.ReadAll(newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "first"}, content: strings.Repeat("a", 600)},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "second"}, content: strings.Repeat("b", 1500)},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "third"}, content: "third"},
+	))
+	require.NoError(t, err)
+	// the content of the second entry is split across the first two volumes, and the third entry starts the last volume
+	const secondContent, secondSplit, thirdHeader = 4 * tarBlockSize, 512, 7 * tarBlockSize
+	continuation := rawTarHeader("second", typeGNUMultiVolume, 1500-secondSplit, func(block []byte) {
+		copy(block[369:381], fmt.Sprintf("%011o\x00", secondSplit))
+	})
+	label := rawTarHeader("backup", typeGNUVolumeLabel, 0, nil)
+
+	dir := t.TempDir()
+	volumes := [][]byte{
+		archive[:secondContent+secondSplit],
+		append(append(label, continuation...), archive[secondContent+secondSplit:thirdHeader]...),
+		archive[thirdHeader:],
+	}
+	for i, volume := range volumes {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("archive.tar.%d", i+1)), volume, 0600))
+	}
+
+	var entries []string
+	err = IterateMultiVolumeTar(filepath.Join(dir, "archive.tar.%d"), func(entry TarFileEntry) error {
+		content, err := io.ReadAll(entry.Reader)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry.Header.Name+"="+string(content))
+		return nil
+	}, WithRequiredTerminator(true))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"first=" + strings.Repeat("a", 600),
+		"second=" + strings.Repeat("b", 1500),
+		"third=third",
+	}, entries)
+}
+
+func TestIterateMultiVolumeTar_MissingVolume(t *testing.T) {
+	dir := t.TempDir()
+	err := IterateMultiVolumeTar(filepath.Join(dir, "archive.tar.%d"), func(TarFileEntry) error {
+		return nil
+	})
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// a missing volume within the archive leaves the spanning entry truncated
+	archive, err := io.ReadAll(newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "spanning"}, content: strings.Repeat("a", 1500)},
+	))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "archive.tar.1"), archive[:2*tarBlockSize], 0600))
+
+	err = IterateMultiVolumeTar(filepath.Join(dir, "archive.tar.%d"), func(entry TarFileEntry) error {
+		_, err := io.ReadAll(entry.Reader)
+		return err
+	})
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,51 @@
+package file
+
+import (
+	"strings"
+)
+
+// windowsReservedNames are device names which cannot be used as a file name on Windows, with or without an extension.
+var windowsReservedNames = map[string]struct{}{
+	"CON": {}, "PRN": {}, "AUX": {}, "NUL": {},
+	"COM1": {}, "COM2": {}, "COM3": {}, "COM4": {}, "COM5": {}, "COM6": {}, "COM7": {}, "COM8": {}, "COM9": {},
+	"LPT1": {}, "LPT2": {}, "LPT3": {}, "LPT4": {}, "LPT5": {}, "LPT6": {}, "LPT7": {}, "LPT8": {}, "LPT9": {},
+}
+
+// DefaultNameSanitizer remaps tar entry names so that they can be extracted onto filesystems with stricter naming rules
+// than Linux (most notably Windows), for use with WithNameSanitizer. Within each element of the name, characters that
+// are illegal on Windows (<>:"\|?* and control characters) and trailing dots and spaces (which Windows silently drops)
+// are replaced with "_", and reserved device names (such as "CON" or "nul.txt") are suffixed with "_". Names that are
+// already portable are returned unchanged.
+func DefaultNameSanitizer(name string) string {
+	elements := strings.Split(name, DirSeparator)
+	for i, element := range elements {
+		elements[i] = sanitizeNameElement(element)
+	}
+	return strings.Join(elements, DirSeparator)
+}
+
+func sanitizeNameElement(element string) string {
+	if element == "." || element == ".." {
+		return element
+	}
+
+	sanitized := strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, element)
+
+	if trimmed := strings.TrimRight(sanitized, ". "); len(trimmed) != len(sanitized) {
+		sanitized = trimmed + strings.Repeat("_", len(sanitized)-len(trimmed))
+	}
+
+	stem, ext, _ := strings.Cut(sanitized, ".")
+	if _, reserved := windowsReservedNames[strings.ToUpper(stem)]; reserved {
+		sanitized = stem + "_"
+		if ext != "" {
+			sanitized += "." + ext
+		}
+	}
+	return sanitized
+}
@@ -0,0 +1,32 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultNameSanitizer(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "usr/bin/env", want: "usr/bin/env"},
+		{name: "./etc/", want: "./etc/"},
+		{name: "a/../b", want: "a/../b"},
+		{name: "etc/systemd/system/getty@tty1.service", want: "etc/systemd/system/getty@tty1.service"},
+		{name: "var/lib/a:b?c*d", want: "var/lib/a_b_c_d"},
+		{name: `dir<x>/"quoted"|pipe\slash`, want: "dir_x_/_quoted__pipe_slash"},
+		{name: "ctrl\x01char", want: "ctrl_char"},
+		{name: "trailing./spaces  /name", want: "trailing_/spaces__/name"},
+		{name: "dev/con", want: "dev/con_"},
+		{name: "dev/NUL.txt", want: "dev/NUL_.txt"},
+		{name: "dev/com1.tar.gz", want: "dev/com1_.tar.gz"},
+		{name: "dev/console", want: "dev/console"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, DefaultNameSanitizer(tt.name))
+		})
+	}
+}
@@ -0,0 +1,745 @@
+package file
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"os"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// UntarOptions configures how tar archives are iterated and extracted. The zero value preserves the default behavior.
+type UntarOptions struct {
+	// DetectCaseCollisions fails extraction when two entries would resolve to the same path on a case-insensitive
+	// filesystem (e.g. "Foo" and "foo").
+	DetectCaseCollisions bool
+
+	// LogCaseCollisions logs collisions found by DetectCaseCollisions as warnings instead of failing extraction.
+	LogCaseCollisions bool
+
+	// NoOverwrite refuses to replace a regular file that already exists at the destination.
+	NoOverwrite bool
+
+	// CleanupOnError removes everything created by an extraction that fails (or is stopped with an error) partway
+	// through the archive, leaving the destination without partially extracted content.
+	CleanupOnError bool
+
+	// OnTypeConflict is the policy for entries whose target already exists as a different kind of node, such as a
+	// directory where the archive has a regular file (or vice versa). Defaults to TypeConflictFail.
+	OnTypeConflict TypeConflictPolicy
+
+	// Hardlinks is how hardlink entries are extracted. Defaults to HardlinkSkip.
+	Hardlinks HardlinkStrategy
+
+	// CompareContentDigests additionally compares the content digest of regular files when diffing archives.
+	CompareContentDigests bool
+
+	// PreserveSpecialBits explicitly applies setuid, setgid, and sticky bits from the header to extracted entries.
+	PreserveSpecialBits bool
+
+	// OnWarning is invoked during iteration for recoverable header oddities (such as unknown typeflags) that would
+	// otherwise be silently accepted. Iteration continues after the callback returns.
+	OnWarning func(sequence int64, warning error)
+
+	// OnDuplicatePath is invoked during iteration for every entry whose cleaned name was already seen earlier in the
+	// archive, with the sequence of the first and the current occurrence. Detection keeps every unique name in memory
+	// for the duration of the iteration, so archives with millions of unique entries will hold millions of strings.
+	OnDuplicatePath func(name string, firstSequence, sequence int64)
+
+	// OnGlobalHeader is invoked with the records of each global PAX header (typically one per archive) instead of
+	// presenting the global header to the visitor as an entry. Global headers are then not counted in the Sequence of
+	// the entries that follow.
+	OnGlobalHeader func(records map[string]string)
+
+	// OnEntryConsumed is invoked after each visitor call (regardless of the result) with the number of content bytes the
+	// visitor read from the entry, which allows for catching visitors that unintentionally leave content unread.
+	OnEntryConsumed func(TarEntryConsumption)
+
+	// OnEntryError is invoked when the content of an entry cannot be read (regardless of whether the visitor returned
+	// the error), after which iteration continues with the next entry instead of aborting. Continuing is only possible
+	// when the stream is seekable and the position of the next header is known; otherwise, and for all stream-level
+	// errors (such as an unreadable header), iteration still aborts.
+	OnEntryError func(entry TarFileEntry, err error)
+
+	// DetectContentType classifies the leading bytes of each regular file during iteration, populating
+	// TarFileEntry.DetectedContentType.
+	DetectContentType bool
+
+	// SyncAgainst is an existing directory that extraction is compared against, turning extraction into a sync: regular
+	// files are only written when they differ from the file at the same path within this directory. This is typically
+	// the destination itself. Written files take on the modification time from the header so that subsequent syncs
+	// can detect them as unchanged.
+	SyncAgainst string
+
+	// SyncCompareDigests compares files by content digest instead of by size and modification time when syncing.
+	SyncCompareDigests bool
+
+	// SyncDelete removes any paths within the SyncAgainst directory that are not present in the archive once
+	// extraction completes.
+	SyncDelete bool
+
+	// SanitizeName, NameSanitizer, and RequireUTF8Names form the pipeline that entry names pass through before
+	// extraction, applied in this order (see WithNonUTF8NameSanitizer, WithNameSanitizer, and WithRequireUTF8Names).
+
+	// SanitizeName, when set, remaps entry names that are not valid UTF-8 (which is possible with GNU format headers),
+	// such as with strings.ToValidUTF8.
+	SanitizeName func(name string) string
+
+	// NameSanitizer, when set, remaps every entry name (after SanitizeName), such as to replace characters which are
+	// illegal on the destination filesystem (see DefaultNameSanitizer). Each name that is changed is logged. Names
+	// remapped onto the same name overwrite each other as any duplicate entry would.
+	NameSanitizer func(name string) string
+
+	// RequireUTF8Names fails extraction with ErrInvalidUTF8Name for any entry name that is still not valid UTF-8 once
+	// remapped by SanitizeName and NameSanitizer.
+	RequireUTF8Names bool
+
+	// MaxOpenFiles bounds the number of files simultaneously open during extraction (0 means no limit).
+	MaxOpenFiles int
+
+	// ExtractBudget bounds the total bytes of regular file content written by an extraction (0 means no bound). The
+	// entry that would exceed the budget is not written, and extraction then stops cleanly unless ErrorOnExtractBudget
+	// is set. When stopping early, SyncDelete is not applied since the remainder of the archive was never read.
+	ExtractBudget int64
+
+	// ErrorOnExtractBudget fails extraction with ErrExtractBudgetExceeded instead of stopping cleanly once the
+	// ExtractBudget is reached.
+	ErrorOnExtractBudget bool
+
+	// OnLargeFile is invoked before extracting each regular file whose size (according to its header) exceeds the
+	// LargeFileThreshold, with the name and size of the entry. Returning true skips the file instead of writing it.
+	// This is separate from (and checked before) the per-file read limit guarding against decompression bombs.
+	OnLargeFile func(name string, size int64) (skip bool)
+
+	// LargeFileThreshold is the size above which regular files are passed to OnLargeFile.
+	LargeFileThreshold int64
+
+	// WriteRetryAttempts bounds the number of times creating and writing each extracted file is attempted when failing
+	// with a transient error (such as EINTR or a temporary EIO), where 0 or 1 means failing on the first error. Writes
+	// are retried for the remaining bytes only, so the content of an entry is never read twice.
+	WriteRetryAttempts int
+
+	// WriteRetryBackoff is the wait before the first retry, doubling for each retry thereafter.
+	WriteRetryBackoff time.Duration
+
+	// ReadRetryAttempts bounds the number of times each read of the tar stream is attempted when failing with an error
+	// other than the end of the stream, where 0 or 1 means failing on the first error. Retries read again from the
+	// offset reached, which requires a stream implementing io.ReaderAt and io.Seeker (such as an *os.File or
+	// *io.SectionReader); other streams are read without retries.
+	ReadRetryAttempts int
+
+	// ReadRetryBackoff is the wait before the first retry of a read, doubling for each retry thereafter.
+	ReadRetryBackoff time.Duration
+
+	// MemoryBudget, when set, bounds the memory used for buffering (such as copy buffers during extraction and file
+	// content while squashing layers, see MemoryBudget for exactly what is covered). The same budget may be shared by
+	// many concurrent operations.
+	MemoryBudget *MemoryBudget
+
+	// Deadline bounds the wall-clock time of iteration (and thus extraction). It is checked before each header is read
+	// and on each read of entry content, aborting with ErrDeadlineExceeded once passed (the zero value means no deadline).
+	Deadline time.Time
+
+	// ApplyDirectoryModes sets the mode of each extracted directory from its header (directories are otherwise created
+	// 0755). Archives may list the same directory several times with differing modes, in which case the last entry
+	// wins. Modes are applied once all entries have been extracted.
+	ApplyDirectoryModes bool
+
+	// ApplyDirectoryTimes sets the modification (and access) time of each extracted directory from its header, where
+	// the last entry wins as with ApplyDirectoryModes. Times are applied once all entries have been extracted, since
+	// extracting the contents of a directory updates its modification time.
+	ApplyDirectoryTimes bool
+
+	// ApplyFileTimes sets the modification (and access) time of each extracted regular file from its header. Times are
+	// applied with the full precision of the header (PAX headers may carry sub-second times), as far as the destination
+	// filesystem supports it.
+	ApplyFileTimes bool
+
+	// PreserveOwnership sets the owner of each extracted regular file and directory to the uid and gid from its header
+	// (translated through UIDMap and GIDMap), which generally requires privileges.
+	PreserveOwnership bool
+
+	// UIDMap and GIDMap translate the uid and gid from each header when preserving ownership (see IDMapEntry), such as
+	// into an unprivileged subordinate range for rootless extraction. An empty map leaves IDs as-is. Entries owned by
+	// IDs outside of the map fail extraction with ErrUnmappedID, unless MapUnmappedIDs is set.
+	UIDMap []IDMapEntry
+	GIDMap []IDMapEntry
+
+	// MapUnmappedIDs assigns OverflowUID and OverflowGID to entries owned by IDs outside of UIDMap and GIDMap instead of
+	// failing extraction.
+	MapUnmappedIDs bool
+	OverflowUID    int
+	OverflowGID    int
+
+	// ModeMask is applied with a bitwise AND to the mode from every header before creating files and directories, such
+	// as 0755 for dropping group and other write permissions (where setuid, setgid, and sticky bits are kept only when
+	// included as os.ModeSetuid, os.ModeSetgid, and os.ModeSticky). The zero value keeps all bits.
+	ModeMask os.FileMode
+
+	// ForceModes ignores the modes from all headers, instead giving every extracted regular file ForceFileMode and every
+	// directory ForceDirMode. This takes precedence over PreserveSpecialBits and ApplyDirectoryModes.
+	ForceModes bool
+
+	// ForceFileMode is the mode given to every extracted regular file when ForceModes is enabled.
+	ForceFileMode os.FileMode
+
+	// ForceDirMode is the mode given to every extracted directory when ForceModes is enabled.
+	ForceDirMode os.FileMode
+
+	// ValidatePAXSize fails iteration with ErrSizeDisagreement for entries whose PAX size record disagrees with the size
+	// field of the raw ustar header, which can be used to smuggle content past tools that only honor one of the two.
+	ValidatePAXSize bool
+
+	// RequireTerminator fails iteration with ErrMissingTerminator for archives that end without the two zero blocks
+	// marking the end of the archive, which are otherwise optional.
+	RequireTerminator bool
+
+	// RejectUnsafeLinks fails iteration with ErrUnsafeLinkTarget for hardlinks and symlinks whose target is absolute or
+	// resolves outside of the root of the archive. Although links are never followed when extracting, rejecting them
+	// guards any consumer which might.
+	RejectUnsafeLinks bool
+
+	// RejectDevices fails iteration with ErrDeviceEntry for character and block device entries, for policies that
+	// forbid device nodes in analyzed images. Devices are never created when extracting regardless.
+	RejectDevices bool
+
+	// RequireSorted fails iteration with ErrUnsortedArchive at the first entry whose name sorts before that of the
+	// preceding entry, for verifying that an archive was written deterministically.
+	RequireSorted bool
+
+	// SortEntries writes entries sorted by name when re-serializing an archive (see RewriteTar) instead of preserving
+	// their original order.
+	SortEntries bool
+
+	// PreserveOrder keeps the original order of entries when normalizing an archive (see NormalizeTar), which otherwise
+	// sorts entries by name.
+	PreserveOrder bool
+
+	// PreserveHeaders keeps all header fields as read when normalizing an archive (see NormalizeTar), which otherwise
+	// resets timestamps and ownership.
+	PreserveHeaders bool
+
+	// TrailingGarbageAsEOF treats data following the last member of a gzip stream that is not another gzip member (such
+	// as zero padding) as the end of the stream instead of failing with gzip.ErrHeader.
+	TrailingGarbageAsEOF bool
+
+	// IncludeHeaderOverhead reports the size of the entire uncompressed tar stream as the UntarResult size (see
+	// UntarToDirectoryResult), instead of only the regular file content.
+	IncludeHeaderOverhead bool
+
+	// CollectStats reports the largest and slowest regular files along with the wall time of the extraction as the
+	// UntarResult stats (see UntarToDirectoryResult). Timing every write has a small cost, so this is off by default.
+	CollectStats bool
+
+	// DigestManifest, when set, is populated with the content digest (see HashAlgorithm) of every regular file
+	// extracted, keyed by the cleaned entry name. Digests are computed while the content is written, so cost no
+	// additional read of the content. Files that are not read (such as those left unchanged when syncing by size and
+	// modification time) are not included. Concurrent extractions with the same manifest are safe, but the manifest
+	// must not be read until all of them have finished.
+	DigestManifest map[string]string
+
+	// Metrics receives counters describing extraction (see MetricsCollector), defaulting to discarding them.
+	Metrics MetricsCollector
+
+	// Sink receives the writes made while extracting (see ExtractionSink), defaulting to writing to the filesystem.
+	Sink ExtractionSink
+
+	// HashAlgorithm constructs the hash used wherever content digests are computed (defaults to SHA256). Digests are
+	// rendered prefixed with HashAlgorithmName (e.g. "sha256:...").
+	HashAlgorithm func() hash.Hash
+
+	// HashAlgorithmName is the name of HashAlgorithm used to prefix digests, which may be left empty for well-known
+	// algorithms (such as sha512.New) to be identified from their output, but is required for any other algorithm.
+	HashAlgorithmName string
+
+	// openFiles is the semaphore enforcing MaxOpenFiles, which may be shared across extractions.
+	openFiles openFileLimiter
+}
+
+// TypeConflictPolicy is how extraction handles an entry whose target already exists as a different kind of node.
+type TypeConflictPolicy int
+
+const (
+	// TypeConflictFail fails extraction with ErrTypeConflict.
+	TypeConflictFail TypeConflictPolicy = iota
+	// TypeConflictReplace removes the existing node (recursively, for directories) before extracting the entry.
+	TypeConflictReplace
+	// TypeConflictSkip leaves the existing node as-is and does not extract the entry.
+	TypeConflictSkip
+)
+
+// Option is a functional option for configuring UntarOptions.
+type Option func(*UntarOptions)
+
+// WithCaseCollisionDetection detects entries that differ only by case (e.g. "Foo" and "foo"), which would overwrite
+// each other when extracting onto a case-insensitive filesystem (as is common on macOS and Windows). Collisions fail
+// extraction with ErrCaseCollision, or are only logged when logOnly is set.
+func WithCaseCollisionDetection(logOnly bool) Option {
+	return func(o *UntarOptions) {
+		o.DetectCaseCollisions = true
+		o.LogCaseCollisions = logOnly
+	}
+}
+
+// WithNoOverwrite causes extraction to fail with ErrWouldOverwrite instead of replacing an existing regular file, so
+// content written by a trusted layer cannot be clobbered by a later one.
+func WithNoOverwrite(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.NoOverwrite = enabled
+	}
+}
+
+// WithCleanupOnError causes a failed extraction to remove every file and directory it created (including the
+// destination itself when it did not exist), for callers expecting all-or-nothing semantics. Paths that existed before
+// the extraction are never removed, though content within them that was replaced by the extraction is not restored.
+// This applies to UntarToDirectory, Extractor.Untar, and Extractor.UntarWithResult, and only to the filesystem being
+// extracted to (not to any ExtractionSink).
+func WithCleanupOnError(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.CleanupOnError = enabled
+	}
+}
+
+// WithTypeConflictPolicy sets how extraction handles an entry whose target already exists as a different kind of
+// node (see TypeConflictPolicy).
+func WithTypeConflictPolicy(policy TypeConflictPolicy) Option {
+	return func(o *UntarOptions) {
+		o.OnTypeConflict = policy
+	}
+}
+
+// WithHardlinkStrategy sets how extraction materializes hardlink entries (see HardlinkStrategy). Links are only
+// materialized for targets that are regular files extracted earlier from the same archive.
+func WithHardlinkStrategy(strategy HardlinkStrategy) Option {
+	return func(o *UntarOptions) {
+		o.Hardlinks = strategy
+	}
+}
+
+// WithContentDigestComparison causes DiffTars to compare content digests of regular files in addition to header
+// metadata. This requires reading all content from both archives.
+func WithContentDigestComparison(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.CompareContentDigests = enabled
+	}
+}
+
+// WithPreserveSpecialBits causes extraction to explicitly chmod extracted files and directories carrying setuid,
+// setgid, or sticky bits so that these bits are retained on disk.
+func WithPreserveSpecialBits(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.PreserveSpecialBits = enabled
+	}
+}
+
+// WithWarningHandler sets a callback invoked during iteration for recoverable header oddities.
+func WithWarningHandler(fn func(sequence int64, warning error)) Option {
+	return func(o *UntarOptions) {
+		o.OnWarning = fn
+	}
+}
+
+// WithDuplicatePathHandler sets a callback invoked during iteration whenever an entry repeats the (cleaned) name of
+// an earlier entry. Note that this requires holding every unique entry name in memory for the duration of iteration.
+func WithDuplicatePathHandler(fn func(name string, firstSequence, sequence int64)) Option {
+	return func(o *UntarOptions) {
+		o.OnDuplicatePath = fn
+	}
+}
+
+// WithGlobalHeader sets a callback invoked with the records of the archive-wide global PAX header, which is then no
+// longer visited (nor counted in the Sequence of entries) as if it were a regular entry.
+func WithGlobalHeader(fn func(records map[string]string)) Option {
+	return func(o *UntarOptions) {
+		o.OnGlobalHeader = fn
+	}
+}
+
+// WithEntryConsumptionHandler sets a callback invoked after each visitor call with the number of content bytes the
+// visitor read from the entry.
+func WithEntryConsumptionHandler(fn func(TarEntryConsumption)) Option {
+	return func(o *UntarOptions) {
+		o.OnEntryConsumed = fn
+	}
+}
+
+// WithEntryErrorHandler sets a callback invoked for entries whose content cannot be read, allowing best-effort
+// iteration to continue past them when the stream allows for it.
+func WithEntryErrorHandler(fn func(entry TarFileEntry, err error)) Option {
+	return func(o *UntarOptions) {
+		o.OnEntryError = fn
+	}
+}
+
+// WithContentTypeDetection causes IterateTar to sniff the content type of each regular file (via
+// http.DetectContentType) before invoking the visitor. The sniffed bytes are transparently re-presented on the
+// entry reader.
+func WithContentTypeDetection(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.DetectContentType = enabled
+	}
+}
+
+// WithMaxOpenFiles bounds the number of files simultaneously open during extraction, avoiding EMFILE errors on systems
+// with low file descriptor limits. Each file is opened, written, and closed while holding a slot of the bound. The
+// bound is shared by all extractions configured with the same option value, so a single option can govern many
+// concurrent extractions. Copying a hardlink (see HardlinkCopy) holds two slots, as both the source and the copy are
+// open at once, except with a bound of 1 where the copy holds the only slot.
+func WithMaxOpenFiles(n int) Option {
+	limiter := newOpenFileLimiter(n)
+	return func(o *UntarOptions) {
+		o.MaxOpenFiles = n
+		o.openFiles = limiter
+	}
+}
+
+// WithDeadline bounds the wall-clock time of an entire iteration or extraction, so that a pathological archive cannot
+// occupy a worker indefinitely. Once passed, processing aborts with ErrExtractionDeadlineExceeded.
+func WithDeadline(deadline time.Time) Option {
+	return func(o *UntarOptions) {
+		o.Deadline = deadline
+	}
+}
+
+// WithExtractBudget bounds extraction to the first n bytes worth of regular file content, which is useful for sampling
+// a representative subset of a large layer. Once reached, extraction stops cleanly (returning nil) or, if
+// errorOnExceed is set, fails with ErrExtractBudgetExceeded.
+func WithExtractBudget(n int64, errorOnExceed bool) Option {
+	return func(o *UntarOptions) {
+		o.ExtractBudget = n
+		o.ErrorOnExtractBudget = errorOnExceed
+	}
+}
+
+// WithLargeFileHandler invokes the given function for each regular file larger than the given threshold before it is
+// extracted, skipping the file if the function returns true (e.g. to record large files rather than write them).
+func WithLargeFileHandler(threshold int64, fn func(name string, size int64) (skip bool)) Option {
+	return func(o *UntarOptions) {
+		o.LargeFileThreshold = threshold
+		o.OnLargeFile = fn
+	}
+}
+
+// WithWriteRetry retries creating and writing extracted files up to the given number of attempts when failing with
+// transient errors, waiting the given backoff (doubling each time) between attempts. Errors that are not transient
+// (such as EACCES or ENOSPC) fail extraction immediately.
+func WithWriteRetry(attempts int, backoff time.Duration) Option {
+	return func(o *UntarOptions) {
+		o.WriteRetryAttempts = attempts
+		o.WriteRetryBackoff = backoff
+	}
+}
+
+// WithReadRetry retries reading the tar stream up to the given number of attempts when failing partway through (such
+// as when streaming a layer from a registry over a flaky connection), waiting the given backoff (doubling each time)
+// between attempts. This requires a stream implementing io.ReaderAt and io.Seeker, which is read again from the offset
+// where the read failed; other streams are read without retries.
+func WithReadRetry(attempts int, backoff time.Duration) Option {
+	return func(o *UntarOptions) {
+		o.ReadRetryAttempts = attempts
+		o.ReadRetryBackoff = backoff
+	}
+}
+
+// WithMemoryBudget accounts buffering (see MemoryBudget for what is covered) against the given budget, which is typically shared across concurrent
+// extractions to cap their total memory use. Buffering blocks, or spills to disk where possible, when the budget is
+// exhausted.
+func WithMemoryBudget(budget *MemoryBudget) Option {
+	return func(o *UntarOptions) {
+		o.MemoryBudget = budget
+	}
+}
+
+// WithSortEntries causes RewriteTar to write entries sorted by name instead of in their original order.
+func WithSortEntries(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.SortEntries = enabled
+	}
+}
+
+// WithPreserveOrder causes NormalizeTar to keep entries in their original order instead of sorting them by name.
+func WithPreserveOrder(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.PreserveOrder = enabled
+	}
+}
+
+// WithPreserveHeaders causes NormalizeTar to keep header fields as read instead of resetting timestamps and ownership.
+func WithPreserveHeaders(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.PreserveHeaders = enabled
+	}
+}
+
+// WithTrailingGarbageAsEOF causes decompression to stop at data following the last gzip member instead of failing.
+func WithTrailingGarbageAsEOF(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.TrailingGarbageAsEOF = enabled
+	}
+}
+
+// WithHashAlgorithm sets the hash used wherever content digests are computed along with the name used to prefix them,
+// such as WithHashAlgorithm("sha512", sha512.New). The name may be left empty for well-known SHA-2 algorithms, MD5, and
+// SHA-1, which are identified from their output; other algorithms must be named, and WithHashAlgorithm panics when
+// they are not. md5.New and sha1.New are accepted for matching manifests produced by other tools, though they are not
+// suitable where the digest must resist tampering.
+func WithHashAlgorithm(name string, newHash func() hash.Hash) Option {
+	if name == "" {
+		known, ok := hashAlgorithmName(newHash)
+		if !ok {
+			panic(fmt.Sprintf("hash algorithm %T must be named", newHash()))
+		}
+		name = known
+	}
+	return func(o *UntarOptions) {
+		o.HashAlgorithm = newHash
+		o.HashAlgorithmName = name
+	}
+}
+
+// WithDirectoryModes causes extraction to apply the mode from the header of each directory, where the last entry for
+// a directory wins when it is repeated within the archive.
+func WithDirectoryModes(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.ApplyDirectoryModes = enabled
+	}
+}
+
+// WithDirectoryTimes causes extraction to set the modification time of each extracted directory from its header (see
+// ApplyDirectoryTimes).
+func WithDirectoryTimes(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.ApplyDirectoryTimes = enabled
+	}
+}
+
+// WithFileTimes causes extraction to set the modification time of each extracted regular file from its header (see
+// ApplyFileTimes).
+func WithFileTimes(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.ApplyFileTimes = enabled
+	}
+}
+
+// WithOwnership causes extraction to set the owner of each extracted regular file and directory from its header (see
+// PreserveOwnership).
+func WithOwnership(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.PreserveOwnership = enabled
+	}
+}
+
+// WithIDMapping translates the uid and gid from each header through the given maps when preserving ownership (see
+// WithOwnership), failing with ErrUnmappedID for IDs outside of the maps.
+func WithIDMapping(uidMap, gidMap []IDMapEntry) Option {
+	return func(o *UntarOptions) {
+		o.UIDMap = uidMap
+		o.GIDMap = gidMap
+	}
+}
+
+// WithOverflowIDs assigns the given uid and gid to entries owned by IDs outside of the maps given to WithIDMapping
+// instead of failing extraction (such as 65534, the "nobody" overflow ID of Linux user namespaces).
+func WithOverflowIDs(uid, gid int) Option {
+	return func(o *UntarOptions) {
+		o.MapUnmappedIDs = true
+		o.OverflowUID = uid
+		o.OverflowGID = gid
+	}
+}
+
+// WithModeMask strips the bits not within the given mask from the mode of every extracted file and directory, such as
+// 0755 for hardening extracted trees against group and other writes. Unlike WithForceMode, the modes from headers are
+// otherwise kept.
+func WithModeMask(mask os.FileMode) Option {
+	return func(o *UntarOptions) {
+		o.ModeMask = mask
+	}
+}
+
+// WithForceMode causes extraction to ignore the modes from all headers, giving every regular file fileMode and every
+// directory dirMode (e.g. 0644 and 0755). This is useful when extracting untrusted archives only to read their
+// content, avoiding the creation of executable or world-writable files.
+func WithForceMode(fileMode, dirMode os.FileMode) Option {
+	return func(o *UntarOptions) {
+		o.ForceModes = true
+		o.ForceFileMode = fileMode
+		o.ForceDirMode = dirMode
+	}
+}
+
+// WithSyncAgainst turns extraction into a sync against the given existing directory (typically the destination
+// itself), where regular files are only written when they differ from the file at the same path within it: by content
+// digest when compareDigests is set, otherwise by size and modification time. When deleteUnsynced is set, paths within
+// the directory that are not present in the archive are removed once extraction completes.
+func WithSyncAgainst(dir string, compareDigests, deleteUnsynced bool) Option {
+	return func(o *UntarOptions) {
+		o.SyncAgainst = dir
+		o.SyncCompareDigests = compareDigests
+		o.SyncDelete = deleteUnsynced
+	}
+}
+
+// WithNonUTF8NameSanitizer remaps entry names that are not valid UTF-8 with the given function before extraction,
+// such as with strings.ToValidUTF8 (see UntarOptions.SanitizeName).
+func WithNonUTF8NameSanitizer(sanitizer func(name string) string) Option {
+	return func(o *UntarOptions) {
+		o.SanitizeName = sanitizer
+	}
+}
+
+// WithNameSanitizer remaps every entry name with the given function before extraction, such as DefaultNameSanitizer
+// for extracting onto Windows (see UntarOptions.NameSanitizer).
+func WithNameSanitizer(sanitizer func(name string) string) Option {
+	return func(o *UntarOptions) {
+		o.NameSanitizer = sanitizer
+	}
+}
+
+// WithRequireUTF8Names fails extraction with ErrInvalidUTF8Name for entry names that are not valid UTF-8 once remapped
+// by any sanitizer (see UntarOptions.RequireUTF8Names).
+func WithRequireUTF8Names(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.RequireUTF8Names = enabled
+	}
+}
+
+// WithHeaderOverhead includes the tar headers, padding, and end-of-archive marker in the size reported by
+// UntarToDirectoryResult, making it the size of the entire uncompressed tar stream.
+func WithHeaderOverhead(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.IncludeHeaderOverhead = enabled
+	}
+}
+
+// WithExtractStats reports the largest file, the slowest file to write, and the total wall time of an extraction in
+// the result of UntarToDirectoryResult, for diagnosing slow extractions.
+func WithExtractStats(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.CollectStats = enabled
+	}
+}
+
+// WithDigestManifest populates the given map with the content digest of every regular file extracted, keyed by the
+// cleaned entry name (e.g. "usr/bin/env").
+func WithDigestManifest(manifest map[string]string) Option {
+	return func(o *UntarOptions) {
+		o.DigestManifest = manifest
+	}
+}
+
+// WithMetrics reports counters describing extraction (such as entries processed and bytes written) to the given
+// collector.
+func WithMetrics(collector MetricsCollector) Option {
+	return func(o *UntarOptions) {
+		o.Metrics = collector
+	}
+}
+
+// WithExtractionSink directs the writes made while extracting to the given sink instead of the filesystem, allowing
+// them to be redirected or intercepted.
+func WithExtractionSink(sink ExtractionSink) Option {
+	return func(o *UntarOptions) {
+		o.Sink = sink
+	}
+}
+
+// WithPAXSizeValidation causes iteration to fail with ErrSizeDisagreement when an entry's PAX size record and ustar
+// size field disagree.
+func WithPAXSizeValidation(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.ValidatePAXSize = enabled
+	}
+}
+
+// WithRequiredTerminator causes iteration to fail with ErrMissingTerminator when the archive ends without the
+// end-of-archive marker.
+func WithRequiredTerminator(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.RequireTerminator = enabled
+	}
+}
+
+// WithUnsafeLinkRejection causes iteration to fail with ErrUnsafeLinkTarget for links whose target is absolute or
+// resolves outside of the root of the archive.
+func WithUnsafeLinkRejection(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.RejectUnsafeLinks = enabled
+	}
+}
+
+// WithDeviceRejection causes iteration to fail with ErrDeviceEntry for character and block device entries.
+func WithDeviceRejection(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.RejectDevices = enabled
+	}
+}
+
+// WithRequireSorted causes iteration to fail with ErrUnsortedArchive when entries are not sorted by name (as written by
+// RewriteTar with WithSortEntries).
+func WithRequireSorted(enabled bool) Option {
+	return func(o *UntarOptions) {
+		o.RequireSorted = enabled
+	}
+}
+
+// metrics returns the configured MetricsCollector, or one discarding all metrics if none is configured.
+func (o UntarOptions) metrics() MetricsCollector {
+	if o.Metrics == nil {
+		return nopMetrics{}
+	}
+	return o.Metrics
+}
+
+// sink returns the configured ExtractionSink, or one writing to the given filesystem if none is configured.
+func (o UntarOptions) sink(fs afero.Fs) ExtractionSink {
+	if o.Sink == nil {
+		return fsSink{fs: fs}
+	}
+	return o.Sink
+}
+
+func (o UntarOptions) checkDeadline() error {
+	if !o.Deadline.IsZero() && time.Now().After(o.Deadline) {
+		return &ErrDeadlineExceeded{Deadline: o.Deadline}
+	}
+	return nil
+}
+
+// modeMask returns the configured ModeMask, or a mask keeping all bits if none is configured.
+func (o UntarOptions) modeMask() os.FileMode {
+	if o.ModeMask == 0 {
+		return ^os.FileMode(0)
+	}
+	return o.ModeMask
+}
+
+// newHash returns a hash for computing content digests along with the algorithm name used to prefix them.
+func (o UntarOptions) newHash() (hash.Hash, string) {
+	if o.HashAlgorithm == nil {
+		return sha256.New(), "sha256"
+	}
+	return o.HashAlgorithm(), o.HashAlgorithmName
+}
+
+func newUntarOptions(options ...Option) UntarOptions {
+	var cfg UntarOptions
+	for _, option := range options {
+		if option == nil {
+			continue
+		}
+		option(&cfg)
+	}
+	if cfg.openFiles == nil {
+		cfg.openFiles = newOpenFileLimiter(cfg.MaxOpenFiles)
+	}
+	if cfg.HashAlgorithm == nil {
+		cfg.HashAlgorithm, cfg.HashAlgorithmName = sha256.New, "sha256"
+	}
+	if cfg.HashAlgorithmName == "" {
+		cfg.HashAlgorithmName, _ = hashAlgorithmName(cfg.HashAlgorithm)
+	}
+	return cfg
+}
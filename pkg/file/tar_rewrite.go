@@ -0,0 +1,123 @@
+package file
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// TarEntryTransform is applied to each entry when rewriting a tar. It may modify the header of the entry in place
+// (other than its size, since the content is carried over as-is), and returns false for entries that should be
+// dropped. The content of the entry must not be read.
+type TarEntryTransform func(entry *TarFileEntry) bool
+
+// RewriteTar re-serializes the given tar to out, applying the given transform to every entry (or keeping all entries
+// as-is when transform is nil). Headers are otherwise written as read, and content is subject to the same read limit
+// as extraction.
+//
+// By default kept entries are streamed through in their original order without buffering, so the output order is
+// exactly the input order (including any duplicate names). With WithSortEntries, kept entries are instead written
+// sorted by their cleaned name, with entries of the same name retaining their relative input order, so the output is
+// deterministic for a given set of entries. Sorting is only possible once all entries were read, so their content is
+// buffered in temporary files until the output is written (or in memory, within the budget given by WithMemoryBudget).
+func RewriteTar(in io.Reader, out io.Writer, transform TarEntryTransform, options ...Option) error {
+	return rewriteTar(in, out, transform, newUntarOptions(options...))
+}
+
+// NormalizeTar rewrites the given tar (see RewriteTar) into a reproducible form, such that the output only depends on
+// the names, types, modes, link targets, extended attributes, and content of the entries: entries are sorted by name,
+// and all timestamps and ownership are reset in the headers. Either can be opted out of separately (see
+// WithPreserveOrder and WithPreserveHeaders), where preserving both results in a faithful copy of the input except for
+// the changes made by the transform (e.g. for redacting entries without reordering the archive).
+func NormalizeTar(in io.Reader, out io.Writer, transform TarEntryTransform, options ...Option) error {
+	cfg := newUntarOptions(options...)
+	cfg.SortEntries = !cfg.PreserveOrder
+	normalize := func(entry *TarFileEntry) bool {
+		if transform != nil && !transform(entry) {
+			return false
+		}
+		if !cfg.PreserveHeaders {
+			normalizeHeader(&entry.Header)
+		}
+		return true
+	}
+	return rewriteTar(in, out, normalize, cfg)
+}
+
+// normalizeHeader resets all timestamps and ownership of the given header. The writer takes these fields over any
+// equivalent PAX records, so only the remaining records (such as extended attributes) are carried over.
+func normalizeHeader(header *tar.Header) {
+	header.ModTime = time.Unix(0, 0)
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	header.Uid = 0
+	header.Gid = 0
+	header.Uname = ""
+	header.Gname = ""
+}
+
+func rewriteTar(in io.Reader, out io.Writer, transform TarEntryTransform, cfg UntarOptions) error {
+	keep := transform
+	if keep == nil {
+		keep = func(*TarFileEntry) bool { return true }
+	}
+	if cfg.SortEntries {
+		return rewriteTarSorted(in, out, keep, cfg)
+	}
+
+	tw := tar.NewWriter(out)
+	visitor := func(entry TarFileEntry) error {
+		if !keep(&entry) {
+			return nil
+		}
+		if err := tw.WriteHeader(&entry.Header); err != nil {
+			return fmt.Errorf("unable to write header for %q: %w", entry.Header.Name, err)
+		}
+		return copyWithReadLimit(tw, entry.Reader, nil)
+	}
+	if err := iterateTar(in, visitor, cfg); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func rewriteTarSorted(in io.Reader, out io.Writer, keep TarEntryTransform, cfg UntarOptions) error {
+	buffer, err := newEntryBuffer(cfg.MemoryBudget)
+	if err != nil {
+		return fmt.Errorf("unable to create temp dir for rewriting: %w", err)
+	}
+	defer buffer.close()
+
+	var entries []*bufferedEntry
+	defer func() {
+		for _, entry := range entries {
+			buffer.release(entry)
+		}
+	}()
+
+	visitor := func(entry TarFileEntry) error {
+		if !keep(&entry) {
+			return nil
+		}
+		buffered := &bufferedEntry{header: entry.Header}
+		entries = append(entries, buffered)
+		return buffer.hold(buffered, entry.Reader)
+	}
+	if err := iterateTar(in, visitor, cfg); err != nil {
+		return err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return cleanTarEntryName(entries[i].header.Name) < cleanTarEntryName(entries[j].header.Name)
+	})
+
+	tw := tar.NewWriter(out)
+	for _, entry := range entries {
+		if err := writeBufferedEntry(tw, entry); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
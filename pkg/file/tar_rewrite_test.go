@@ -0,0 +1,163 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteTar(t *testing.T) {
+	reg := func(name, content string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name}, content: content}
+	}
+	newInput := func() io.Reader {
+		return newTestTar(t,
+			reg("zeta", "z"),
+			reg("alpha", "a1"),
+			reg("skip/me", "skipped"),
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "mid/", Mode: 0755}},
+			reg("./alpha", "a2"),
+			reg("beta", "b"),
+		)
+	}
+	keep := func(entry *TarFileEntry) bool {
+		return !strings.HasPrefix(entry.Header.Name, "skip/")
+	}
+
+	tests := []struct {
+		name    string
+		keep    TarEntryTransform
+		options []Option
+		want    []string
+	}{
+		{
+			name: "preserves the original order by default",
+			keep: keep,
+			want: []string{"zeta=z", "alpha=a1", "mid/=", "./alpha=a2", "beta=b"},
+		},
+		{
+			name: "keeps all entries without a filter",
+			want: []string{"zeta=z", "alpha=a1", "skip/me=skipped", "mid/=", "./alpha=a2", "beta=b"},
+		},
+		{
+			name:    "sorts by name, keeping the input order of duplicates",
+			keep:    keep,
+			options: []Option{WithSortEntries(true)},
+			want:    []string{"alpha=a1", "./alpha=a2", "beta=b", "mid/=", "zeta=z"},
+		},
+		{
+			name:    "sorting spills content beyond the memory budget",
+			keep:    keep,
+			options: []Option{WithSortEntries(true), WithMemoryBudget(NewMemoryBudget(2))},
+			want:    []string{"alpha=a1", "./alpha=a2", "beta=b", "mid/=", "zeta=z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			require.NoError(t, RewriteTar(newInput(), out, tt.keep, tt.options...))
+			written := bytes.Clone(out.Bytes())
+			assert.Equal(t, tt.want, rewrittenEntries(t, out))
+
+			// rewriting is deterministic, so the same input always results in the same bytes
+			again := &bytes.Buffer{}
+			require.NoError(t, RewriteTar(newInput(), again, tt.keep, tt.options...))
+			assert.Equal(t, written, again.Bytes())
+		})
+	}
+}
+
+func TestRewriteTar_MemoryBudgetReleased(t *testing.T) {
+	budget := NewMemoryBudget(1 * MB)
+	input := newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file"}, content: "content"})
+	require.NoError(t, RewriteTar(input, io.Discard, nil, WithSortEntries(true), WithMemoryBudget(budget)))
+	assert.Equal(t, int64(0), budget.InUse())
+}
+
+// rewrittenEntries renders each entry of the given tar as "name=content", in order.
+func rewrittenEntries(t *testing.T, reader io.Reader) []string {
+	var entries []string
+	err := IterateTar(reader, func(entry TarFileEntry) error {
+		content, err := io.ReadAll(entry.Reader)
+		require.NoError(t, err)
+		entries = append(entries, entry.Header.Name+"="+string(content))
+		return nil
+	})
+	require.NoError(t, err)
+	return entries
+}
+
+func TestNormalizeTar(t *testing.T) {
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	newInput := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "zeta", Uid: 1000, Uname: "user", ModTime: mtime}, content: "z"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "secret", ModTime: mtime}, content: "redact me"},
+			testTarEntry{
+				header: tar.Header{
+					Typeflag:   tar.TypeReg,
+					Name:       "alpha",
+					Gid:        1000,
+					ModTime:    mtime,
+					PAXRecords: map[string]string{"SCHILY.xattr.user.key": "value"},
+				},
+				content: "a",
+			},
+		)
+	}
+	redact := func(entry *TarFileEntry) bool {
+		return entry.Header.Name != "secret"
+	}
+
+	t.Run("sorts entries and resets timestamps and ownership", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, NormalizeTar(newInput(), out, redact))
+
+		var names []string
+		err := IterateTar(out, func(entry TarFileEntry) error {
+			names = append(names, entry.Header.Name)
+			assert.Equal(t, time.Unix(0, 0), entry.Header.ModTime)
+			assert.Zero(t, entry.Header.Uid)
+			assert.Zero(t, entry.Header.Gid)
+			assert.Empty(t, entry.Header.Uname)
+			if entry.Header.Name == "alpha" {
+				assert.Equal(t, "value", entry.Header.PAXRecords["SCHILY.xattr.user.key"], "extended attributes are kept")
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"alpha", "zeta"}, names)
+	})
+
+	t.Run("no-op transform preserving order and headers is a faithful copy", func(t *testing.T) {
+		input, err := io.ReadAll(newInput())
+		require.NoError(t, err)
+
+		out := &bytes.Buffer{}
+		require.NoError(t, NormalizeTar(bytes.NewReader(input), out, nil, WithPreserveOrder(true), WithPreserveHeaders(true)))
+		assert.Equal(t, input, out.Bytes())
+	})
+
+	t.Run("redacts without reordering", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, NormalizeTar(newInput(), out, redact, WithPreserveOrder(true), WithPreserveHeaders(true)))
+
+		var names []string
+		err := IterateTar(out, func(entry TarFileEntry) error {
+			names = append(names, entry.Header.Name)
+			assert.Equal(t, mtime, entry.Header.ModTime.UTC())
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"zeta", "alpha"}, names)
+	})
+}
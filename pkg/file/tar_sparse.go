@@ -0,0 +1,265 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxSparseHeaderSize bounds how much of the raw headers of an entry are read when checking its sparse map, matching
+// the limit archive/tar applies to the same headers.
+const maxSparseHeaderSize = 1 * MB
+
+// sparseRegion is a region of data within the logical content of a sparse entry.
+type sparseRegion struct {
+	offset, length int64
+}
+
+// classifyHeaderError determines whether a failure to read the header at the given offset is due to an invalid sparse
+// map, returning ErrInvalidSparseMap if so (and the given error otherwise). archive/tar rejects such maps without
+// returning the header, so the raw headers are read again, which is only possible for seekable streams.
+func classifyHeaderError(reader io.Reader, offsets *tarHeaderOffsets, err error) error {
+	if !errors.Is(err, tar.ErrHeader) || offsets.seeker == nil || offsets.next < 0 {
+		return err
+	}
+	if _, seekErr := offsets.seeker.Seek(offsets.next, io.SeekStart); seekErr != nil {
+		return err
+	}
+	name, regions, size, ok := readSparseMap(io.LimitReader(reader, maxSparseHeaderSize))
+	if !ok {
+		return err
+	}
+	if reason := validateSparseMap(regions, size); reason != "" {
+		return &ErrInvalidSparseMap{Name: name, Reason: reason}
+	}
+	return err
+}
+
+// validateSparseMap describes why the given sparse map is invalid for the given logical size, returning an empty
+// string for valid maps. Regions must not be negative, must be in order without overlapping, and must be within the
+// logical size.
+func validateSparseMap(regions []sparseRegion, size int64) string {
+	if size < 0 {
+		return fmt.Sprintf("negative size %d", size)
+	}
+	var end int64
+	for i, r := range regions {
+		switch {
+		case r.offset < 0 || r.length < 0:
+			return fmt.Sprintf("region %d has a negative offset or length", i)
+		case r.offset > math.MaxInt64-r.length:
+			return fmt.Sprintf("region %d overflows", i)
+		case r.offset < end:
+			return fmt.Sprintf("region %d (offset=%d) overlaps or precedes the previous region (end=%d)", i, r.offset, end)
+		case r.offset+r.length > size:
+			return fmt.Sprintf("region %d (end=%d) extends beyond the size %d", i, r.offset+r.length, size)
+		}
+		end = r.offset + r.length
+	}
+	return ""
+}
+
+// readSparseMap reads the raw headers of an entry, returning its name, sparse map, and logical size. The old GNU
+// sparse format and the PAX GNU sparse formats 0.0, 0.1, and 1.0 are supported. This is false if the entry is not
+// sparse or its headers cannot be read.
+func readSparseMap(reader io.Reader) (string, []sparseRegion, int64, bool) {
+	var records map[string]string
+	var block [tarBlockSize]byte
+	for {
+		if _, err := io.ReadFull(reader, block[:]); err != nil {
+			return "", nil, 0, false
+		}
+		size, ok := parseUSTARSize(block[:])
+		if !ok {
+			return "", nil, 0, false
+		}
+		switch block[156] {
+		case tar.TypeXHeader:
+			data, err := readPaddedData(reader, size)
+			if err != nil {
+				return "", nil, 0, false
+			}
+			records = parsePAXRecords(data)
+		case tar.TypeGNULongName, tar.TypeGNULongLink:
+			if _, err := readPaddedData(reader, size); err != nil {
+				return "", nil, 0, false
+			}
+		case tar.TypeGNUSparse:
+			regions, logicalSize, ok := readOldGNUSparseMap(reader, block)
+			return headerName(block[:]), regions, logicalSize, ok
+		default:
+			name := headerName(block[:])
+			if sparseName := records["GNU.sparse.name"]; sparseName != "" {
+				name = sparseName
+			}
+			regions, logicalSize, ok := readPAXSparseMap(reader, records)
+			return name, regions, logicalSize, ok
+		}
+	}
+}
+
+func readPaddedData(reader io.Reader, size int64) ([]byte, error) {
+	if size < 0 || size > maxSparseHeaderSize {
+		return nil, tar.ErrHeader
+	}
+	data := make([]byte, tarBlockPadded(size))
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	return data[:size], nil
+}
+
+func headerName(block []byte) string {
+	name, _, _ := bytes.Cut(block[:100], []byte{0})
+	return string(name)
+}
+
+// parsePAXRecords parses the "<length> <key>=<value>\n" records of a PAX extended header. As with archive/tar, the
+// repeated offset and size records of the GNU sparse 0.0 format are combined into a single map record.
+func parsePAXRecords(data []byte) map[string]string {
+	records := make(map[string]string)
+	var sparseMap []string
+	for len(data) > 0 {
+		length, rest, ok := strings.Cut(string(data), " ")
+		n, err := strconv.Atoi(length)
+		if !ok || err != nil || n <= len(length) || n > len(data) {
+			break
+		}
+		record := strings.TrimSuffix(rest[:n-len(length)-1], "\n")
+		data = data[n:]
+		key, value, _ := strings.Cut(record, "=")
+		switch key {
+		case "GNU.sparse.offset", "GNU.sparse.numbytes":
+			sparseMap = append(sparseMap, value)
+		default:
+			records[key] = value
+		}
+	}
+	if len(sparseMap) > 0 {
+		records["GNU.sparse.map"] = strings.Join(sparseMap, ",")
+	}
+	return records
+}
+
+// readPAXSparseMap returns the sparse map described by the given PAX records, where the map of the 1.0 format is at the
+// start of the entry data read from the given reader.
+func readPAXSparseMap(reader io.Reader, records map[string]string) ([]sparseRegion, int64, bool) {
+	sizeRecord := records["GNU.sparse.size"]
+	if sizeRecord == "" {
+		sizeRecord = records["GNU.sparse.realsize"]
+	}
+	size, err := strconv.ParseInt(sizeRecord, 10, 64)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	var numbers []string
+	switch {
+	case records["GNU.sparse.major"] == "1" && records["GNU.sparse.minor"] == "0":
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, 0, false
+		}
+		fields := strings.Split(string(data), "\n")
+		count, err := strconv.Atoi(fields[0])
+		if err != nil || count < 0 || 2*count >= len(fields) {
+			return nil, 0, false
+		}
+		numbers = fields[1 : 1+2*count]
+	case records["GNU.sparse.map"] != "":
+		numbers = strings.Split(records["GNU.sparse.map"], ",")
+	default:
+		return nil, 0, false
+	}
+
+	regions, ok := parseSparseNumbers(numbers)
+	return regions, size, ok
+}
+
+func parseSparseNumbers(numbers []string) ([]sparseRegion, bool) {
+	if len(numbers)%2 != 0 {
+		return nil, false
+	}
+	regions := make([]sparseRegion, 0, len(numbers)/2)
+	for i := 0; i < len(numbers); i += 2 {
+		offset, err1 := strconv.ParseInt(numbers[i], 10, 64)
+		length, err2 := strconv.ParseInt(numbers[i+1], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, false
+		}
+		regions = append(regions, sparseRegion{offset: offset, length: length})
+	}
+	return regions, true
+}
+
+// readOldGNUSparseMap returns the sparse map of an old GNU format sparse header, which lists up to 4 regions within the
+// header block itself followed by extension blocks of up to 21 regions each.
+func readOldGNUSparseMap(reader io.Reader, block [tarBlockSize]byte) ([]sparseRegion, int64, bool) {
+	size, ok := parseTarNumeric(block[483:495])
+	if !ok {
+		return nil, 0, false
+	}
+	var regions []sparseRegion
+	entries, extended := block[386:482], block[482]
+	for {
+		for ; len(entries) >= 24 && entries[0] != 0; entries = entries[24:] {
+			offset, ok1 := parseTarNumeric(entries[:12])
+			length, ok2 := parseTarNumeric(entries[12:24])
+			if !ok1 || !ok2 {
+				return nil, 0, false
+			}
+			regions = append(regions, sparseRegion{offset: offset, length: length})
+		}
+		if extended == 0 {
+			return regions, size, true
+		}
+		if _, err := io.ReadFull(reader, block[:]); err != nil {
+			return nil, 0, false
+		}
+		entries, extended = block[:504], block[504]
+	}
+}
+
+// isSparseHeader reports whether the given header (as returned by archive/tar) describes a sparse entry in any of the
+// supported formats.
+func isSparseHeader(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// sparseDataErrors maps the messages of the errors archive/tar returns when the sparse map of an entry cannot be
+// reconciled with its archived data (which are not exported, so can only be recognized by message) to the reason
+// reported for them.
+var sparseDataErrors = map[string]string{
+	"archive/tar: sparse file references non-existent data": "sparse file references non-existent data",
+	"archive/tar: sparse file contains unreferenced data":   "sparse file contains unreferenced data",
+}
+
+// sparseContentReader reports failures of archive/tar to reconcile the sparse map of an entry with its archived data
+// (which are only detected while reading the content) as ErrInvalidSparseMap. All other errors are returned as-is.
+type sparseContentReader struct {
+	reader io.Reader
+	name   string
+}
+
+func (s *sparseContentReader) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p)
+	if err != nil {
+		if reason, ok := sparseDataErrors[err.Error()]; ok {
+			err = &ErrInvalidSparseMap{Name: s.name, Reason: reason}
+		}
+	}
+	return n, err
+}
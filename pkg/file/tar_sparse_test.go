@@ -0,0 +1,195 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawTarHeader creates a header block by hand, which allows for writing headers that tar.Writer refuses to (such as
+// PAX GNU sparse records and old GNU sparse headers).
+func rawTarHeader(name string, typeflag byte, size int64, edit func(block []byte)) []byte {
+	block := make([]byte, tarBlockSize)
+	copy(block[0:100], name)
+	copy(block[100:108], "0000644\x00")
+	copy(block[108:116], "0000000\x00")
+	copy(block[116:124], "0000000\x00")
+	copy(block[124:136], fmt.Sprintf("%011o\x00", size))
+	copy(block[136:148], "00000000000\x00")
+	block[156] = typeflag
+	copy(block[257:265], "ustar\x0000")
+	if edit != nil {
+		edit(block)
+	}
+
+	copy(block[148:156], "        ")
+	var checksum int64
+	for _, b := range block {
+		checksum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", checksum))
+	return block
+}
+
+func padTarBlock(data string) []byte {
+	return append([]byte(data), make([]byte, tarBlockPadded(int64(len(data)))-int64(len(data)))...)
+}
+
+func paxRecord(key, value string) string {
+	record := fmt.Sprintf(" %s=%s\n", key, value)
+	length := len(record)
+	for length != len(fmt.Sprint(length))+len(record) {
+		length = len(fmt.Sprint(length)) + len(record)
+	}
+	return fmt.Sprint(length) + record
+}
+
+// oldGNUSparseTar creates an archive with a single old GNU format sparse entry with the given regions.
+func oldGNUSparseTar(regions []sparseRegion, size int64) []byte {
+	var physical int64
+	for _, r := range regions {
+		physical += r.length
+	}
+	header := rawTarHeader("sparse.bin", tar.TypeGNUSparse, physical, func(block []byte) {
+		copy(block[257:265], "ustar  \x00")
+		for i, r := range regions {
+			entry := block[386+24*i:]
+			copy(entry[0:12], fmt.Sprintf("%011o\x00", r.offset))
+			copy(entry[12:24], fmt.Sprintf("%011o\x00", r.length))
+		}
+		copy(block[483:495], fmt.Sprintf("%011o\x00", size))
+	})
+	archive := append(header, make([]byte, tarBlockPadded(physical))...)
+	return append(archive, make([]byte, 2*tarBlockSize)...)
+}
+
+// paxSparseTar creates an archive with a single PAX (GNU sparse 0.1) format sparse entry with the given map and physical
+// (archived) size.
+func paxSparseTar(sparseMap string, numBlocks int, size, physical int64) []byte {
+	records := paxRecord("GNU.sparse.major", "0") +
+		paxRecord("GNU.sparse.minor", "1") +
+		paxRecord("GNU.sparse.name", "sparse.bin") +
+		paxRecord("GNU.sparse.size", fmt.Sprint(size)) +
+		paxRecord("GNU.sparse.numblocks", fmt.Sprint(numBlocks)) +
+		paxRecord("GNU.sparse.map", sparseMap)
+	archive := rawTarHeader("PaxHeaders/sparse.bin", tar.TypeXHeader, int64(len(records)), nil)
+	archive = append(archive, padTarBlock(records)...)
+	archive = append(archive, rawTarHeader("GNUSparseFile.0/sparse.bin", tar.TypeReg, physical, nil)...)
+	archive = append(archive, make([]byte, tarBlockPadded(physical))...)
+	return append(archive, make([]byte, 2*tarBlockSize)...)
+}
+
+func TestIterateTar_InvalidSparseMap(t *testing.T) {
+	invalidSparseMap := func(t require.TestingT, err error, _ ...interface{}) {
+		var invalid *ErrInvalidSparseMap
+		require.ErrorAs(t, err, &invalid)
+		assert.Equal(t, "sparse.bin", invalid.Name)
+	}
+	// sparseDataErr asserts the failure of archive/tar to reconcile the map with the data is recognized, which relies on
+	// the exact messages of its (unexported) errors
+	sparseDataErr := func(reason string) require.ErrorAssertionFunc {
+		return func(t require.TestingT, err error, _ ...interface{}) {
+			var invalid *ErrInvalidSparseMap
+			require.ErrorAs(t, err, &invalid)
+			assert.Equal(t, &ErrInvalidSparseMap{Name: "sparse.bin", Reason: reason}, invalid)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		archive   []byte
+		streaming bool
+		wantErr   require.ErrorAssertionFunc
+	}{
+		{
+			name:    "valid old GNU sparse map",
+			archive: oldGNUSparseTar([]sparseRegion{{offset: 0, length: 512}, {offset: 1024, length: 512}}, 2048),
+			wantErr: require.NoError,
+		},
+		{
+			name:    "overlapping old GNU sparse map",
+			archive: oldGNUSparseTar([]sparseRegion{{offset: 0, length: 600}, {offset: 512, length: 600}}, 2048),
+			wantErr: invalidSparseMap,
+		},
+		{
+			name:    "out of order old GNU sparse map",
+			archive: oldGNUSparseTar([]sparseRegion{{offset: 1024, length: 512}, {offset: 0, length: 512}}, 2048),
+			wantErr: invalidSparseMap,
+		},
+		{
+			name:    "old GNU sparse map beyond the size",
+			archive: oldGNUSparseTar([]sparseRegion{{offset: 1024, length: 512}}, 1200),
+			wantErr: invalidSparseMap,
+		},
+		{
+			name:    "valid PAX sparse map",
+			archive: paxSparseTar("0,0,100,0", 2, 200, 0),
+			wantErr: require.NoError,
+		},
+		{
+			name:    "overlapping PAX sparse map",
+			archive: paxSparseTar("100,0,50,0", 2, 200, 0),
+			wantErr: invalidSparseMap,
+		},
+		{
+			name:    "PAX sparse map referencing missing data",
+			archive: paxSparseTar("0,100,150,0", 2, 200, 50),
+			wantErr: sparseDataErr("sparse file references non-existent data"),
+		},
+		{
+			name:    "PAX sparse map leaving data unreferenced",
+			archive: paxSparseTar("0,50,150,0", 2, 200, 100),
+			wantErr: sparseDataErr("sparse file contains unreferenced data"),
+		},
+		{
+			name:      "overlapping sparse map from a stream",
+			archive:   oldGNUSparseTar([]sparseRegion{{offset: 0, length: 600}, {offset: 512, length: 600}}, 2048),
+			streaming: true,
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				// the map cannot be read again, so only archive/tar's error is available
+				require.ErrorIs(t, err, tar.ErrHeader)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reader io.Reader = bytes.NewReader(tt.archive)
+			if tt.streaming {
+				reader = io.MultiReader(reader)
+			}
+			err := IterateTar(reader, func(entry TarFileEntry) error {
+				_, err := io.Copy(io.Discard, entry.Reader)
+				return err
+			})
+			tt.wantErr(t, err)
+		})
+	}
+}
+
+func Test_validateSparseMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		regions []sparseRegion
+		size    int64
+		valid   bool
+	}{
+		{name: "empty", size: 10, valid: true},
+		{name: "adjacent regions", regions: []sparseRegion{{0, 5}, {5, 5}}, size: 10, valid: true},
+		{name: "overlapping regions", regions: []sparseRegion{{0, 6}, {5, 5}}, size: 10},
+		{name: "unordered regions", regions: []sparseRegion{{5, 1}, {0, 1}}, size: 10},
+		{name: "negative length", regions: []sparseRegion{{0, -1}}, size: 10},
+		{name: "beyond size", regions: []sparseRegion{{5, 6}}, size: 10},
+		{name: "negative size", size: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := validateSparseMap(tt.regions, tt.size)
+			assert.Equal(t, tt.valid, reason == "", "reason=%q", reason)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package file
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// squashEntry is the winning entry for a path while squashing layers.
+type squashEntry struct {
+	bufferedEntry
+	layer int
+}
+
+// squashState is the accumulated filesystem of all layers squashed so far.
+type squashState struct {
+	entries map[string]*squashEntry
+	buffer  *entryBuffer
+}
+
+// SquashLayers applies each of the given layer tars in order and writes the resulting squashed filesystem as a single
+// tar to out, sorted by path. Entries from later layers replace those from earlier layers, and OCI whiteouts
+// (".wh.<name>") and opaque directory markers (".wh..wh..opq") remove content contributed by earlier layers. Since the
+// squashed result is only known after reading all layers, the content of regular files is buffered in temporary files
+// until the output is written (or in memory, for as long as it fits within the budget given by WithMemoryBudget).
+func SquashLayers(layers []io.Reader, out io.Writer, options ...Option) error {
+	cfg := newUntarOptions(options...)
+	buffer, err := newEntryBuffer(cfg.MemoryBudget)
+	if err != nil {
+		return fmt.Errorf("unable to create temp dir for squashing: %w", err)
+	}
+	defer buffer.close()
+
+	state := &squashState{
+		entries: make(map[string]*squashEntry),
+		buffer:  buffer,
+	}
+	defer state.releaseAll()
+	for i, layer := range layers {
+		visitor := func(entry TarFileEntry) error {
+			return state.apply(i, entry)
+		}
+		if err := iterateTar(layer, visitor, cfg); err != nil {
+			return fmt.Errorf("unable to squash layer index=%d: %w", i, err)
+		}
+	}
+	return state.write(out)
+}
+
+func (s *squashState) apply(layer int, entry TarFileEntry) error {
+	name := cleanTarEntryName(entry.Header.Name)
+	switch base, kind := ClassifyWhiteout(name); kind {
+	case WhiteoutOpaque:
+		s.removeLower(layer, base, false)
+		return nil
+	case WhiteoutFile:
+		s.removeLower(layer, base, true)
+		return nil
+	}
+
+	if existing, ok := s.entries[name]; ok && existing.header.Typeflag == tar.TypeDir && entry.Header.Typeflag != tar.TypeDir {
+		// a directory replaced by a non-directory takes all lower content within it along with it
+		s.removeLower(layer, name, false)
+	}
+
+	squashed := &squashEntry{layer: layer, bufferedEntry: bufferedEntry{header: entry.Header}}
+	if entry.Header.Typeflag == tar.TypeReg {
+		if err := s.buffer.hold(&squashed.bufferedEntry, entry.Reader); err != nil {
+			return err
+		}
+	}
+	s.remove(name)
+	s.entries[name] = squashed
+	return nil
+}
+
+// remove drops the entry at the given name, releasing any memory held for its content.
+func (s *squashState) remove(name string) {
+	if entry, ok := s.entries[name]; ok {
+		s.buffer.release(&entry.bufferedEntry)
+		delete(s.entries, name)
+	}
+}
+
+func (s *squashState) releaseAll() {
+	for name := range s.entries {
+		s.remove(name)
+	}
+}
+
+// removeLower removes all entries contributed by layers below the given layer that are within the given directory
+// (and the directory itself, if requested).
+func (s *squashState) removeLower(layer int, dir string, includeDir bool) {
+	prefix := dir + DirSeparator
+	if dir == "." {
+		prefix = ""
+	}
+	for name, entry := range s.entries {
+		if entry.layer >= layer {
+			continue
+		}
+		if strings.HasPrefix(name, prefix) || (includeDir && name == dir) {
+			s.remove(name)
+		}
+	}
+}
+
+func (s *squashState) write(out io.Writer) error {
+	names := make([]string, 0, len(s.entries))
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tar.NewWriter(out)
+	for _, name := range names {
+		if err := writeBufferedEntry(tw, &s.entries[name].bufferedEntry); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
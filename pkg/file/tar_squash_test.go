@@ -0,0 +1,84 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSquashLayers(t *testing.T) {
+	dir := func(name string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755}}
+	}
+	reg := func(name, content string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name}, content: content}
+	}
+
+	layers := []io.Reader{
+		newTestTar(t,
+			dir("etc/"),
+			reg("etc/passwd", "root"),
+			reg("etc/shadow", "secret"),
+			dir("var/"),
+			dir("var/cache/"),
+			reg("var/cache/a", "cached"),
+		),
+		newTestTar(t,
+			reg("etc/passwd", "root,user"),
+			reg("etc/.wh.shadow", ""),
+			reg("var/cache/b", "new cache"),
+			reg("var/cache/.wh..wh..opq", ""),
+		),
+		newTestTar(t,
+			reg("etc/passwd", "root,user,admin"),
+			reg("etc/hosts", "localhost"),
+		),
+	}
+
+	out := &bytes.Buffer{}
+	require.NoError(t, SquashLayers(layers, out))
+
+	got := make(map[string]string)
+	var order []string
+	err := IterateTar(out, func(entry TarFileEntry) error {
+		content, err := io.ReadAll(entry.Reader)
+		require.NoError(t, err)
+		got[entry.Header.Name] = string(content)
+		order = append(order, entry.Header.Name)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"etc/", "etc/hosts", "etc/passwd", "var/", "var/cache/", "var/cache/b"}, order)
+	assert.Equal(t, "root,user,admin", got["etc/passwd"])
+	assert.Equal(t, "localhost", got["etc/hosts"])
+	assert.Equal(t, "new cache", got["var/cache/b"])
+}
+
+func TestSquashLayers_MemoryBudget(t *testing.T) {
+	reg := func(name, content string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name}, content: content}
+	}
+	newLayers := func() []io.Reader {
+		return []io.Reader{
+			newTestTar(t, reg("a", "12345678"), reg("b", "12345678")),
+			newTestTar(t, reg("c", "12345678")),
+		}
+	}
+
+	expected := &bytes.Buffer{}
+	require.NoError(t, SquashLayers(newLayers(), expected))
+
+	budget := NewMemoryBudget(10)
+	got := &bytes.Buffer{}
+	require.NoError(t, SquashLayers(newLayers(), got, WithMemoryBudget(budget)))
+	assert.Equal(t, expected.Bytes(), got.Bytes(), "spilling should not change the result")
+	assert.Equal(t, int64(0), budget.InUse(), "all memory should be released")
+}
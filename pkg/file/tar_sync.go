@@ -0,0 +1,165 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// recordSynced marks the given entry name (and all of its parents) as present in the archive.
+func (v tarVisitor) recordSynced(name string) {
+	if v.synced == nil {
+		return
+	}
+	for p := cleanTarEntryName(name); p != "."; p = path.Dir(p) {
+		v.synced.Add(p)
+	}
+}
+
+// syncRegularFile writes the given entry only if it differs from the corresponding file in the tree being synced against.
+func (v tarVisitor) syncRegularFile(entry TarFileEntry, target string) error {
+	existing := filepath.Join(v.options.SyncAgainst, cleanTarEntryName(entry.Header.Name))
+	if v.options.SyncCompareDigests {
+		return v.syncRegularFileByDigest(entry, target, existing)
+	}
+
+	info, err := v.fs.Stat(existing)
+	if err == nil && info.Mode().IsRegular() && info.Size() == entry.Header.Size && info.ModTime().Equal(entry.Header.ModTime) {
+		return nil
+	}
+
+	if err := v.writeRegularFile(entry, target); err != nil {
+		return err
+	}
+	return v.options.sink(v.fs).Chtimes(target, accessTime(entry.Header), entry.Header.ModTime)
+}
+
+// syncRegularFileByDigest stages the entry content in a temporary file (computing its digest as it is written) and
+// only moves it into place when the digest differs from the existing file.
+func (v tarVisitor) syncRegularFileByDigest(entry TarFileEntry, target, existing string) error {
+	v.options.openFiles.acquire()
+	existingDigest, err := v.fileDigest(existing)
+	v.options.openFiles.release()
+	if err != nil {
+		return err
+	}
+
+	sink := v.options.sink(v.fs)
+	stagedPath := stagingPath(target)
+	stagedDigest, err := v.stageFile(entry, stagedPath)
+	if err != nil || existingDigest == stagedDigest {
+		if removeErr := sink.RemoveAll(stagedPath); removeErr != nil && err == nil {
+			err = fmt.Errorf("unable to remove staged file: %w", removeErr)
+		}
+		return err
+	}
+
+	if v.options.NoOverwrite {
+		if _, err := v.fs.Stat(target); err == nil {
+			return &ErrWouldOverwrite{Path: target}
+		}
+	}
+	if err := sink.Chmod(stagedPath, v.fileMode(entry.Header).Perm()); err != nil {
+		_ = sink.RemoveAll(stagedPath)
+		return err
+	}
+	v.trackCreated(target)
+	if err := sink.Rename(stagedPath, target); err != nil {
+		_ = sink.RemoveAll(stagedPath)
+		return fmt.Errorf("unable to move staged file into place: %w", err)
+	}
+	v.options.metrics().Add(MetricBytesWritten, entry.Header.Size)
+	if err := sink.Chtimes(target, accessTime(entry.Header), entry.Header.ModTime); err != nil {
+		return err
+	}
+	return v.applyFileMode(entry.Header, target)
+}
+
+// stagingPath returns a path next to the given target for staging its content, which is unlikely to be used by
+// anything else.
+func stagingPath(target string) string {
+	return filepath.Join(filepath.Dir(target), fmt.Sprintf(".sync-%s-%d", filepath.Base(target), rand.Uint64()))
+}
+
+// stageFile writes the entry content to the given staging path, returning the digest of the content.
+func (v tarVisitor) stageFile(entry TarFileEntry, stagedPath string) (string, error) {
+	v.options.openFiles.acquire()
+	defer v.options.openFiles.release()
+
+	staged, err := v.options.sink(v.fs).CreateFile(stagedPath, 0600)
+	if err != nil {
+		return "", fmt.Errorf("unable to stage file: %w", err)
+	}
+
+	h, algorithm := v.options.newHash()
+	buf := v.copyBuffer()
+	defer v.releaseCopyBuffer(buf)
+	copyErr := copyWithReadLimit(io.MultiWriter(staged, h), entry.Reader, *buf)
+	if errors.Is(copyErr, ErrReadLimitExceeded) {
+		v.options.metrics().Inc(MetricBombsDetected)
+	}
+	if err := staged.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	return formatDigest(algorithm, h), copyErr
+}
+
+// fileDigest returns the digest of the given regular file, or an empty string if it does not exist.
+func (v tarVisitor) fileDigest(p string) (string, error) {
+	info, err := v.fs.Stat(p)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", nil
+	}
+
+	f, err := v.fs.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h, algorithm := v.options.newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("unable to digest existing file: %w", err)
+	}
+	return formatDigest(algorithm, h), nil
+}
+
+// removeUnsynced removes all paths within the tree being synced against that were not present in the archive.
+func (v tarVisitor) removeUnsynced() error {
+	if v.options.SyncAgainst == "" || !v.options.SyncDelete || v.stoppedEarly() {
+		// paths not seen may still be within the unread remainder of the archive
+		return nil
+	}
+
+	root := filepath.Clean(v.options.SyncAgainst)
+	return afero.Walk(v.fs, root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if v.synced.Has(filepath.ToSlash(rel)) {
+			return nil
+		}
+
+		if err := v.options.sink(v.fs).RemoveAll(p); err != nil {
+			return fmt.Errorf("unable to remove unsynced path=%q: %w", p, err)
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
@@ -0,0 +1,103 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUntarToDirectory_SyncAgainst(t *testing.T) {
+	modTime := time.Date(2019, time.September, 16, 0, 0, 0, 0, time.UTC)
+	reg := func(name, content string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name, ModTime: modTime}, content: content}
+	}
+
+	tests := []struct {
+		name    string
+		options func(dst string) []Option
+		// existing content and modtime of "unchanged.txt", which should not be rewritten
+		existingContent string
+		existingModTime time.Time
+		wantRemoved     bool
+	}{
+		{
+			name: "size and modtime comparison",
+			options: func(dst string) []Option {
+				return []Option{WithSyncAgainst(dst, false, false)}
+			},
+			// same size and modtime is considered unchanged even though the content differs
+			existingContent: "old!",
+			existingModTime: modTime,
+			wantRemoved:     false,
+		},
+		{
+			name: "digest comparison with delete",
+			options: func(dst string) []Option {
+				return []Option{WithSyncAgainst(dst, true, true)}
+			},
+			// same content is considered unchanged even though the modtime differs
+			existingContent: "same",
+			existingModTime: modTime.Add(time.Hour),
+			wantRemoved:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			write := func(name, content string, mtime time.Time) {
+				p := filepath.Join(dst, name)
+				require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+				require.NoError(t, os.Chtimes(p, mtime, mtime))
+			}
+			write("unchanged.txt", tt.existingContent, tt.existingModTime)
+			write("changed.txt", "previous content", modTime)
+			write("removed.txt", "stale", modTime)
+
+			reader := newTestTar(t,
+				reg("unchanged.txt", "same"),
+				reg("changed.txt", "updated"),
+				reg("added.txt", "new"),
+			)
+			require.NoError(t, UntarToDirectory(reader, dst, tt.options(dst)...))
+
+			assertContent := func(name, want string) {
+				t.Helper()
+				content, err := os.ReadFile(filepath.Join(dst, name))
+				require.NoError(t, err)
+				assert.Equal(t, want, string(content))
+			}
+			assertContent("unchanged.txt", tt.existingContent)
+			info, err := os.Stat(filepath.Join(dst, "unchanged.txt"))
+			require.NoError(t, err)
+			assert.True(t, tt.existingModTime.Equal(info.ModTime()))
+			assertContent("changed.txt", "updated")
+			assertContent("added.txt", "new")
+
+			if tt.wantRemoved {
+				assert.NoFileExists(t, filepath.Join(dst, "removed.txt"))
+			} else {
+				assert.FileExists(t, filepath.Join(dst, "removed.txt"))
+			}
+
+			// synced files carry the header modtime so that a subsequent sync sees them as unchanged
+			info, err = os.Stat(filepath.Join(dst, "added.txt"))
+			require.NoError(t, err)
+			assert.True(t, modTime.Equal(info.ModTime()))
+
+			// no staging files are left behind
+			entries, err := os.ReadDir(dst)
+			require.NoError(t, err)
+			for _, e := range entries {
+				assert.NotContains(t, e.Name(), ".sync-")
+			}
+		})
+	}
+}
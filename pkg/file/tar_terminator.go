@@ -0,0 +1,62 @@
+package file
+
+import (
+	"archive/tar"
+	"io"
+)
+
+// tarTerminatorCheck verifies that a tar stream ends with the end-of-archive marker (two zero blocks), which
+// archive/tar otherwise does not require: a stream that simply stops after the content of the last entry is read as
+// complete. This can hide truncation that happens to fall on an entry boundary.
+type tarTerminatorCheck struct {
+	position func() (int64, error)
+	// end is the offset at which the content of the last entry ends (-1 when it cannot be determined)
+	end int64
+}
+
+// newTarTerminatorCheck returns the reader to iterate over along with the check tracking it. Seekable streams are used
+// as-is since archive/tar skips unread content by seeking, otherwise the stream is wrapped to count the bytes read.
+func newTarTerminatorCheck(reader io.Reader) (io.Reader, *tarTerminatorCheck) {
+	if seeker, ok := reader.(io.Seeker); ok {
+		if start, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			return reader, &tarTerminatorCheck{
+				position: func() (int64, error) { return seeker.Seek(0, io.SeekCurrent) },
+				end:      start,
+			}
+		}
+	}
+	counter := &countingReader{reader: reader}
+	return counter, &tarTerminatorCheck{
+		position: func() (int64, error) { return counter.count, nil },
+	}
+}
+
+// advance must be called just after a header has been read, while the stream is positioned at the entry content.
+func (t *tarTerminatorCheck) advance(hdr *tar.Header) {
+	pos, err := t.position()
+	switch {
+	case err != nil, hdr.Typeflag == tar.TypeGNUSparse, isPAXSparse(hdr):
+		// the size on sparse headers is the logical size, not the size of the content within the archive
+		t.end = -1
+	case isHeaderOnlyTarType(hdr.Typeflag):
+		t.end = pos
+	default:
+		t.end = pos + tarBlockPadded(hdr.Size)
+	}
+}
+
+// verify must be called once the tar reader reports the end of the archive, failing with ErrMissingTerminator when
+// fewer than two zero blocks followed the last entry.
+func (t *tarTerminatorCheck) verify() error {
+	if t.end < 0 {
+		return nil
+	}
+	pos, err := t.position()
+	if err != nil {
+		return nil
+	}
+	if blocks := (pos - t.end) / tarBlockSize; blocks < 2 {
+		return &ErrMissingTerminator{ZeroBlocks: int(blocks)}
+	}
+	return nil
+}
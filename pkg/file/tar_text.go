@@ -0,0 +1,44 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+)
+
+const (
+	// maxTextFileSize is the largest file that IterateTarTextFiles will present to the visitor.
+	maxTextFileSize = 1 * MB
+	// binarySniffSize is how much of the leading content IterateTarTextFiles inspects for null bytes.
+	binarySniffSize = 8000
+)
+
+// IterateTarTextFiles invokes the visitor with the name and content of each regular file within the tar that appears
+// to be text, sparing callers from telling text and binary files apart themselves. A file is considered binary when
+// a null byte occurs within its first 8000 bytes (the same heuristic as git and grep). Files larger than 1 MB are
+// skipped without being read, and invalid UTF-8 sequences within text files are replaced with U+FFFD.
+func IterateTarTextFiles(reader io.Reader, visitor func(name string, content string) error) error {
+	return IterateTar(reader, func(entry TarFileEntry) error {
+		if entry.Header.Typeflag != tar.TypeReg || entry.Header.Size > maxTextFileSize {
+			return nil
+		}
+
+		content, err := io.ReadAll(io.LimitReader(entry.Reader, maxTextFileSize))
+		if err != nil {
+			return err
+		}
+		if isBinary(content) {
+			return nil
+		}
+		return visitor(entry.Header.Name, strings.ToValidUTF8(string(content), "�"))
+	})
+}
+
+// isBinary indicates if the given content appears to be binary, according to the null byte heuristic.
+func isBinary(content []byte) bool {
+	if len(content) > binarySniffSize {
+		content = content[:binarySniffSize]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterateTarTextFiles(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/os-release"}, content: "ID=alpine\nVERSION_ID=3.19\n"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "bin/busybox"}, content: "\x7fELF\x02\x01\x01\x00\x00\x00"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/latin1"}, content: "caf\xe9"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "var/huge.log"}, content: strings.Repeat("x", maxTextFileSize+1)},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "var/late-null"}, content: strings.Repeat("x", binarySniffSize) + "\x00"},
+	)
+
+	got := make(map[string]string)
+	require.NoError(t, IterateTarTextFiles(reader, func(name, content string) error {
+		got[name] = content
+		return nil
+	}))
+
+	assert.Equal(t, map[string]string{
+		"etc/os-release": "ID=alpine\nVERSION_ID=3.19\n",
+		"etc/latin1":     "caf�",
+		// null bytes past the sniffed prefix do not make a file binary
+		"var/late-null": strings.Repeat("x", binarySniffSize) + "\x00",
+	}, got)
+}
@@ -0,0 +1,108 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// TarContentTransform rewrites an entry while a tar is repacked (see TransformTar), returning the content and header
+// to write in its place. The given header may be modified and returned as-is. Returning a nil header drops the entry,
+// and returning a nil reader writes the entry without content.
+type TarContentTransform func(hdr *tar.Header, content io.Reader) (io.Reader, *tar.Header, error)
+
+// TransformTar streams the given tar to out, passing the header and content of every entry through the given
+// transform, which can rewrite both (e.g. to redact secrets from certain files). Entries are written in their original
+// order as they are read, so the input is never held in full.
+//
+// The tar format records the size of an entry before its content, so the content returned by the transform must
+// match the Size of the returned header exactly (failing otherwise). When the size of transformed content is not
+// known up front, the transform may return a header with a negative Size, in which case the content is buffered to
+// determine its size before the entry is written, in memory within the budget given by WithMemoryBudget and otherwise
+// in a temporary file. Transforms that change the size of content should therefore compute it where practical, and
+// otherwise expect the memory or disk use of buffering the largest such entry.
+func TransformTar(in io.Reader, out io.Writer, transform TarContentTransform, options ...Option) error {
+	cfg := newUntarOptions(options...)
+	t := &tarTransformer{
+		tw:        tar.NewWriter(out),
+		transform: transform,
+		buf:       make([]byte, copyBufferSize),
+		budget:    cfg.MemoryBudget,
+	}
+	defer t.close()
+	if err := iterateTar(in, t.visit, cfg); err != nil {
+		return err
+	}
+	return t.tw.Close()
+}
+
+// tarTransformer is the state of a single TransformTar call.
+type tarTransformer struct {
+	tw        *tar.Writer
+	transform TarContentTransform
+	buf       []byte
+	// buffer holds content of unknown size, created only once needed
+	buffer *entryBuffer
+	// budget is the memory budget for holding content of unknown size
+	budget *MemoryBudget
+}
+
+func (t *tarTransformer) visit(entry TarFileEntry) error {
+	content, header, err := t.transform(&entry.Header, entry.Reader)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		return nil
+	}
+	if content == nil {
+		content = bytes.NewReader(nil)
+	}
+	if header.Size < 0 {
+		return t.writeBuffered(*header, content)
+	}
+
+	if err := t.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("unable to write header for %q: %w", header.Name, err)
+	}
+	counter := &countingReader{reader: content}
+	if err := copyWithLimit(t.tw, counter, t.buf, perFileReadLimit); err != nil {
+		return fmt.Errorf("unable to write content for %q: %w", header.Name, err)
+	}
+	if counter.count != header.Size {
+		return fmt.Errorf("transformed content for %q does not match its size (size=%d content=%d)", header.Name, header.Size, counter.count)
+	}
+	return nil
+}
+
+// writeBuffered writes an entry whose content is of unknown size, buffering the content to determine the size.
+func (t *tarTransformer) writeBuffered(header tar.Header, content io.Reader) error {
+	if t.buffer == nil {
+		buffer, err := newEntryBuffer(t.budget)
+		if err != nil {
+			return fmt.Errorf("unable to create temp dir for transforming: %w", err)
+		}
+		t.buffer = buffer
+	}
+
+	buffered := &bufferedEntry{header: header}
+	counter := &countingReader{reader: content}
+	if err := t.buffer.holdUnsized(buffered, counter); err != nil {
+		return err
+	}
+	defer t.buffer.release(buffered)
+	if buffered.content != "" {
+		defer os.Remove(buffered.content)
+	}
+
+	buffered.header.Size = counter.count
+	return writeBufferedEntry(t.tw, buffered)
+}
+
+func (t *tarTransformer) close() {
+	if t.buffer != nil {
+		t.buffer.close()
+	}
+}
@@ -0,0 +1,144 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformTar(t *testing.T) {
+	reg := func(name, content string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name}, content: content}
+	}
+	newInput := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755}},
+			reg("etc/secret", "password=hunter2"),
+			reg("etc/hosts", "127.0.0.1"),
+		)
+	}
+	// redact replaces the content of the secret entry, keeping all other entries as-is
+	redact := func(replacement string, size int64) TarContentTransform {
+		return func(hdr *tar.Header, content io.Reader) (io.Reader, *tar.Header, error) {
+			if hdr.Name != "etc/secret" {
+				return content, hdr, nil
+			}
+			hdr.Size = size
+			return strings.NewReader(replacement), hdr, nil
+		}
+	}
+	errTransform := errors.New("transform failed")
+
+	tests := []struct {
+		name      string
+		transform TarContentTransform
+		want      []string
+		wantErr   require.ErrorAssertionFunc
+	}{
+		{
+			name:      "rewrites content of the same size",
+			transform: redact("password=*******", 16),
+			want:      []string{"etc/=", "etc/secret=password=*******", "etc/hosts=127.0.0.1"},
+			wantErr:   require.NoError,
+		},
+		{
+			name:      "rewrites content of a different size",
+			transform: redact("REDACTED", 8),
+			want:      []string{"etc/=", "etc/secret=REDACTED", "etc/hosts=127.0.0.1"},
+			wantErr:   require.NoError,
+		},
+		{
+			name:      "buffers content of unknown size",
+			transform: redact("REDACTED", -1),
+			want:      []string{"etc/=", "etc/secret=REDACTED", "etc/hosts=127.0.0.1"},
+			wantErr:   require.NoError,
+		},
+		{
+			name: "rewrites headers",
+			transform: func(hdr *tar.Header, content io.Reader) (io.Reader, *tar.Header, error) {
+				renamed := *hdr
+				renamed.Name = strings.Replace(hdr.Name, "etc", "conf", 1)
+				return content, &renamed, nil
+			},
+			want:    []string{"conf/=", "conf/secret=password=hunter2", "conf/hosts=127.0.0.1"},
+			wantErr: require.NoError,
+		},
+		{
+			name: "drops entries without a header",
+			transform: func(hdr *tar.Header, content io.Reader) (io.Reader, *tar.Header, error) {
+				if hdr.Name == "etc/secret" {
+					return nil, nil, nil
+				}
+				return content, hdr, nil
+			},
+			want:    []string{"etc/=", "etc/hosts=127.0.0.1"},
+			wantErr: require.NoError,
+		},
+		{
+			name: "writes entries without a reader as empty",
+			transform: func(hdr *tar.Header, content io.Reader) (io.Reader, *tar.Header, error) {
+				hdr.Size = 0
+				return nil, hdr, nil
+			},
+			want:    []string{"etc/=", "etc/secret=", "etc/hosts="},
+			wantErr: require.NoError,
+		},
+		{
+			name:      "content shorter than the header size",
+			transform: redact("REDACTED", 16),
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				assert.ErrorContains(t, err, `transformed content for "etc/secret" does not match its size (size=16 content=8)`)
+			},
+		},
+		{
+			name:      "content longer than the header size",
+			transform: redact("REDACTED", 4),
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				assert.ErrorIs(t, err, tar.ErrWriteTooLong)
+			},
+		},
+		{
+			name: "transform error",
+			transform: func(hdr *tar.Header, content io.Reader) (io.Reader, *tar.Header, error) {
+				return nil, nil, errTransform
+			},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				assert.ErrorIs(t, err, errTransform)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := TransformTar(newInput(), out, tt.transform)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.want, rewrittenEntries(t, out))
+		})
+	}
+}
+
+func TestTransformTar_MemoryBudget(t *testing.T) {
+	for _, budget := range []*MemoryBudget{NewMemoryBudget(1024), NewMemoryBudget(4)} {
+		input := newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/secret"}, content: "password=hunter2"})
+		out := &bytes.Buffer{}
+		err := TransformTar(input, out, func(hdr *tar.Header, content io.Reader) (io.Reader, *tar.Header, error) {
+			hdr.Size = -1
+			return strings.NewReader("REDACTED"), hdr, nil
+		}, WithMemoryBudget(budget))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"etc/secret=REDACTED"}, rewrittenEntries(t, out))
+		assert.Zero(t, budget.InUse(), "buffered content is released once written")
+	}
+}
@@ -0,0 +1,74 @@
+package file
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ErrDigestMismatch is returned when the content of a regular file within a tar does not match its expected digest.
+type ErrDigestMismatch struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e *ErrDigestMismatch) Error() string {
+	return fmt.Sprintf("digest mismatch (path=%s expected=%s actual=%s)", e.Path, e.Expected, e.Actual)
+}
+
+// VerifyTarAgainstDigests computes the SHA256 digest of every regular file in the tar named in expected (a map of path
+// to hex digest, optionally prefixed with "sha256:") in a single pass, returning an error combining an
+// ErrDigestMismatch for every file with unexpected content and an ErrFileNotFound for every expected path that is not
+// a regular file within the tar. Paths are compared relative to the root of the archive (so "./etc/passwd",
+// "/etc/passwd", and "etc/passwd" are equivalent), and when a path is repeated the last entry wins.
+func VerifyTarAgainstDigests(reader io.Reader, expected map[string]string) error {
+	want := make(map[string]string, len(expected))
+	for p, digest := range expected {
+		want[cleanTarEntryName(p)] = strings.TrimPrefix(strings.ToLower(digest), "sha256:")
+	}
+
+	actual := make(map[string]string)
+	visitor := func(entry TarFileEntry) error {
+		name := cleanTarEntryName(entry.Header.Name)
+		if _, ok := want[name]; !ok {
+			return nil
+		}
+		if entry.Header.Typeflag != tar.TypeReg {
+			// this may replace a regular file earlier in the archive
+			delete(actual, name)
+			return nil
+		}
+		h := sha256.New()
+		if err := copyWithReadLimit(h, entry.Reader, nil); err != nil {
+			return err
+		}
+		actual[name] = fmt.Sprintf("%x", h.Sum(nil))
+		return nil
+	}
+	if err := IterateTar(reader, visitor); err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(want))
+	for p := range want {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var errs []error
+	for _, p := range paths {
+		digest, ok := actual[p]
+		switch {
+		case !ok:
+			errs = append(errs, &ErrFileNotFound{Path: p})
+		case digest != want[p]:
+			errs = append(errs, &ErrDigestMismatch{Path: p, Expected: want[p], Actual: digest})
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,54 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyTarAgainstDigests(t *testing.T) {
+	sha := func(content string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+	}
+	newReader := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/passwd"}, content: "root:x:0:0"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/shadow"}, content: "tampered"},
+		)
+	}
+
+	t.Run("all match", func(t *testing.T) {
+		require.NoError(t, VerifyTarAgainstDigests(newReader(), map[string]string{
+			"etc/passwd":  sha("root:x:0:0"),
+			"/etc/shadow": "sha256:" + sha("tampered"),
+		}))
+	})
+
+	t.Run("mismatched and missing", func(t *testing.T) {
+		err := VerifyTarAgainstDigests(newReader(), map[string]string{
+			"etc/passwd":     sha("root:x:0:0"),
+			"etc/shadow":     sha("original"),
+			"etc/os-release": sha("ID=test"),
+		})
+		require.Error(t, err)
+
+		var mismatch *ErrDigestMismatch
+		require.ErrorAs(t, err, &mismatch)
+		assert.Equal(t, &ErrDigestMismatch{Path: "etc/shadow", Expected: sha("original"), Actual: sha("tampered")}, mismatch)
+
+		var missing *ErrFileNotFound
+		require.ErrorAs(t, err, &missing)
+		assert.Equal(t, "etc/os-release", missing.Path)
+
+		assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 2, "only the mismatched and missing paths should be reported")
+	})
+}
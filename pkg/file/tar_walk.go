@@ -0,0 +1,99 @@
+package file
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+
+	"github.com/scylladb/go-set/strset"
+)
+
+// TarDirEntry is the fs.DirEntry presented for each tar entry by WalkTar. The entry content is available from Reader,
+// but only for the duration of the callback.
+type TarDirEntry struct {
+	entry TarFileEntry
+}
+
+var _ fs.DirEntry = (*TarDirEntry)(nil)
+
+// Name returns the base name of the entry.
+func (d *TarDirEntry) Name() string {
+	return path.Base(cleanTarEntryName(d.entry.Header.Name))
+}
+
+// IsDir reports whether the entry is a directory.
+func (d *TarDirEntry) IsDir() bool {
+	return d.entry.Header.FileInfo().IsDir()
+}
+
+// Type returns the type bits of the entry mode.
+func (d *TarDirEntry) Type() fs.FileMode {
+	return d.entry.Header.FileInfo().Mode().Type()
+}
+
+// Info returns the file info described by the entry header.
+func (d *TarDirEntry) Info() (fs.FileInfo, error) {
+	return d.entry.Header.FileInfo(), nil
+}
+
+// Header returns the raw tar header of the entry.
+func (d *TarDirEntry) Header() tar.Header {
+	return d.entry.Header
+}
+
+// Reader returns the content of the entry, which may only be read within the callback.
+func (d *TarDirEntry) Reader() io.Reader {
+	return d.entry.Reader
+}
+
+// WalkTar invokes the given fs.WalkDirFunc for each entry of the tar, allowing walk-based tooling to run directly over
+// an archive without extracting it. The path given to the callback is relative to the root of the archive (the root
+// itself being "."), and the fs.DirEntry is a *TarDirEntry, which provides access to the entry content.
+//
+// Unlike fs.WalkDir, entries are visited in archive order and directories not listed in the archive are not
+// synthesized. Returning fs.SkipDir from the callback skips all later entries within the directory (or, for a
+// non-directory, within its parent directory), and fs.SkipAll stops the walk without error. The callback is never
+// invoked with a non-nil error; errors reading the archive are returned directly.
+func WalkTar(reader io.Reader, fn fs.WalkDirFunc, options ...Option) error {
+	skipped := strset.New()
+	visitor := func(entry TarFileEntry) error {
+		name := cleanTarEntryName(entry.Header.Name)
+		if isWithinSkipped(name, skipped) {
+			return nil
+		}
+
+		d := &TarDirEntry{entry: entry}
+		err := fn(name, d, nil)
+		switch {
+		case errors.Is(err, fs.SkipAll):
+			return ErrTarStopIteration
+		case errors.Is(err, fs.SkipDir):
+			dir := name
+			if !d.IsDir() {
+				dir = path.Dir(name)
+			}
+			if dir == "." {
+				return ErrTarStopIteration
+			}
+			skipped.Add(dir)
+			return nil
+		}
+		return err
+	}
+	return IterateTar(reader, visitor, options...)
+}
+
+// isWithinSkipped indicates if the given path is, or is within, any of the skipped directories.
+func isWithinSkipped(name string, skipped *strset.Set) bool {
+	if skipped.IsEmpty() {
+		return false
+	}
+	for p := name; p != "."; p = path.Dir(p) {
+		if skipped.Has(p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalkTar(t *testing.T) {
+	newReader := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "./etc/", Mode: 0755}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "./etc/passwd"}, content: "root"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "./var/", Mode: 0755}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "./var/cache/", Mode: 0755}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "./var/cache/a"}, content: "a"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "./var/log"}, content: "log"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "./link", Linkname: "etc/passwd"}},
+		)
+	}
+
+	type walked struct {
+		path    string
+		name    string
+		isDir   bool
+		content string
+	}
+
+	tests := []struct {
+		name    string
+		skip    map[string]error
+		want    []walked
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "all entries",
+			want: []walked{
+				{path: ".", name: ".", isDir: true},
+				{path: "etc", name: "etc", isDir: true},
+				{path: "etc/passwd", name: "passwd", content: "root"},
+				{path: "var", name: "var", isDir: true},
+				{path: "var/cache", name: "cache", isDir: true},
+				{path: "var/cache/a", name: "a", content: "a"},
+				{path: "var/log", name: "log", content: "log"},
+				{path: "link", name: "link"},
+			},
+		},
+		{
+			name: "skip directory",
+			skip: map[string]error{"var/cache": fs.SkipDir},
+			want: []walked{
+				{path: ".", name: ".", isDir: true},
+				{path: "etc", name: "etc", isDir: true},
+				{path: "etc/passwd", name: "passwd", content: "root"},
+				{path: "var", name: "var", isDir: true},
+				{path: "var/cache", name: "cache", isDir: true},
+				{path: "var/log", name: "log", content: "log"},
+				{path: "link", name: "link"},
+			},
+		},
+		{
+			name: "skip directory from a file",
+			skip: map[string]error{"var/cache/a": fs.SkipDir},
+			want: []walked{
+				{path: ".", name: ".", isDir: true},
+				{path: "etc", name: "etc", isDir: true},
+				{path: "etc/passwd", name: "passwd", content: "root"},
+				{path: "var", name: "var", isDir: true},
+				{path: "var/cache", name: "cache", isDir: true},
+				{path: "var/cache/a", name: "a", content: "a"},
+				{path: "var/log", name: "log", content: "log"},
+				{path: "link", name: "link"},
+			},
+		},
+		{
+			name: "skip all",
+			skip: map[string]error{"etc/passwd": fs.SkipAll},
+			want: []walked{
+				{path: ".", name: ".", isDir: true},
+				{path: "etc", name: "etc", isDir: true},
+				{path: "etc/passwd", name: "passwd", content: "root"},
+			},
+		},
+		{
+			name: "callback error",
+			skip: map[string]error{"etc": errors.New("boom")},
+			want: []walked{
+				{path: ".", name: ".", isDir: true},
+				{path: "etc", name: "etc", isDir: true},
+			},
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			var got []walked
+			err := WalkTar(newReader(), func(p string, d fs.DirEntry, err error) error {
+				require.NoError(t, err)
+				entry, ok := d.(*TarDirEntry)
+				require.True(t, ok)
+				content, err := io.ReadAll(entry.Reader())
+				require.NoError(t, err)
+				got = append(got, walked{path: p, name: d.Name(), isDir: d.IsDir(), content: string(content)})
+				return tt.skip[p]
+			})
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTarDirEntry(t *testing.T) {
+	var entries []*TarDirEntry
+	err := WalkTar(newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "bin/", Mode: 0755}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "bin/sh", Linkname: "bash"}},
+	), func(_ string, d fs.DirEntry, _ error) error {
+		entries = append(entries, d.(*TarDirEntry))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, fs.ModeDir, entries[0].Type())
+	assert.Equal(t, fs.ModeSymlink, entries[1].Type())
+	assert.Equal(t, "bash", entries[1].Header().Linkname)
+
+	info, err := entries[0].Info()
+	require.NoError(t, err)
+	assert.Equal(t, fs.ModeDir|0755, info.Mode())
+}
@@ -0,0 +1,76 @@
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TarToZip converts the given tar into a zip archive written to out. Directories and regular files are carried over
+// along with their modes and modification times, and regular file content is subject to the same read limit as
+// extraction. All other entry types (links, devices, etc.) are skipped, which is reported via the OnWarning callback.
+// As with extraction, entries are counted with the metrics collector (see WithMetrics), where skipped entries are
+// counted as MetricEntriesSkipped and all other processed entries are written to the zip.
+func TarToZip(in io.Reader, out io.Writer, options ...Option) error {
+	cfg := newUntarOptions(options...)
+	zw := zip.NewWriter(out)
+	visitor := func(entry TarFileEntry) error {
+		return writeZipEntry(zw, entry, cfg)
+	}
+	if err := iterateTar(in, visitor, cfg); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("unable to finalize zip: %w", err)
+	}
+	return nil
+}
+
+func writeZipEntry(zw *zip.Writer, entry TarFileEntry, cfg UntarOptions) error {
+	cfg.metrics().Inc(MetricEntriesProcessed)
+	switch entry.Header.Typeflag {
+	case tar.TypeDir, tar.TypeReg:
+	default:
+		skipEntry(cfg.metrics(), entry, "unsupported")
+		if cfg.OnWarning != nil {
+			cfg.OnWarning(entry.Sequence, fmt.Errorf("skipping unsupported typeflag %q for tar entry=%q in zip", entry.Header.Typeflag, entry.Header.Name))
+		}
+		return nil
+	}
+
+	// names are anchored to the archive root, so no entry can escape the root of the zip
+	name := cleanTarEntryName(entry.Header.Name)
+	if name == "." {
+		skipEntry(cfg.metrics(), entry, "root entry")
+		return nil
+	}
+
+	zipHeader, err := zip.FileInfoHeader(entry.Header.FileInfo())
+	if err != nil {
+		return fmt.Errorf("unable to create zip header: %w", err)
+	}
+	zipHeader.Name = name
+	if entry.Header.Typeflag == tar.TypeDir {
+		zipHeader.Name += "/"
+		zipHeader.Method = zip.Store
+	} else {
+		zipHeader.Method = zip.Deflate
+	}
+
+	w, err := zw.CreateHeader(zipHeader)
+	if err != nil {
+		return fmt.Errorf("unable to write zip header: %w", err)
+	}
+	if entry.Header.Typeflag == tar.TypeDir {
+		return nil
+	}
+	counter := &countingReader{reader: entry.Reader}
+	err = copyWithReadLimit(w, counter, nil)
+	if errors.Is(err, ErrReadLimitExceeded) {
+		cfg.metrics().Inc(MetricBombsDetected)
+	}
+	cfg.metrics().Add(MetricBytesWritten, counter.count)
+	return err
+}
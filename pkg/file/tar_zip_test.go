@@ -0,0 +1,76 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarToZip(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "bin/", Mode: 0755, ModTime: mtime}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "bin/tool", Mode: 0755, ModTime: mtime}, content: "#!/bin/sh\n"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "bin/link", Linkname: "tool"}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/config", Mode: 0600, ModTime: mtime}, content: "key=value\n"},
+	)
+
+	var warnings []int64
+	metrics := &fakeMetrics{}
+	out := &bytes.Buffer{}
+	require.NoError(t, TarToZip(reader, out, WithMetrics(metrics), WithWarningHandler(func(sequence int64, _ error) {
+		warnings = append(warnings, sequence)
+	})))
+	assert.Equal(t, []int64{3}, warnings, "the symlink should be reported as skipped")
+	assert.Equal(t, map[string]int64{
+		MetricEntriesProcessed: 5,
+		MetricEntriesSkipped:   2,
+		MetricBytesWritten:     20,
+	}, metrics.counters, "the root entry and symlink should be counted as skipped")
+
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.NoError(t, err)
+
+	type zipEntry struct {
+		name    string
+		mode    os.FileMode
+		content string
+	}
+	var got []zipEntry
+	for _, f := range zr.File {
+		assert.True(t, mtime.Equal(f.Modified), "mtime for %q", f.Name)
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		got = append(got, zipEntry{name: f.Name, mode: f.Mode(), content: string(content)})
+	}
+	assert.Equal(t, []zipEntry{
+		{name: "bin/", mode: os.ModeDir | 0755},
+		{name: "bin/tool", mode: 0755, content: "#!/bin/sh\n"},
+		{name: "etc/config", mode: 0600, content: "key=value\n"},
+	}, got)
+}
+
+func TestTarToZip_Traversal(t *testing.T) {
+	reader := newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "../escape"}, content: "bad"})
+	out := &bytes.Buffer{}
+	require.NoError(t, TarToZip(reader, out))
+
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 1)
+	assert.Equal(t, "escape", zr.File[0].Name)
+}
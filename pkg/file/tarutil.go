@@ -2,13 +2,21 @@ package file
 
 import (
 	"archive/tar"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
+	"github.com/scylladb/go-set/strset"
 	"github.com/spf13/afero"
 
 	"github.com/anchore/stereoscope/internal/log"
@@ -18,6 +26,54 @@ const perFileReadLimit = 2 * GB
 
 var ErrTarStopIteration = fmt.Errorf("halt iterating tar")
 
+// stopIterationError halts iteration with an error (see StopIterationWithError).
+type stopIterationError struct {
+	err error
+}
+
+// StopIterationWithError returns an error that, when returned from a visitor, halts iteration (as ErrTarStopIteration
+// does) yet fails it with the given error. The given error is returned to the caller as-is, without the context of
+// the entry being visited, which allows for reporting the outcome of a check such as a policy rejecting the archive.
+func StopIterationWithError(err error) error {
+	return &stopIterationError{err: err}
+}
+
+func (e *stopIterationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stopIterationError) Unwrap() []error {
+	return []error{ErrTarStopIteration, e.err}
+}
+
+// stoppedIteration returns the result of iteration halted by the given visitor error: nil for ErrTarStopIteration, the
+// error given to StopIterationWithError, and otherwise the visitor error itself.
+func stoppedIteration(err error) error {
+	var stop *stopIterationError
+	if errors.As(err, &stop) {
+		return stop.err
+	}
+	if errors.Is(err, ErrTarStopIteration) {
+		return nil
+	}
+	return err
+}
+
+// maxConsecutiveNilHeaders bounds how many times in a row reading a header may yield neither a header nor an error
+// before the archive is considered malformed (instead of spinning indefinitely).
+const maxConsecutiveNilHeaders = 3
+
+// tarEntryReader is the part of tar.Reader used for iterating over entries.
+type tarEntryReader interface {
+	io.Reader
+	Next() (*tar.Header, error)
+}
+
+// newTarReader creates the reader used for iterating over entries (replaceable for testing).
+var newTarReader = func(reader io.Reader) tarEntryReader {
+	return tar.NewReader(reader)
+}
+
 // tarFile is a ReadCloser of a tar file on disk.
 type tarFile struct {
 	io.Reader
@@ -29,6 +85,19 @@ type TarFileEntry struct {
 	Sequence int64
 	Header   tar.Header
 	Reader   io.Reader
+	// DetectedContentType is the sniffed content type of regular files, populated only when content type
+	// detection is enabled (see WithContentTypeDetection).
+	DetectedContentType string
+}
+
+// TarEntryConsumption describes how much of the content of an entry a visitor read (see WithEntryConsumptionHandler).
+type TarEntryConsumption struct {
+	Sequence int64
+	Name     string
+	// Size is the size of the entry content according to the header.
+	Size int64
+	// Consumed is the number of content bytes read by the visitor.
+	Consumed int64
 }
 
 // TarFileVisitor is a visitor function meant to be used in conjunction with the IterateTar.
@@ -43,54 +112,393 @@ func (e *ErrFileNotFound) Error() string {
 	return fmt.Sprintf("file not found (path=%s)", e.Path)
 }
 
+// ErrFileTooLarge returned from BytesFromTar if a file within the given archive is larger than allowed.
+type ErrFileTooLarge struct {
+	Path  string
+	Size  int64
+	Limit int64
+}
+
+func (e *ErrFileTooLarge) Error() string {
+	return fmt.Sprintf("file too large (path=%s size=%d limit=%d)", e.Path, e.Size, e.Limit)
+}
+
 // IterateTar is a function that reads across a tar and invokes a visitor function for each entry discovered. The iterator
 // stops when there are no more entries to read, if there is an error in the underlying reader or visitor function,
-// or if the visitor function returns a ErrTarStopIteration sentinel error.
-func IterateTar(reader io.Reader, visitor TarFileVisitor) error {
-	tarReader := tar.NewReader(reader)
+// or if the visitor function returns a ErrTarStopIteration sentinel error. That is, a visitor can:
+//
+//   - return nil to continue with the next entry
+//   - return ErrTarStopIteration to stop cleanly, with IterateTar returning nil
+//   - return any other error to stop with a failure, which is returned wrapped with the position of the entry, or
+//     return an error from StopIterationWithError to stop with a failure that is returned as-is
+//
+// Visitors are not required to read the
+// content of an entry: any content left unread (in part or in full) is skipped when advancing to the next entry, which
+// for seekable readers is done without reading it at all. This makes header-only scans (see HeadersFromTar) cheap.
+func IterateTar(reader io.Reader, visitor TarFileVisitor, options ...Option) error {
+	return iterateTar(reader, visitor, newUntarOptions(options...))
+}
+
+func iterateTar(reader io.Reader, visitor TarFileVisitor, cfg UntarOptions) error {
+	source := readerName(reader)
+	log.WithFields("path", source).Debug("iterating tar")
+
+	var entries, size int64
+	counted := func(entry TarFileEntry) error {
+		entries++
+		size += entry.Header.Size
+		return visitor(entry)
+	}
+	if err := iterateTarEntries(reader, counted, cfg); err != nil {
+		log.WithFields("path", source, "sequence", entries, "size", size, "error", err).Debug("failed iterating tar")
+		return err
+	}
+	log.WithFields("path", source, "sequence", entries, "size", size).Debug("finished iterating tar")
+	return nil
+}
+
+// readerName returns the name of the given reader for logging, which is the path for files (and empty otherwise).
+func readerName(reader io.Reader) string {
+	if named, ok := reader.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return ""
+}
+
+func iterateTarEntries(reader io.Reader, visitor TarFileVisitor, cfg UntarOptions) error {
+	reader, headers, terminator := wrapTarStream(reader, cfg)
+	tarReader := newTarReader(reader)
+	offsets := newTarHeaderOffsets(reader)
+	inspector := newTarInspector(cfg, headers)
 	var sequence int64 = -1
+	var nilHeaders int
 	for {
 		sequence++
 
+		if err := cfg.checkDeadline(); err != nil {
+			log.WithFields("sequence", sequence).Debug("tar iteration hit the deadline")
+			return err
+		}
+
 		hdr, err := tarReader.Next()
 		if errors.Is(err, io.EOF) {
+			if terminator != nil {
+				return terminator.verify()
+			}
 			break
 		}
 		if err != nil {
-			return err
+			err = classifyHeaderError(reader, offsets, err)
+			return fmt.Errorf("failed to read tar header %s: %w", tarPosition{sequence: sequence, offset: offsets.next}, err)
 		}
 		if hdr == nil {
+			// archive/tar never returns a nil header without an error, so these are not counted as entries
+			sequence--
+			if nilHeaders++; nilHeaders > maxConsecutiveNilHeaders {
+				return &ErrMalformedArchive{Reason: fmt.Sprintf("no header read after %d attempts", nilHeaders)}
+			}
 			continue
 		}
+		nilHeaders = 0
+		headerOffset := offsets.advance(hdr)
+		if terminator != nil {
+			terminator.advance(hdr)
+		}
+
+		if hdr.Typeflag == tar.TypeXGlobalHeader && cfg.OnGlobalHeader != nil {
+			// global headers describe the archive rather than an entry, so are not counted as one
+			cfg.OnGlobalHeader(hdr.PAXRecords)
+			sequence--
+			continue
+		}
+
+		position := tarPosition{sequence: sequence, offset: headerOffset}
+		if err := inspector.inspect(sequence, hdr); err != nil {
+			return fmt.Errorf("invalid tar entry=%q %s : %w", hdr.Name, position, err)
+		}
 
-		if err := visitor(TarFileEntry{
-			Sequence: sequence,
-			Header:   *hdr,
-			Reader:   tarReader,
-		}); err != nil {
-			if errors.Is(err, ErrTarStopIteration) {
-				return nil
+		readErr, err := visitTarEntry(cfg, visitor, sequence, hdr, tarReader, position)
+		if err != nil {
+			return stoppedIteration(err)
+		}
+		if readErr != nil {
+			var resumed bool
+			if tarReader, resumed = resumeTar(reader, offsets); !resumed {
+				return fmt.Errorf("failed to read tar entry=%q %s : %w", hdr.Name, position, readErr)
 			}
-			return fmt.Errorf("failed to visit tar entry=%q : %w", hdr.Name, err)
 		}
 	}
 	return nil
 }
 
+// wrapTarStream wraps the given tar stream with the readers needed by the checks enabled in the given options, which
+// all preserve any io.Seeker implementation of the stream.
+func wrapTarStream(reader io.Reader, cfg UntarOptions) (io.Reader, *headerRecorder, *tarTerminatorCheck) {
+	// retries are innermost, such that all other checks only see the stream once read successfully
+	reader = newRetryingTarSource(reader, cfg)
+	var headers *headerRecorder
+	if cfg.ValidatePAXSize {
+		reader, headers = newHeaderRecorder(reader)
+	}
+	var terminator *tarTerminatorCheck
+	if cfg.RequireTerminator {
+		reader, terminator = newTarTerminatorCheck(reader)
+	}
+	return reader, headers, terminator
+}
+
+// visitTarEntry presents the current entry to the visitor. When entry errors are being handled (see
+// WithEntryErrorHandler), a failure to read the entry content is reported and returned separately from all other
+// errors, so that iteration may continue with the next entry.
+func visitTarEntry(cfg UntarOptions, visitor TarFileVisitor, sequence int64, hdr *tar.Header, content io.Reader, position tarPosition) (readErr, err error) {
+	if isSparseHeader(hdr) {
+		content = &sparseContentReader{reader: content, name: hdr.Name}
+	}
+	var recorder *errorRecordingReader
+	if cfg.OnEntryError != nil {
+		recorder = &errorRecordingReader{reader: content}
+		content = recorder
+	}
+
+	var entry TarFileEntry
+	entry, err = newTarFileEntry(cfg, sequence, hdr, content)
+	if err != nil {
+		err = fmt.Errorf("failed to read tar entry=%q %s : %w", hdr.Name, position, err)
+	} else if err = visitEntry(cfg, visitor, entry); err != nil {
+		err = fmt.Errorf("failed to visit tar entry=%q %s : %w", hdr.Name, position, err)
+	}
+
+	if recorder != nil && recorder.err != nil && !errors.Is(err, ErrTarStopIteration) {
+		cfg.OnEntryError(entry, recorder.err)
+		return recorder.err, nil
+	}
+	return nil, err
+}
+
+// resumeTar positions the stream at the header following an entry whose content could not be read, returning a new
+// tar reader from that point (the existing tar reader cannot continue past a read error). This is only possible for
+// seekable streams where the offset of the next header is known.
+func resumeTar(reader io.Reader, offsets *tarHeaderOffsets) (tarEntryReader, bool) {
+	if offsets.seeker == nil || offsets.next < 0 {
+		return nil, false
+	}
+	if _, err := offsets.seeker.Seek(offsets.next, io.SeekStart); err != nil {
+		return nil, false
+	}
+	return newTarReader(reader), true
+}
+
+// visitEntry invokes the visitor for the given entry, reporting how much of the entry content the visitor consumed
+// when requested.
+func visitEntry(cfg UntarOptions, visitor TarFileVisitor, entry TarFileEntry) error {
+	if cfg.OnEntryConsumed == nil {
+		return visitor(entry)
+	}
+	counter := &countingReader{reader: entry.Reader}
+	entry.Reader = counter
+	err := visitor(entry)
+	cfg.OnEntryConsumed(TarEntryConsumption{
+		Sequence: entry.Sequence,
+		Name:     entry.Header.Name,
+		Size:     entry.Header.Size,
+		Consumed: counter.count,
+	})
+	return err
+}
+
+// newTarFileEntry creates the entry presented to visitors, wrapping the content reader as the options require.
+func newTarFileEntry(cfg UntarOptions, sequence int64, hdr *tar.Header, content io.Reader) (TarFileEntry, error) {
+	entry := TarFileEntry{
+		Sequence: sequence,
+		Header:   *hdr,
+		Reader:   content,
+	}
+
+	if !cfg.Deadline.IsZero() {
+		entry.Reader = &deadlineReader{reader: entry.Reader, deadline: cfg.Deadline}
+	}
+
+	if cfg.DetectContentType && hdr.Typeflag == tar.TypeReg {
+		contentType, reader, err := sniffContentType(entry.Reader)
+		if err != nil {
+			return entry, err
+		}
+		entry.DetectedContentType = contentType
+		entry.Reader = reader
+	}
+	return entry, nil
+}
+
+// sniffContentType classifies the leading bytes of the given content, returning a reader that still yields the
+// full content.
+func sniffContentType(content io.Reader) (string, io.Reader, error) {
+	// http.DetectContentType considers at most 512 bytes
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(content, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", nil, fmt.Errorf("unable to read content for type detection: %w", err)
+	}
+	buf = buf[:n]
+	return http.DetectContentType(buf), io.MultiReader(bytes.NewReader(buf), content), nil
+}
+
+// headerWarning returns a description of any recoverable oddity with the given header, which would otherwise be
+// silently accepted.
+func headerWarning(hdr *tar.Header) error {
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, // nolint: staticcheck
+		tar.TypeFifo, tar.TypeCont, tar.TypeXGlobalHeader, tar.TypeGNUSparse:
+		return nil
+	}
+	return fmt.Errorf("unknown typeflag %q for tar entry=%q", hdr.Typeflag, hdr.Name)
+}
+
+// tarHeaderOffsets tracks the byte offset of each header within a seekable tar stream so that errors can be
+// correlated with the raw archive (e.g. a hex dump). Offsets are -1 when they cannot be determined.
+type tarHeaderOffsets struct {
+	seeker io.Seeker
+	// next is the expected offset of the next header to be read
+	next int64
+}
+
+func newTarHeaderOffsets(reader io.Reader) *tarHeaderOffsets {
+	offsets := &tarHeaderOffsets{next: -1}
+	if seeker, ok := reader.(io.Seeker); ok {
+		if pos, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			offsets.seeker = seeker
+			offsets.next = pos
+		}
+	}
+	return offsets
+}
+
+// advance must be called just after a header has been read, returning the offset of that header and computing the
+// offset of the header that follows.
+func (t *tarHeaderOffsets) advance(hdr *tar.Header) int64 {
+	current := t.next
+	if t.seeker == nil {
+		return current
+	}
+
+	// the reader is positioned at the start of the entry content
+	pos, err := t.seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.next = -1
+		return current
+	}
+
+	switch {
+	case isHeaderOnlyTarType(hdr.Typeflag):
+		t.next = pos
+	case hdr.Typeflag == tar.TypeGNUSparse || isPAXSparse(hdr):
+		// the size on the header is the logical size, not the size of the content within the archive
+		t.next = -1
+	default:
+		t.next = pos + tarBlockPadded(hdr.Size)
+	}
+	return current
+}
+
+func isHeaderOnlyTarType(flag byte) bool {
+	switch flag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		return true
+	}
+	return false
+}
+
+func isPAXSparse(hdr *tar.Header) bool {
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+func tarBlockPadded(size int64) int64 {
+	const blockSize = 512
+	return (size + blockSize - 1) / blockSize * blockSize
+}
+
+// tarPosition locates an entry within a tar for error messages. The description is only rendered when needed, since
+// the position of every entry is tracked while iterating.
+type tarPosition struct {
+	sequence int64
+	// offset is the offset of the entry header (-1 when unknown)
+	offset int64
+}
+
+func (p tarPosition) String() string {
+	if p.offset < 0 {
+		return fmt.Sprintf("(sequence=%d)", p.sequence)
+	}
+	return fmt.Sprintf("(sequence=%d offset=%d)", p.sequence, p.offset)
+}
+
+// IterateTarSection is IterateTar over a tar embedded within a larger file, starting at the given offset and spanning
+// length bytes.
+func IterateTarSection(ra io.ReaderAt, offset, length int64, visitor TarFileVisitor, options ...Option) error {
+	return IterateTar(io.NewSectionReader(ra, offset, length), visitor, options...)
+}
+
 // ReaderFromTar returns a io.ReadCloser for the Path within a tar file.
 func ReaderFromTar(reader io.ReadCloser, tarPath string) (io.ReadCloser, error) {
+	result, _, err := ReaderAndHeaderFromTar(reader, tarPath)
+	return result, err
+}
+
+// ReaderAndHeaderFromTar returns a io.ReadCloser for the Path within a tar file along with the header of the entry,
+// which saves a second pass over the archive with MetadataFromTar when details such as the size or mode are needed.
+func ReaderAndHeaderFromTar(reader io.ReadCloser, tarPath string) (io.ReadCloser, tar.Header, error) {
 	var result io.ReadCloser
+	var header tar.Header
 
+	matcher := newTarPathMatcher(tarPath)
 	visitor := func(entry TarFileEntry) error {
-		if entry.Header.Name == tarPath {
+		if matcher.matches(entry.Header.Name) {
 			result = &tarFile{
 				Reader: entry.Reader,
 				Closer: reader,
 			}
+			header = entry.Header
 			return ErrTarStopIteration
 		}
 		return nil
 	}
+	if err := IterateTar(reader, visitor); err != nil {
+		return nil, tar.Header{}, err
+	}
+
+	if result == nil {
+		return nil, tar.Header{}, &ErrFileNotFound{tarPath}
+	}
+
+	return result, header, nil
+}
+
+// BytesFromTar returns the content of the Path within a tar file, failing with ErrFileTooLarge when the content is
+// larger than maxBytes (without reading it). The given reader is closed once the content has been read.
+func BytesFromTar(reader io.ReadCloser, tarPath string, maxBytes int64) ([]byte, error) {
+	defer reader.Close()
+
+	var result []byte
+	matcher := newTarPathMatcher(tarPath)
+	visitor := func(entry TarFileEntry) error {
+		if !matcher.matches(entry.Header.Name) {
+			return nil
+		}
+		if entry.Header.Size > maxBytes {
+			return &ErrFileTooLarge{Path: tarPath, Size: entry.Header.Size, Limit: maxBytes}
+		}
+		// the tar reader yields exactly the size given on the header, so no further limit is needed while reading
+		content, err := io.ReadAll(entry.Reader)
+		if err != nil {
+			return fmt.Errorf("unable to read %q from tar: %w", tarPath, err)
+		}
+		result = content
+		return ErrTarStopIteration
+	}
 	if err := IterateTar(reader, visitor); err != nil {
 		return nil, err
 	}
@@ -98,15 +506,34 @@ func ReaderFromTar(reader io.ReadCloser, tarPath string) (io.ReadCloser, error)
 	if result == nil {
 		return nil, &ErrFileNotFound{tarPath}
 	}
-
 	return result, nil
 }
 
+// tarPathMatcher indicates if entry names are the requested path. Names must match exactly, except for the root of
+// the archive, which may be requested as any of its forms (".", "./", or "/"). The requested path is normalized once
+// up front so that matching each entry does not allocate.
+type tarPathMatcher struct {
+	path string
+	root bool
+}
+
+func newTarPathMatcher(tarPath string) tarPathMatcher {
+	return tarPathMatcher{path: tarPath, root: cleanTarEntryName(tarPath) == "."}
+}
+
+func (m tarPathMatcher) matches(name string) bool {
+	if name == m.path {
+		return true
+	}
+	return m.root && cleanTarEntryName(name) == "."
+}
+
 // MetadataFromTar returns the tar metadata from the header info.
 func MetadataFromTar(reader io.ReadCloser, tarPath string) (Metadata, error) {
 	var metadata *Metadata
+	matcher := newTarPathMatcher(tarPath)
 	visitor := func(entry TarFileEntry) error {
-		if entry.Header.Name == tarPath {
+		if matcher.matches(entry.Header.Name) {
 			var content io.Reader
 			if entry.Header.Size > 0 {
 				content = reader
@@ -126,67 +553,735 @@ func MetadataFromTar(reader io.ReadCloser, tarPath string) (Metadata, error) {
 	return *metadata, nil
 }
 
+// AllMetadataFromTar returns the tar metadata from the header info of every entry in a single pass. Content is not
+// read, thus no MIME types are populated.
+func AllMetadataFromTar(reader io.Reader) ([]Metadata, error) {
+	var metadata []Metadata
+	visitor := func(entry TarFileEntry) error {
+		metadata = append(metadata, NewMetadata(entry.Header, nil))
+		return nil
+	}
+	if err := IterateTar(reader, visitor); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// HeadersFromTar returns the headers of every entry in a single pass, in archive order. Content is not read.
+func HeadersFromTar(reader io.Reader) ([]tar.Header, error) {
+	var headers []tar.Header
+	visitor := func(entry TarFileEntry) error {
+		headers = append(headers, entry.Header)
+		return nil
+	}
+	if err := IterateTar(reader, visitor); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// ContainsDevices indicates if the archive has any character or block device entries, stopping at the first one found.
+// Content is not read.
+func ContainsDevices(reader io.Reader) (bool, error) {
+	var found bool
+	visitor := func(entry TarFileEntry) error {
+		if isDevice(&entry.Header) {
+			found = true
+			return ErrTarStopIteration
+		}
+		return nil
+	}
+	if err := IterateTar(reader, visitor); err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+// TarFormats counts the entries of the archive by the format each was read as (see tar.Header.Format), which is useful
+// for diagnosing interoperability issues with the tools that produced the archive. The format of each entry is also
+// available to visitors from the header of the entry. The format is as detected by archive/tar, so entries without
+// any PAX records count as tar.FormatUSTAR (regardless of the writer), and entries in the original (pre-POSIX) tar
+// format count as tar.FormatUnknown. Content is not read.
+func TarFormats(reader io.Reader) (map[tar.Format]int, error) {
+	formats := make(map[tar.Format]int)
+	visitor := func(entry TarFileEntry) error {
+		formats[entry.Header.Format]++
+		return nil
+	}
+	if err := IterateTar(reader, visitor); err != nil {
+		return nil, err
+	}
+	return formats, nil
+}
+
+// TopLevelEntries returns the distinct first path components of all entries (e.g. "bin", "etc", and "usr" for a
+// typical image filesystem), sorted by name. Leading "./" and "/" are ignored, and the root entry itself is not
+// included. Content is not read.
+func TopLevelEntries(reader io.Reader) ([]string, error) {
+	names := strset.New()
+	visitor := func(entry TarFileEntry) error {
+		name := cleanTarEntryName(entry.Header.Name)
+		if name == "." {
+			return nil
+		}
+		first, _, _ := strings.Cut(name, DirSeparator)
+		names.Add(first)
+		return nil
+	}
+	if err := IterateTar(reader, visitor); err != nil {
+		return nil, err
+	}
+	result := names.List()
+	sort.Strings(result)
+	return result, nil
+}
+
 // UntarToDirectory writes the contents of the given tar reader to the given destination. Note: this is meant to handle
 // archives for images (not image contents) thus intentionally does not handle links or any kinds of special files.
-func UntarToDirectory(reader io.Reader, dst string) error {
-	return IterateTar(
-		reader,
-		tarVisitor{
-			fs:          afero.NewOsFs(),
-			destination: dst,
-		}.visit,
-	)
+func UntarToDirectory(reader io.Reader, dst string, options ...Option) error {
+	if len(options) == 0 {
+		return defaultExtractor.Untar(reader, dst)
+	}
+	return NewExtractor(options...).Untar(reader, dst)
+}
+
+// UntarResult summarizes an extraction.
+type UntarResult struct {
+	// Entries is the number of entries read from the archive (whether or not they were extracted).
+	Entries int64
+	// Size is the number of uncompressed bytes of regular file content read while extracting. When header overhead
+	// is included (see WithHeaderOverhead), this is instead the size of the entire uncompressed tar stream, including
+	// headers, padding, and the end-of-archive marker.
+	Size int64
+	// Stats describes where the time of the extraction went (only when collected, see WithExtractStats).
+	Stats *ExtractStats
+}
+
+// ExtractStats reports the entries dominating an extraction, for diagnosing why extracting an archive is slow.
+type ExtractStats struct {
+	// Largest is the largest regular file extracted.
+	Largest ExtractEntryStats
+	// Slowest is the regular file that took the longest to write.
+	Slowest ExtractEntryStats
+	// Duration is the wall time of the entire extraction.
+	Duration time.Duration
+}
+
+// ExtractEntryStats describes the extraction of a single regular file.
+type ExtractEntryStats struct {
+	Name     string
+	Size     int64
+	Duration time.Duration
+}
+
+// record accounts for the given regular file having been written in the given time.
+func (s *ExtractStats) record(header tar.Header, duration time.Duration) {
+	entry := ExtractEntryStats{Name: header.Name, Size: header.Size, Duration: duration}
+	if s.Largest.Name == "" || entry.Size > s.Largest.Size {
+		s.Largest = entry
+	}
+	if s.Slowest.Name == "" || entry.Duration > s.Slowest.Duration {
+		s.Slowest = entry
+	}
+}
+
+// UntarToDirectoryResult writes the contents of the given tar reader to the given destination (see UntarToDirectory),
+// returning a summary of what was extracted, such as the uncompressed size of the archive content. This allows for
+// accounting for the size of a (compressed) layer while it is streamed to disk, without a separate pass.
+func UntarToDirectoryResult(reader io.Reader, dst string, options ...Option) (UntarResult, error) {
+	if len(options) == 0 {
+		return defaultExtractor.UntarWithResult(reader, dst)
+	}
+	return NewExtractor(options...).UntarWithResult(reader, dst)
 }
 
 type tarVisitor struct {
 	fs          afero.Fs
 	destination string
+	options     UntarOptions
+	// seenFolded maps the lowercased form of every extracted path (and its parents) to the first name seen, used
+	// for case collision detection.
+	seenFolded map[string]string
+	// synced are the cleaned names of all entries (and their parents) seen when syncing against an existing tree
+	synced *strset.Set
+	// buffers is an optional pool of copy buffers shared across extractions
+	buffers *sync.Pool
+	// dirHeaders maps each extracted directory to the header of the last entry seen for it (only when applying
+	// directory modes or times)
+	dirHeaders map[string]tar.Header
+	// budget tracks the content bytes extracted against the ExtractBudget (only when set)
+	budget *extractBudget
+	// result accumulates the summary of the extraction (only when requested, see Extractor.UntarWithResult)
+	result *UntarResult
+	// linkTargets maps the target path of every extracted regular file to its header, and hardlinked is the set of
+	// target paths sharing content through a hardlink (only when materializing hardlinks)
+	linkTargets map[string]tar.Header
+	hardlinked  map[string]struct{}
+	// created are the paths created by the extraction (only when cleaning up on error)
+	created *createdPaths
+}
+
+// extractBudget is the state of an extraction bounded by ExtractBudget.
+type extractBudget struct {
+	used int64
+	// exhausted is set once extraction stopped short of the end of the archive
+	exhausted bool
+}
+
+func newTarVisitor(fs afero.Fs, dst string, options UntarOptions) tarVisitor {
+	v := tarVisitor{
+		fs:          fs,
+		destination: dst,
+		options:     options,
+		seenFolded:  make(map[string]string),
+		synced:      strset.New(),
+	}
+	if options.ApplyDirectoryModes || options.ForceModes || options.ApplyDirectoryTimes {
+		v.dirHeaders = make(map[string]tar.Header)
+	}
+	if options.ExtractBudget > 0 {
+		v.budget = &extractBudget{}
+	}
+	if options.Hardlinks != HardlinkSkip {
+		v.linkTargets = make(map[string]tar.Header)
+		v.hardlinked = make(map[string]struct{})
+	}
+	if options.CleanupOnError {
+		v.created = &createdPaths{}
+	}
+	return v
 }
 
 func (v tarVisitor) visit(entry TarFileEntry) error {
-	target := filepath.Join(v.destination, entry.Header.Name)
+	entry, err := v.named(entry)
+	if err != nil {
+		return err
+	}
+	return v.visitNamed(entry)
+}
 
-	// we should not allow for any destination path to be outside of where we are unarchiving to
-	// "." is a special case that we allow (it is the root of the unarchived content)
-	withinDir := v.destination + string(os.PathSeparator)
-	if !strings.HasPrefix(target, withinDir) && entry.Header.Name != "." {
-		return fmt.Errorf("potential path traversal attack with entry: %q", entry.Header.Name)
+// named accounts for the given entry as processed, returning it with the name it should be extracted as (see
+// entryName).
+func (v tarVisitor) named(entry TarFileEntry) (TarFileEntry, error) {
+	v.options.metrics().Inc(MetricEntriesProcessed)
+	if v.result != nil {
+		v.result.Entries++
+	}
+	name, err := v.entryName(entry.Header.Name)
+	if err != nil {
+		return entry, err
+	}
+	entry.Header.Name = name
+	return entry, nil
+}
+
+// visitNamed extracts the given entry, whose name has already been resolved (see named).
+func (v tarVisitor) visitNamed(entry TarFileEntry) error {
+	target, err := v.targetPath(entry.Header.Name)
+	if err != nil {
+		v.options.metrics().Inc(MetricTraversalsBlocked)
+		return err
+	}
+
+	if v.options.DetectCaseCollisions {
+		if err := v.checkCaseCollision(entry.Header); err != nil {
+			if !v.options.LogCaseCollisions {
+				return err
+			}
+			log.WithFields("path", entry.Header.Name).Warn(err.Error())
+		}
+	}
+
+	if v.options.SyncAgainst != "" {
+		v.recordSynced(entry.Header.Name)
 	}
 
 	switch entry.Header.Typeflag {
-	case tar.TypeSymlink, tar.TypeLink:
+	case tar.TypeSymlink:
 		// we don't handle this is to prevent any potential traversal attacks
-		log.WithFields("path", entry.Header.Name).Trace("skipping symlink/link entry in image tar")
+		skipEntry(v.options.metrics(), entry, "symlink/link")
 
-	case tar.TypeDir:
-		// we don't need to do anything for directories, they are created as needed
-		if entry.Header.Name == "." {
+	case tar.TypeLink:
+		if v.linkTargets == nil {
+			skipEntry(v.options.metrics(), entry, "symlink/link")
 			return nil
 		}
-		if _, err := v.fs.Stat(target); err != nil {
-			if err := v.fs.MkdirAll(target, 0755); err != nil {
-				return err
-			}
+		return v.visitHardlink(entry, target)
+
+	case tar.TypeDir, tar.TypeReg:
+		if extract, err := v.resolveTypeConflict(entry.Header, target); !extract {
+			return err
+		}
+		if entry.Header.Typeflag == tar.TypeDir {
+			return v.visitDirectory(entry, target)
 		}
+		return v.visitRegularFile(entry, target)
 
-	case tar.TypeReg:
-		f, err := v.fs.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(entry.Header.Mode))
-		if err != nil {
+	default:
+		skipEntry(v.options.metrics(), entry, "special file")
+	}
+	return nil
+}
+
+// skipEntry counts and logs an entry that is deliberately not extracted.
+func skipEntry(metrics MetricsCollector, entry TarFileEntry, kind string) {
+	metrics.Inc(MetricEntriesSkipped)
+	logSkippedEntry(entry, kind)
+}
+
+// logSkippedEntry logs an entry that is not extracted. This is logged at trace level, since archives may hold many of
+// these (e.g. symlinks within most image layers).
+func logSkippedEntry(entry TarFileEntry, kind string) {
+	log.WithFields("path", entry.Header.Name, "sequence", entry.Sequence, "size", entry.Header.Size).
+		Tracef("skipping %s entry in image tar", kind)
+}
+
+// logLimitHit logs that the processing of the given entry hit the named limit.
+func logLimitHit(entry TarFileEntry, limit string) {
+	log.WithFields("path", entry.Header.Name, "sequence", entry.Sequence, "size", entry.Header.Size).
+		Debugf("tar entry hit the %s", limit)
+}
+
+// resolveTypeConflict applies the OnTypeConflict policy when the target already exists as a different kind of node
+// than the entry (a directory versus anything else), returning whether the entry should still be extracted.
+func (v tarVisitor) resolveTypeConflict(header tar.Header, target string) (bool, error) {
+	info, err := v.fs.Stat(target)
+	if err != nil || info.IsDir() == (header.Typeflag == tar.TypeDir) {
+		return true, nil
+	}
+	conflict := &ErrTypeConflict{
+		Path:     target,
+		Existing: TypeFromMode(info.Mode()),
+		Entry:    TypeFromTarType(header.Typeflag),
+	}
+
+	switch v.options.OnTypeConflict {
+	case TypeConflictSkip:
+		log.WithFields("path", header.Name).Trace(conflict.Error())
+		return false, nil
+	case TypeConflictReplace:
+		// never remove the destination itself (e.g. for a regular file entry named "."), only nodes within it. Note that
+		// removing a symlink removes the link, not what it points to.
+		if filepath.Clean(target) == filepath.Clean(v.destination) {
+			return false, conflict
+		}
+		if err := v.fs.RemoveAll(target); err != nil {
+			return false, fmt.Errorf("unable to remove conflicting node (path=%s): %w", target, err)
+		}
+		return true, nil
+	}
+	return false, conflict
+}
+
+// entryName returns the name the given entry should be extracted as, which is the result of the entry name pipeline
+// (see UntarOptions.NameSanitizer):
+//  1. names that are not valid UTF-8 are remapped with SanitizeName
+//  2. every name is remapped with NameSanitizer
+//  3. names that are still not valid UTF-8 are rejected when RequireUTF8Names is set
+func (v tarVisitor) entryName(name string) (string, error) {
+	original := name
+	if v.options.SanitizeName != nil && !utf8.ValidString(name) {
+		sanitized := v.options.SanitizeName(name)
+		log.WithFields("name", name, "sanitized", sanitized).Trace("remapped non-UTF-8 entry name")
+		name = sanitized
+	}
+	if v.options.NameSanitizer != nil {
+		if sanitized := v.options.NameSanitizer(name); sanitized != name {
+			log.WithFields("name", name, "sanitized", sanitized).Debug("remapped entry name")
+			name = sanitized
+		}
+	}
+	if v.options.RequireUTF8Names && !utf8.ValidString(name) {
+		return "", &ErrInvalidUTF8Name{Name: original}
+	}
+	return name, nil
+}
+
+// targetPath returns the path within the destination for the given entry name, rejecting any name that would
+// resolve outside of the destination.
+func (v tarVisitor) targetPath(name string) (string, error) {
+	return SafeJoin(v.destination, name)
+}
+
+// SafeJoin returns the cleaned path of the given tar entry name within dst, or an ErrPathTraversal if the name would
+// resolve outside of dst (e.g. "../etc/passwd"). Absolute entry names are anchored to dst, and names resolving to dst
+// itself (such as ".", the root of the archive) are allowed.
+func SafeJoin(dst, entryName string) (string, error) {
+	dst = filepath.Clean(dst)
+	target := filepath.Join(dst, entryName)
+
+	// we should not allow for any destination path to be outside of where we are unarchiving to
+	rel, err := filepath.Rel(dst, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", &ErrPathTraversal{Name: entryName}
+	}
+	return target, nil
+}
+
+func (v tarVisitor) visitDirectory(entry TarFileEntry, target string) error {
+	// we don't need to do anything for directories, they are created as needed. The root of the archive (in any of its
+	// forms, such as "." or "./") is the destination itself, which is left as-is.
+	if cleanTarEntryName(entry.Header.Name) == "." {
+		return nil
+	}
+	if _, err := v.fs.Stat(target); err != nil {
+		v.trackCreated(target)
+		if err := v.options.sink(v.fs).MkdirAll(target, 0755); err != nil {
 			return err
 		}
+	}
+	if v.dirHeaders != nil {
+		// the last entry for a directory wins, regardless of how many times it is repeated within the archive
+		v.dirHeaders[target] = entry.Header
+	}
+	if err := v.applyOwnership(entry.Header, target); err != nil {
+		return err
+	}
+	return v.applySpecialBits(entry.Header, target)
+}
+
+// directoryMode is the mode to apply to a directory for the given header, which only includes setuid, setgid, and
+// sticky bits when these are being preserved (or is the forced mode, regardless of the header).
+func (v tarVisitor) directoryMode(header tar.Header) os.FileMode {
+	if v.options.ForceModes {
+		return v.options.ForceDirMode
+	}
+	mode := v.headerMode(header)
+	if v.options.PreserveSpecialBits {
+		return mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	}
+	return mode.Perm()
+}
 
-		// limit the reader on each file read to prevent decompression bomb attacks
-		numBytes, err := io.Copy(f, io.LimitReader(entry.Reader, perFileReadLimit))
-		if numBytes >= perFileReadLimit || errors.Is(err, io.EOF) {
-			return fmt.Errorf("zip read limit hit (potential decompression bomb attack)")
+// applyDirectoryHeaders sets the mode and modification time (as configured) from the recorded header of every
+// extracted directory. This is deferred until all entries have been extracted so that restrictive modes (e.g. 0555)
+// cannot prevent writing the directory contents, and since writing the contents updates the modification time.
+func (v tarVisitor) applyDirectoryHeaders() error {
+	for target, header := range v.dirHeaders {
+		if v.options.ApplyDirectoryModes || v.options.ForceModes {
+			if err := v.options.sink(v.fs).Chmod(target, v.directoryMode(header)); err != nil {
+				return fmt.Errorf("unable to set directory mode: %w", err)
+			}
 		}
-		if err != nil {
-			return fmt.Errorf("unable to copy file: %w", err)
+		if v.options.ApplyDirectoryTimes {
+			if err := v.options.sink(v.fs).Chtimes(target, accessTime(header), header.ModTime); err != nil {
+				return fmt.Errorf("unable to set directory times: %w", err)
+			}
 		}
+	}
+	return nil
+}
+
+// accessTime is the access time from the given header, which falls back to the modification time for formats that do
+// not record access times.
+func accessTime(header tar.Header) time.Time {
+	if header.AccessTime.IsZero() {
+		return header.ModTime
+	}
+	return header.AccessTime
+}
 
-		if err = f.Close(); err != nil {
-			log.Errorf("failed to close file during untar of path=%q: %w", f.Name(), err)
+func (v tarVisitor) visitRegularFile(entry TarFileEntry, target string) error {
+	if entry.Reader == nil {
+		// entries built by callers (rather than read from an archive) may have no content
+		entry.Reader = bytes.NewReader(nil)
+	}
+	if v.options.OnLargeFile != nil && entry.Header.Size > v.options.LargeFileThreshold {
+		if v.options.OnLargeFile(entry.Header.Name, entry.Header.Size) {
+			skipEntry(v.options.metrics(), entry, "large file")
+			return nil
 		}
 	}
+	if err := v.spendExtractBudget(entry); err != nil {
+		return err
+	}
+	if v.result != nil {
+		counter := &countingReader{reader: entry.Reader}
+		entry.Reader = counter
+		defer func() {
+			v.result.Size += counter.count
+		}()
+	}
+	if v.options.DigestManifest == nil {
+		return v.extractRegularFile(entry, target)
+	}
+
+	// the digest is computed as the content is written, so that the content is only read once
+	h, algorithm := v.options.newHash()
+	digested := &countingReader{reader: io.TeeReader(entry.Reader, h)}
+	entry.Reader = digested
+	if err := v.extractRegularFile(entry, target); err != nil {
+		return err
+	}
+	if digested.count == entry.Header.Size {
+		recordDigest(v.options.DigestManifest, cleanTarEntryName(entry.Header.Name), formatDigest(algorithm, h))
+	}
 	return nil
 }
+
+// digestManifestLock serializes writes to digest manifests, since the same manifest is shared by all extractions of an
+// Extractor (which may run concurrently).
+var digestManifestLock sync.Mutex
+
+func recordDigest(manifest map[string]string, name, digest string) {
+	digestManifestLock.Lock()
+	defer digestManifestLock.Unlock()
+	manifest[name] = digest
+}
+
+func (v tarVisitor) extractRegularFile(entry TarFileEntry, target string) error {
+	var start time.Time
+	if v.collectingStats() {
+		start = time.Now()
+	}
+
+	var err error
+	if v.options.SyncAgainst != "" {
+		err = v.syncRegularFile(entry, target)
+	} else {
+		err = v.writeRegularFile(entry, target)
+	}
+	if err == nil && v.linkTargets != nil {
+		v.linkTargets[target] = entry.Header
+	}
+	if err == nil && v.collectingStats() {
+		v.result.Stats.record(entry.Header, time.Since(start))
+	}
+	return err
+}
+
+// collectingStats indicates if the extraction statistics are being collected (see WithExtractStats).
+func (v tarVisitor) collectingStats() bool {
+	return v.result != nil && v.result.Stats != nil
+}
+
+func (v tarVisitor) writeRegularFile(entry TarFileEntry, target string) error {
+	if v.options.NoOverwrite {
+		if _, err := v.fs.Stat(target); err == nil {
+			return &ErrWouldOverwrite{Path: target}
+		}
+	}
+	if err := v.unlinkShared(target); err != nil {
+		return err
+	}
+
+	if err := v.copyToFile(entry, target); err != nil {
+		return err
+	}
+	if v.options.ApplyFileTimes {
+		if err := v.options.sink(v.fs).Chtimes(target, accessTime(entry.Header), entry.Header.ModTime); err != nil {
+			return fmt.Errorf("unable to set file times: %w", err)
+		}
+	}
+	return v.applyFileMode(entry.Header, target)
+}
+
+// copyToFile creates (or truncates) the target file and writes the entry content to it. The file is opened, written,
+// and closed while holding a slot from the open file limiter.
+func (v tarVisitor) copyToFile(entry TarFileEntry, target string) error {
+	v.options.openFiles.acquire()
+	defer v.options.openFiles.release()
+
+	retry := writeRetry{attempts: v.options.WriteRetryAttempts, backoff: v.options.WriteRetryBackoff}
+	var f io.WriteCloser
+	v.trackCreated(target)
+	err := retry.do(func() (err error) {
+		f, err = v.options.sink(v.fs).CreateFile(target, v.fileMode(entry.Header))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var dst io.Writer = f
+	if retry.enabled() {
+		dst = retryingWriter{writer: f, retry: retry}
+	}
+
+	buf := v.copyBuffer()
+	defer v.releaseCopyBuffer(buf)
+	if err := copyWithReadLimit(dst, entry.Reader, *buf); err != nil {
+		if errors.Is(err, ErrReadLimitExceeded) {
+			logLimitHit(entry, "read limit")
+			v.options.metrics().Inc(MetricBombsDetected)
+		}
+		f.Close()
+		return err
+	}
+	v.options.metrics().Add(MetricBytesWritten, entry.Header.Size)
+
+	if err = f.Close(); err != nil {
+		log.Errorf("failed to close file during untar of path=%q: %w", target, err)
+	}
+	return nil
+}
+
+// copyBuffer returns a buffer for copying file content, which is accounted for in the memory budget until released.
+func (v tarVisitor) copyBuffer() *[]byte {
+	v.options.MemoryBudget.acquire(copyBufferSize)
+	if v.buffers == nil {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	}
+	return v.buffers.Get().(*[]byte)
+}
+
+func (v tarVisitor) releaseCopyBuffer(buf *[]byte) {
+	if v.buffers != nil {
+		v.buffers.Put(buf)
+	}
+	v.options.MemoryBudget.release(copyBufferSize)
+}
+
+// copyWithReadLimit copies the given entry content using the given buffer, limiting the reader on each file read to
+// prevent decompression bomb attacks.
+func copyWithReadLimit(dst io.Writer, content io.Reader, buf []byte) error {
+	return copyWithLimit(dst, content, buf, perFileReadLimit)
+}
+
+// copyWithLimit is copyWithReadLimit with the given limit.
+func copyWithLimit(dst io.Writer, content io.Reader, buf []byte, limit int64) error {
+	// hide any io.ReaderFrom implementation on the destination so that the given buffer is used
+	_, err := io.CopyBuffer(struct{ io.Writer }{dst}, NewLimitedReader(content, limit), buf)
+	if errors.Is(err, ErrReadLimitExceeded) {
+		return fmt.Errorf("zip read limit hit (potential decompression bomb attack): %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to copy file: %w", err)
+	}
+	return nil
+}
+
+// spendExtractBudget accounts for the content of the given entry against the ExtractBudget. Once the entry would
+// exceed the budget, extraction either fails or stops cleanly (before the entry is written), as configured.
+func (v tarVisitor) spendExtractBudget(entry TarFileEntry) error {
+	if v.budget == nil {
+		return nil
+	}
+	if v.budget.used+entry.Header.Size > v.options.ExtractBudget {
+		logLimitHit(entry, "extract budget")
+		v.budget.exhausted = true
+		if v.options.ErrorOnExtractBudget {
+			return &ErrExtractBudgetExceeded{Budget: v.options.ExtractBudget}
+		}
+		return ErrTarStopIteration
+	}
+	v.budget.used += entry.Header.Size
+	return nil
+}
+
+// stoppedEarly indicates that extraction ended before the end of the archive.
+func (v tarVisitor) stoppedEarly() bool {
+	return v.budget != nil && v.budget.exhausted
+}
+
+// fileMode is the mode to create a regular file with for the given header.
+func (v tarVisitor) fileMode(header tar.Header) os.FileMode {
+	if v.options.ForceModes {
+		return v.options.ForceFileMode
+	}
+	return os.FileMode(header.Mode) & v.options.modeMask()
+}
+
+// headerMode is the mode from the given header with the ModeMask applied (the type of the entry is always kept).
+func (v tarVisitor) headerMode(header tar.Header) os.FileMode {
+	return header.FileInfo().Mode() & (v.options.modeMask() | os.ModeType)
+}
+
+// applyFileMode sets the mode of an extracted regular file after it has been written. A forced mode is always set,
+// since the mode given when creating a file is subject to the umask and is not applied to an existing file.
+func (v tarVisitor) applyFileMode(header tar.Header, target string) error {
+	// changing the owner clears setuid and setgid bits, so ownership must be applied first
+	if err := v.applyOwnership(header, target); err != nil {
+		return err
+	}
+	if !v.options.ForceModes {
+		return v.applySpecialBits(header, target)
+	}
+	if err := v.options.sink(v.fs).Chmod(target, v.options.ForceFileMode); err != nil {
+		return fmt.Errorf("unable to set forced file mode: %w", err)
+	}
+	return nil
+}
+
+// applyOwnership sets the owner of an extracted entry from its header (translated through the ID maps) when
+// ownership is being preserved.
+func (v tarVisitor) applyOwnership(header tar.Header, target string) error {
+	if !v.options.PreserveOwnership {
+		return nil
+	}
+	uid, ok := mapID(v.options.UIDMap, header.Uid)
+	if !ok {
+		if !v.options.MapUnmappedIDs {
+			return &ErrUnmappedID{Name: header.Name, Kind: "uid", ID: header.Uid}
+		}
+		uid = v.options.OverflowUID
+	}
+	gid, ok := mapID(v.options.GIDMap, header.Gid)
+	if !ok {
+		if !v.options.MapUnmappedIDs {
+			return &ErrUnmappedID{Name: header.Name, Kind: "gid", ID: header.Gid}
+		}
+		gid = v.options.OverflowGID
+	}
+	if err := v.options.sink(v.fs).Chown(target, uid, gid); err != nil {
+		return fmt.Errorf("unable to set owner: %w", err)
+	}
+	return nil
+}
+
+// applySpecialBits explicitly sets the permission bits along with any setuid, setgid, and sticky bits from the
+// header, since these are not reliably applied by the mode given when creating a file.
+func (v tarVisitor) applySpecialBits(header tar.Header, target string) error {
+	if !v.options.PreserveSpecialBits || v.options.ForceModes {
+		return nil
+	}
+	mode := v.headerMode(header)
+	special := mode & (os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if special == 0 {
+		return nil
+	}
+	if err := v.options.sink(v.fs).Chmod(target, mode.Perm()|special); err != nil {
+		return fmt.Errorf("unable to set special mode bits: %w", err)
+	}
+	return nil
+}
+
+// checkCaseCollision records the given entry path (and all parent paths) by their lowercased form, returning an
+// ErrCaseCollision if a previously seen path differs from the current one only by case.
+func (v tarVisitor) checkCaseCollision(header tar.Header) error {
+	switch header.Typeflag {
+	case tar.TypeDir, tar.TypeReg:
+	default:
+		// only entries that result in something being written can collide
+		return nil
+	}
+
+	name := cleanTarEntryName(header.Name)
+	if name == "." || v.seenFolded == nil {
+		return nil
+	}
+
+	for p := name; p != "."; p = path.Dir(p) {
+		folded := strings.ToLower(p)
+		existing, ok := v.seenFolded[folded]
+		if !ok {
+			v.seenFolded[folded] = p
+			continue
+		}
+		if existing != p {
+			return &ErrCaseCollision{Path: p, Conflict: existing}
+		}
+	}
+	return nil
+}
+
+// cleanTarEntryName returns the given entry name relative to the root of the archive (e.g. "./a/b/" and "/a/b" both
+// become "a/b"), or "." for the root itself.
+func cleanTarEntryName(name string) string {
+	cleaned := strings.TrimPrefix(path.Clean(DirSeparator+name), DirSeparator)
+	if cleaned == "" {
+		return "."
+	}
+	return cleaned
+}
@@ -0,0 +1,154 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// isExpectedTarError reports whether the given error from reading an arbitrary archive is one that callers can act
+// on: either one of the typed errors of this package or one of the errors archive/tar reports for malformed input.
+func isExpectedTarError(err error) bool {
+	var (
+		traversal   *ErrPathTraversal
+		unsafeLink  *ErrUnsafeLinkTarget
+		sizes       *ErrSizeDisagreement
+		terminator  *ErrMissingTerminator
+		malformed   *ErrMalformedArchive
+		sparse      *ErrInvalidSparseMap
+		invalidName *ErrInvalidUTF8Name
+	)
+	switch {
+	case errors.As(err, &traversal), errors.As(err, &unsafeLink), errors.As(err, &sizes),
+		errors.As(err, &terminator), errors.As(err, &malformed), errors.As(err, &sparse),
+		errors.As(err, &invalidName):
+		return true
+	case errors.Is(err, tar.ErrHeader), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	return false
+}
+
+func fuzzSeedTars(f *testing.F) [][]byte {
+	seeds := []*bytes.Reader{
+		newTestTar(f,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "dir/"}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "dir/file"}, content: "content"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "dir/link", Linkname: "file"}},
+		),
+		newTestTar(f,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "../escape"}, content: "content"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeLink, Name: "hardlink", Linkname: "/etc/passwd"}},
+		),
+		newTestTar(f,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "pax", Format: tar.FormatPAX,
+				PAXRecords: map[string]string{"path": "pax-name"}}, content: "content"},
+		),
+	}
+	var tars [][]byte
+	for _, seed := range seeds {
+		data, err := io.ReadAll(seed)
+		if err != nil {
+			f.Fatal(err)
+		}
+		tars = append(tars, data)
+	}
+	return tars
+}
+
+func FuzzIterateTar(f *testing.F) {
+	for _, seed := range fuzzSeedTars(f) {
+		f.Add(seed, true)
+		f.Add(seed, false)
+	}
+	f.Add(oldGNUSparseTar([]sparseRegion{{offset: 0, length: 512}, {offset: 1024, length: 512}}, 2048), true)
+	f.Add(paxSparseTar("0,512,1024,512", 2, 2048, 0), true)
+	f.Add([]byte{}, true)
+	f.Add(make([]byte, 1024), false)
+
+	f.Fuzz(func(t *testing.T, data []byte, seekable bool) {
+		// seekable streams take different paths for reporting header errors and resuming after content errors
+		var reader io.Reader = bytes.NewReader(data)
+		if !seekable {
+			reader = &slowReader{reader: reader, chunk: 512}
+		}
+		visitor := func(entry TarFileEntry) error {
+			_, err := io.Copy(io.Discard, entry.Reader)
+			return err
+		}
+		err := IterateTar(reader, visitor,
+			WithPAXSizeValidation(true),
+			WithRequiredTerminator(true),
+			WithUnsafeLinkRejection(true),
+			WithEntryErrorHandler(func(TarFileEntry, error) {}),
+		)
+		if err != nil && !isExpectedTarError(err) {
+			t.Fatalf("unexpected error type %T: %v", errors.Unwrap(err), err)
+		}
+	})
+}
+
+func FuzzTarVisitor(f *testing.F) {
+	f.Add("dir/file", byte(tar.TypeReg), int64(0644), int64(7), "content")
+	f.Add("../../etc/passwd", byte(tar.TypeReg), int64(0644), int64(7), "content")
+	f.Add("/abs/../../file", byte(tar.TypeReg), int64(04755), int64(0), "")
+	f.Add("dir/../../", byte(tar.TypeDir), int64(0755), int64(0), "")
+	f.Add(".", byte(tar.TypeReg), int64(0644), int64(7), "content")
+	f.Add("link", byte(tar.TypeSymlink), int64(0777), int64(0), "")
+	f.Add("\xff\xfe", byte(tar.TypeReg), int64(0644), int64(7), "content")
+	f.Add("short", byte(tar.TypeReg), int64(0644), int64(-1), "content")
+
+	f.Fuzz(func(t *testing.T, name string, typeflag byte, mode, size int64, content string) {
+		fs := afero.NewMemMapFs()
+		const destination = "/dst"
+		if err := fs.MkdirAll(destination, 0755); err != nil {
+			t.Fatal(err)
+		}
+		// entries without content are given no reader at all, as custom visitors building their own entries may do
+		var reader io.Reader
+		if content != "" {
+			reader = strings.NewReader(content)
+		}
+		visitor := newTarVisitor(fs, destination, newUntarOptions())
+		err := visitor.visit(TarFileEntry{
+			Header: tar.Header{
+				Typeflag: typeflag,
+				Name:     name,
+				Mode:     mode,
+				Size:     size,
+			},
+			Reader: reader,
+		})
+		var (
+			traversal *ErrPathTraversal
+			conflict  *ErrTypeConflict
+		)
+		if err != nil && !errors.As(err, &traversal) && !errors.As(err, &conflict) {
+			t.Fatalf("unexpected error type %T: %v", errors.Unwrap(err), err)
+		}
+
+		walkErr := afero.Walk(fs, "/", func(p string, _ os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			clean := filepath.Clean(p)
+			if clean != "/" && clean != destination && !strings.HasPrefix(clean, destination+string(os.PathSeparator)) {
+				t.Errorf("entry %q was written outside of the destination: %q", name, p)
+			}
+			return nil
+		})
+		if walkErr != nil {
+			t.Fatal(walkErr)
+		}
+	})
+}
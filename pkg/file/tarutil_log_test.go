@@ -0,0 +1,150 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/anchore/go-logger"
+	"github.com/anchore/go-logger/adapter/discard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/stereoscope/internal/log"
+)
+
+// loggedEvent is a single message logged with fields.
+type loggedEvent struct {
+	level   logger.Level
+	message string
+	fields  map[string]interface{}
+}
+
+// recordingLogger records all debug and trace messages logged with fields.
+type recordingLogger struct {
+	logger.Logger
+	lock   sync.Mutex
+	events []loggedEvent
+}
+
+func (r *recordingLogger) WithFields(fields ...interface{}) logger.MessageLogger {
+	m := make(map[string]interface{})
+	for i := 0; i+1 < len(fields); i += 2 {
+		m[fmt.Sprint(fields[i])] = fields[i+1]
+	}
+	return &recordingMessageLogger{MessageLogger: r.Logger, recorder: r, fields: m}
+}
+
+func (r *recordingLogger) eventsAt(level logger.Level) []loggedEvent {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var events []loggedEvent
+	for _, e := range r.events {
+		if e.level == level {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+type recordingMessageLogger struct {
+	logger.MessageLogger
+	recorder *recordingLogger
+	fields   map[string]interface{}
+}
+
+func (m *recordingMessageLogger) record(level logger.Level, message string) {
+	m.recorder.lock.Lock()
+	defer m.recorder.lock.Unlock()
+	m.recorder.events = append(m.recorder.events, loggedEvent{level: level, message: message, fields: m.fields})
+}
+
+func (m *recordingMessageLogger) Debug(args ...interface{}) {
+	m.record(logger.DebugLevel, fmt.Sprint(args...))
+}
+
+func (m *recordingMessageLogger) Debugf(format string, args ...interface{}) {
+	m.record(logger.DebugLevel, fmt.Sprintf(format, args...))
+}
+
+func (m *recordingMessageLogger) Trace(args ...interface{}) {
+	m.record(logger.TraceLevel, fmt.Sprint(args...))
+}
+
+func (m *recordingMessageLogger) Tracef(format string, args ...interface{}) {
+	m.record(logger.TraceLevel, fmt.Sprintf(format, args...))
+}
+
+func recordLogs(t *testing.T) *recordingLogger {
+	recorder := &recordingLogger{Logger: discard.New()}
+	original := log.Log
+	log.Log = recorder
+	t.Cleanup(func() {
+		log.Log = original
+	})
+	return recorder
+}
+
+func TestUntarToDirectory_Logging(t *testing.T) {
+	recorder := recordLogs(t)
+
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "small"}, content: "1"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeFifo, Name: "fifo"}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "small"}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "large"}, content: "12345"},
+	)
+	require.NoError(t, UntarToDirectory(reader, t.TempDir(), WithExtractBudget(2, false)))
+
+	debug := recorder.eventsAt(logger.DebugLevel)
+	require.Len(t, debug, 3)
+	assert.Equal(t, "iterating tar", debug[0].message)
+	assert.Equal(t, "tar entry hit the extract budget", debug[1].message)
+	assert.Equal(t, map[string]interface{}{"path": "large", "sequence": int64(3), "size": int64(5)}, debug[1].fields)
+	assert.Equal(t, "finished iterating tar", debug[2].message)
+	assert.Equal(t, int64(4), debug[2].fields["sequence"])
+	assert.Equal(t, int64(6), debug[2].fields["size"])
+
+	// entries that are skipped are only logged at trace level, since there may be many
+	var skipped []string
+	for _, e := range recorder.eventsAt(logger.TraceLevel) {
+		assert.Contains(t, e.fields, "sequence")
+		assert.Contains(t, e.fields, "size")
+		skipped = append(skipped, fmt.Sprint(e.fields["path"]))
+	}
+	assert.Equal(t, []string{"fifo", "link"}, skipped)
+}
+
+func TestUntarToDirectory_NameSanitizerLogging(t *testing.T) {
+	recorder := recordLogs(t)
+
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "a:b/"}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "a:b/what?.txt"}, content: "content"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "a:b/portable.txt"}, content: "content"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "portable.txt"}, content: "content"},
+	)
+	dst := t.TempDir()
+	require.NoError(t, UntarToDirectory(reader, dst, WithNameSanitizer(DefaultNameSanitizer)))
+
+	for _, name := range []string{"a_b/what_.txt", "a_b/portable.txt", "portable.txt"} {
+		assert.FileExists(t, filepath.Join(dst, name))
+	}
+
+	var remapped []map[string]interface{}
+	for _, e := range recorder.eventsAt(logger.DebugLevel) {
+		if e.message == "remapped entry name" {
+			remapped = append(remapped, e.fields)
+		}
+	}
+	assert.Equal(t, []map[string]interface{}{
+		{"name": "a:b/", "sanitized": "a_b/"},
+		{"name": "a:b/what?.txt", "sanitized": "a_b/what_.txt"},
+		{"name": "a:b/portable.txt", "sanitized": "a_b/portable.txt"},
+	}, remapped)
+}
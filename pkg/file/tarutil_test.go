@@ -5,15 +5,22 @@ package file
 
 import (
 	"archive/tar"
+	"bytes"
+	"crypto/md5"  //nolint:gosec // matching legacy digests, not for security
+	"crypto/sha1" //nolint:gosec // matching legacy digests, not for security
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -51,6 +58,54 @@ func TestReaderFromTar_GoCase(t *testing.T) {
 	}
 }
 
+func TestReaderAndHeaderFromTar(t *testing.T) {
+	newReader := func() io.ReadCloser {
+		return io.NopCloser(newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts", Mode: 0600}, content: "127.0.0.1 localhost"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/passwd"}, content: "root:x:0:0"},
+		))
+	}
+
+	rc, header, err := ReaderAndHeaderFromTar(newReader(), "etc/hosts")
+	require.NoError(t, err)
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1 localhost", string(content))
+	assert.Equal(t, "etc/hosts", header.Name)
+	assert.Equal(t, int64(len(content)), header.Size)
+	assert.Equal(t, int64(0600), header.Mode)
+
+	_, header, err = ReaderAndHeaderFromTar(newReader(), "etc/shadow")
+	var notFound *ErrFileNotFound
+	require.ErrorAs(t, err, &notFound)
+	assert.Equal(t, tar.Header{}, header)
+}
+
+func BenchmarkReaderFromTar(b *testing.B) {
+	const entries = 10000
+	testEntries := make([]testTarEntry, entries)
+	for i := range testEntries {
+		testEntries[i] = testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: fmt.Sprintf("dir/file-%d.txt", i)}, content: "x"}
+	}
+	archive := newTestTar(b, testEntries...)
+	// the last entry is requested so that every entry of the archive is compared
+	tarPath := fmt.Sprintf("dir/file-%d.txt", entries-1)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := archive.Seek(0, io.SeekStart); err != nil {
+			b.Fatal(err)
+		}
+		rc, err := ReaderFromTar(io.NopCloser(archive), tarPath)
+		if err != nil {
+			b.Fatal(err)
+		}
+		rc.Close()
+	}
+}
+
 func TestReaderFromTar_MissingFile(t *testing.T) {
 	tarReader := getTarFixture(t, "fixture-1")
 
@@ -430,3 +485,2131 @@ func Test_tarVisitor_visit(t *testing.T) {
 		})
 	}
 }
+
+type testTarEntry struct {
+	header  tar.Header
+	content string
+}
+
+// newTestTar creates an in-memory tar from the given entries, setting the size of each regular file from its content.
+func newTestTar(t testing.TB, entries ...testTarEntry) *bytes.Reader {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := e.header
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.content))
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		require.NoError(t, tw.WriteHeader(&hdr))
+		if e.content != "" {
+			_, err := tw.Write([]byte(e.content))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestUntarToDirectory_DetectCaseCollisions(t *testing.T) {
+	entries := []testTarEntry{
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "Foo"}, content: "upper"},
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "foo"}, content: "lower"},
+	}
+
+	tests := []struct {
+		name    string
+		options []Option
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "collisions are ignored by default",
+			wantErr: require.NoError,
+		},
+		{
+			name: "collision detected when enabled",
+			options: []Option{
+				func(o *UntarOptions) { o.DetectCaseCollisions = true },
+			},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var collision *ErrCaseCollision
+				require.ErrorAs(t, err, &collision)
+				assert.Equal(t, "foo", collision.Path)
+				assert.Equal(t, "Foo", collision.Conflict)
+			},
+		},
+		{
+			name:    "collision detected with option",
+			options: []Option{WithCaseCollisionDetection(false)},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var collision *ErrCaseCollision
+				require.ErrorAs(t, err, &collision)
+			},
+		},
+		{
+			name:    "collision only logged",
+			options: []Option{WithCaseCollisionDetection(true)},
+			wantErr: require.NoError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := UntarToDirectory(newTestTar(t, entries...), t.TempDir(), tt.options...)
+			tt.wantErr(t, err)
+		})
+	}
+}
+
+func Test_tarVisitor_checkCaseCollision_parents(t *testing.T) {
+	v := tarVisitor{seenFolded: make(map[string]string)}
+	require.NoError(t, v.checkCaseCollision(tar.Header{Typeflag: tar.TypeReg, Name: "Dir/a.txt"}))
+	require.NoError(t, v.checkCaseCollision(tar.Header{Typeflag: tar.TypeReg, Name: "Dir/b.txt"}))
+
+	var collision *ErrCaseCollision
+	require.ErrorAs(t, v.checkCaseCollision(tar.Header{Typeflag: tar.TypeReg, Name: "dir/c.txt"}), &collision)
+	assert.Equal(t, "dir", collision.Path)
+	assert.Equal(t, "Dir", collision.Conflict)
+}
+
+func TestIterateTarSection(t *testing.T) {
+	embedded := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "a.txt"}, content: "a"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "b.txt"}, content: "b"},
+	)
+	tarBytes, err := io.ReadAll(embedded)
+	require.NoError(t, err)
+
+	prefix := bytes.Repeat([]byte("x"), 100)
+	composite := append(append(append([]byte{}, prefix...), tarBytes...), []byte("trailing garbage")...)
+
+	var names []string
+	err = IterateTarSection(bytes.NewReader(composite), int64(len(prefix)), int64(len(tarBytes)), func(entry TarFileEntry) error {
+		names = append(names, entry.Header.Name)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "b.txt"}, names)
+}
+
+func TestUntarToDirectory_NoOverwrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "overwrites by default",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "refuses to overwrite when enabled",
+			options: []Option{WithNoOverwrite(true)},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var overwrite *ErrWouldOverwrite
+				require.ErrorAs(t, err, &overwrite)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			first := newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file.txt"}, content: "trusted"})
+			require.NoError(t, UntarToDirectory(first, dst, tt.options...))
+
+			second := newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file.txt"}, content: "evil"})
+			tt.wantErr(t, UntarToDirectory(second, dst, tt.options...))
+		})
+	}
+}
+
+func TestUntarToDirectory_TypeConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing is created at "node" within the destination before extraction
+		existing func(t *testing.T, p string)
+		entry    testTarEntry
+		options  []Option
+		wantErr  require.ErrorAssertionFunc
+		assert   func(t *testing.T, p string)
+	}{
+		{
+			name:     "file over directory fails by default",
+			existing: mkdirWithChild,
+			entry:    testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "node"}, content: "file"},
+			wantErr:  requireTypeConflict(TypeDirectory, TypeRegular),
+			assert:   assertDirWithChild,
+		},
+		{
+			name:     "directory over file fails by default",
+			existing: writeExistingFile,
+			entry:    testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "node/"}},
+			wantErr:  requireTypeConflict(TypeRegular, TypeDirectory),
+			assert:   assertFile("existing"),
+		},
+		{
+			name:     "file over directory replaces recursively",
+			existing: mkdirWithChild,
+			entry:    testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "node"}, content: "file"},
+			options:  []Option{WithTypeConflictPolicy(TypeConflictReplace)},
+			wantErr:  require.NoError,
+			assert:   assertFile("file"),
+		},
+		{
+			name:     "directory over file replaces",
+			existing: writeExistingFile,
+			entry:    testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "node/"}},
+			options:  []Option{WithTypeConflictPolicy(TypeConflictReplace)},
+			wantErr:  require.NoError,
+			assert: func(t *testing.T, p string) {
+				info, err := os.Stat(p)
+				require.NoError(t, err)
+				assert.True(t, info.IsDir())
+			},
+		},
+		{
+			name:     "file over directory is skipped",
+			existing: mkdirWithChild,
+			entry:    testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "node"}, content: "file"},
+			options:  []Option{WithTypeConflictPolicy(TypeConflictSkip)},
+			wantErr:  require.NoError,
+			assert:   assertDirWithChild,
+		},
+		{
+			name:     "directory over file is skipped",
+			existing: writeExistingFile,
+			entry:    testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "node/"}},
+			options:  []Option{WithTypeConflictPolicy(TypeConflictSkip)},
+			wantErr:  require.NoError,
+			assert:   assertFile("existing"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			p := filepath.Join(dst, "node")
+			tt.existing(t, p)
+
+			tt.wantErr(t, UntarToDirectory(newTestTar(t, tt.entry), dst, tt.options...))
+			tt.assert(t, p)
+		})
+	}
+}
+
+func TestUntarToDirectory_TypeConflictNeverReplacesDestination(t *testing.T) {
+	dst := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "keep"), []byte("keep"), 0644))
+
+	reader := newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "."}, content: "file"})
+	err := UntarToDirectory(reader, dst, WithTypeConflictPolicy(TypeConflictReplace))
+
+	var conflict *ErrTypeConflict
+	require.ErrorAs(t, err, &conflict)
+	assert.FileExists(t, filepath.Join(dst, "keep"))
+}
+
+func mkdirWithChild(t *testing.T, p string) {
+	require.NoError(t, os.MkdirAll(filepath.Join(p, "child"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(p, "child", "file"), []byte("child"), 0644))
+}
+
+func writeExistingFile(t *testing.T, p string) {
+	require.NoError(t, os.WriteFile(p, []byte("existing"), 0644))
+}
+
+func assertDirWithChild(t *testing.T, p string) {
+	assert.FileExists(t, filepath.Join(p, "child", "file"))
+}
+
+func assertFile(content string) func(t *testing.T, p string) {
+	return func(t *testing.T, p string) {
+		got, err := os.ReadFile(p)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(got))
+	}
+}
+
+func requireTypeConflict(existing, entry Type) require.ErrorAssertionFunc {
+	return func(t require.TestingT, err error, _ ...interface{}) {
+		var conflict *ErrTypeConflict
+		require.ErrorAs(t, err, &conflict)
+		assert.Equal(t, existing, conflict.Existing)
+		assert.Equal(t, entry, conflict.Entry)
+	}
+}
+
+func TestUntarToDirectory_PreserveSpecialBits(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []Option
+		wantMode os.FileMode
+	}{
+		{
+			name:     "special bits are dropped by default",
+			wantMode: 0,
+		},
+		{
+			name:     "setuid bit preserved when enabled",
+			options:  []Option{WithPreserveSpecialBits(true)},
+			wantMode: os.ModeSetuid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			reader := newTestTar(t, testTarEntry{
+				header:  tar.Header{Typeflag: tar.TypeReg, Name: "bin/su", Mode: 04755},
+				content: "binary",
+			})
+			require.NoError(t, os.MkdirAll(filepath.Join(dst, "bin"), 0755))
+			require.NoError(t, UntarToDirectory(reader, dst, tt.options...))
+
+			info, err := os.Stat(filepath.Join(dst, "bin", "su"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMode, info.Mode()&os.ModeSetuid)
+			if tt.wantMode != 0 {
+				assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestIterateTar_ErrorPosition(t *testing.T) {
+	entries := []testTarEntry{
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "first.txt"}, content: "1"},
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "second.txt"}, content: "2"},
+	}
+
+	t.Run("visitor error names sequence and header offset", func(t *testing.T) {
+		err := IterateTar(newTestTar(t, entries...), func(entry TarFileEntry) error {
+			if entry.Header.Name == "second.txt" {
+				return fmt.Errorf("bad entry")
+			}
+			return nil
+		})
+		require.Error(t, err)
+		// one header block + one padded content block precedes the second header
+		assert.Contains(t, err.Error(), "(sequence=1 offset=1024)")
+	})
+
+	t.Run("corrupt header names sequence and header offset", func(t *testing.T) {
+		raw, err := io.ReadAll(newTestTar(t, entries...))
+		require.NoError(t, err)
+		// corrupt the checksum of the second header
+		copy(raw[1024+148:], "garbage!")
+
+		err = IterateTar(bytes.NewReader(raw), func(TarFileEntry) error { return nil })
+		require.ErrorIs(t, err, tar.ErrHeader)
+		assert.Contains(t, err.Error(), "(sequence=1 offset=1024)")
+	})
+
+	t.Run("offset omitted for non-seekable readers", func(t *testing.T) {
+		err := IterateTar(io.MultiReader(newTestTar(t, entries...)), func(TarFileEntry) error {
+			return fmt.Errorf("bad entry")
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "(sequence=0)")
+	})
+}
+
+func TestIterateTar_WarningHandler(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "normal.txt"}, content: "ok"},
+		testTarEntry{header: tar.Header{Typeflag: 'Z', Name: "unusual"}},
+	)
+
+	var sequences []int64
+	var warnings []error
+	err := IterateTar(reader, func(TarFileEntry) error { return nil }, WithWarningHandler(func(sequence int64, warning error) {
+		sequences = append(sequences, sequence)
+		warnings = append(warnings, warning)
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1}, sequences)
+	require.Len(t, warnings, 1)
+	assert.ErrorContains(t, warnings[0], "unknown typeflag")
+}
+
+func TestIterateTar_ContentTypeDetection(t *testing.T) {
+	html := "<html><body>hello</body></html>"
+	large := strings.Repeat("plain text ", 100)
+
+	tests := []struct {
+		name     string
+		options  []Option
+		wantType map[string]string
+	}{
+		{
+			name:     "not detected by default",
+			wantType: map[string]string{"index.html": "", "large.txt": "", "empty": ""},
+		},
+		{
+			name:    "detected when enabled",
+			options: []Option{WithContentTypeDetection(true)},
+			wantType: map[string]string{
+				"index.html": "text/html; charset=utf-8",
+				"large.txt":  "text/plain; charset=utf-8",
+				"empty":      "text/plain; charset=utf-8",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newTestTar(t,
+				testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "index.html"}, content: html},
+				testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "large.txt"}, content: large},
+				testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "empty"}},
+			)
+			wantContent := map[string]string{"index.html": html, "large.txt": large, "empty": ""}
+
+			err := IterateTar(reader, func(entry TarFileEntry) error {
+				assert.Equal(t, tt.wantType[entry.Header.Name], entry.DetectedContentType)
+				content, err := io.ReadAll(entry.Reader)
+				require.NoError(t, err)
+				assert.Equal(t, wantContent[entry.Header.Name], string(content))
+				return nil
+			}, tt.options...)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestUntarToDirectory_UTF8Names(t *testing.T) {
+	// "café.txt" encoded as Latin-1
+	latin1Name := "caf\xe9.txt"
+
+	tests := []struct {
+		name     string
+		options  []Option
+		wantErr  require.ErrorAssertionFunc
+		wantFile string
+	}{
+		{
+			name:     "invalid names are extracted as-is by default",
+			wantFile: latin1Name,
+		},
+		{
+			name:    "invalid names are rejected when required",
+			options: []Option{WithRequireUTF8Names(true)},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var invalid *ErrInvalidUTF8Name
+				require.ErrorAs(t, err, &invalid)
+				assert.Equal(t, latin1Name, invalid.Name)
+			},
+		},
+		{
+			name: "invalid names are sanitized",
+			options: []Option{
+				WithRequireUTF8Names(true),
+				WithNonUTF8NameSanitizer(func(name string) string {
+					return strings.ToValidUTF8(name, "_")
+				}),
+			},
+			wantFile: "caf_.txt",
+		},
+		{
+			name: "invalid names are repaired by the name sanitizer before being rejected",
+			options: []Option{
+				WithRequireUTF8Names(true),
+				WithNameSanitizer(func(name string) string {
+					return strings.ToValidUTF8(name, "-")
+				}),
+			},
+			wantFile: "caf-.txt",
+		},
+		{
+			name: "non-UTF-8 sanitizing happens before name sanitizing",
+			options: []Option{
+				WithNonUTF8NameSanitizer(func(name string) string {
+					return strings.ToValidUTF8(name, "_")
+				}),
+				WithNameSanitizer(strings.ToUpper),
+			},
+			wantFile: "CAF_.TXT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			dst := t.TempDir()
+			reader := newTestTar(t, testTarEntry{
+				header:  tar.Header{Typeflag: tar.TypeReg, Name: latin1Name, Format: tar.FormatGNU},
+				content: "content",
+			})
+
+			err := UntarToDirectory(reader, dst, tt.options...)
+			tt.wantErr(t, err)
+			if tt.wantFile != "" {
+				assert.FileExists(t, filepath.Join(dst, tt.wantFile))
+			}
+		})
+	}
+}
+
+// fakeMetrics records the counters reported to it.
+type fakeMetrics struct {
+	lock     sync.Mutex
+	counters map[string]int64
+}
+
+func (f *fakeMetrics) Inc(name string) {
+	f.Add(name, 1)
+}
+
+func (f *fakeMetrics) Add(name string, v int64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.counters == nil {
+		f.counters = make(map[string]int64)
+	}
+	f.counters[name] += v
+}
+
+func TestUntarToDirectory_Metrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testTarEntry
+		options []Option
+		wantErr require.ErrorAssertionFunc
+		want    map[string]int64
+	}{
+		{
+			name: "entries and bytes",
+			entries: []testTarEntry{
+				{header: tar.Header{Typeflag: tar.TypeDir, Name: "a/"}},
+				{header: tar.Header{Typeflag: tar.TypeReg, Name: "a/one.txt"}, content: "one"},
+				{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "a/link", Linkname: "one.txt"}},
+				{header: tar.Header{Typeflag: tar.TypeReg, Name: "two.txt"}, content: "two!"},
+			},
+			wantErr: require.NoError,
+			want: map[string]int64{
+				MetricEntriesProcessed: 4,
+				MetricEntriesSkipped:   1,
+				MetricBytesWritten:     7,
+			},
+		},
+		{
+			name: "traversal",
+			entries: []testTarEntry{
+				{header: tar.Header{Typeflag: tar.TypeReg, Name: "one.txt"}, content: "one"},
+				{header: tar.Header{Typeflag: tar.TypeReg, Name: "../escape.txt"}, content: "escape"},
+			},
+			wantErr: require.Error,
+			want: map[string]int64{
+				MetricEntriesProcessed:  2,
+				MetricBytesWritten:      3,
+				MetricTraversalsBlocked: 1,
+			},
+		},
+		{
+			name: "unsafe link target",
+			entries: []testTarEntry{
+				{header: tar.Header{Typeflag: tar.TypeLink, Name: "shadow", Linkname: "/etc/shadow"}},
+			},
+			options: []Option{WithUnsafeLinkRejection(true)},
+			wantErr: require.Error,
+			want: map[string]int64{
+				MetricTraversalsBlocked: 1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := &fakeMetrics{}
+			err := UntarToDirectory(newTestTar(t, tt.entries...), t.TempDir(), append(tt.options, WithMetrics(metrics))...)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, metrics.counters)
+		})
+	}
+}
+
+func TestUntarToDirectory_MaxOpenFiles(t *testing.T) {
+	option := WithMaxOpenFiles(1)
+
+	// the same option value shares the bound across concurrent extractions
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		reader := newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "a.txt"}, content: "a"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "b.txt"}, content: "b"},
+		)
+		dst := t.TempDir()
+		go func() {
+			errs <- UntarToDirectory(reader, dst, option)
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		require.NoError(t, <-errs)
+	}
+}
+
+// openCountingFs tracks the number of files open at once, along with the most ever open simultaneously.
+type openCountingFs struct {
+	afero.Fs
+	lock    sync.Mutex
+	open    int
+	maxOpen int
+}
+
+func (f *openCountingFs) Open(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (f *openCountingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	file, err := f.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.open++
+	f.maxOpen = max(f.maxOpen, f.open)
+	return &openCountingFile{File: file, fs: f}, nil
+}
+
+type openCountingFile struct {
+	afero.File
+	fs *openCountingFs
+}
+
+func (f *openCountingFile) Close() error {
+	// holding files open for a while makes overlapping opens across extractions likely
+	time.Sleep(time.Millisecond)
+	f.fs.lock.Lock()
+	f.fs.open--
+	f.fs.lock.Unlock()
+	return f.File.Close()
+}
+
+func TestUntarToDirectory_MaxOpenFilesBound(t *testing.T) {
+	const maxOpen = 2
+	option := WithMaxOpenFiles(maxOpen)
+	fs := &openCountingFs{Fs: afero.NewMemMapFs()}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		entries := []testTarEntry{{header: tar.Header{Typeflag: tar.TypeDir, Name: "dir/"}}}
+		for j := 0; j < 10; j++ {
+			name := fmt.Sprintf("dir/%d.txt", j)
+			entries = append(entries,
+				testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name}, content: "content"},
+				// copying a hardlink opens both the source and the copy
+				testTarEntry{header: tar.Header{Typeflag: tar.TypeLink, Name: name + ".link", Linkname: name}},
+			)
+		}
+		reader := newTestTar(t, entries...)
+
+		extractor := NewExtractor(option, WithHardlinkStrategy(HardlinkCopy))
+		extractor.fs = fs
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- extractor.Untar(reader, fmt.Sprintf("/dst-%d", i))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 0, fs.open, "all files should be closed")
+	assert.LessOrEqual(t, fs.maxOpen, maxOpen)
+	content, err := afero.ReadFile(fs.Fs, "/dst-0/dir/0.txt.link")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
+
+func TestAllMetadataFromTar(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts", Uid: 1337}, content: "127.0.0.1"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/link", Linkname: "hosts"}},
+	)
+
+	metadata, err := AllMetadataFromTar(reader)
+	require.NoError(t, err)
+	require.Len(t, metadata, 3)
+
+	assert.Equal(t, "/etc", metadata[0].Path)
+	assert.Equal(t, TypeDirectory, metadata[0].Type)
+
+	assert.Equal(t, "/etc/hosts", metadata[1].Path)
+	assert.Equal(t, TypeRegular, metadata[1].Type)
+	assert.Equal(t, 1337, metadata[1].UserID)
+	assert.Equal(t, int64(9), metadata[1].Size())
+	assert.Empty(t, metadata[1].MIMEType)
+
+	assert.Equal(t, "/etc/link", metadata[2].Path)
+	assert.Equal(t, "hosts", metadata[2].LinkDestination)
+}
+
+func TestHeadersFromTar(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts", Uid: 1337}, content: "127.0.0.1"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/link", Linkname: "hosts"}},
+	)
+
+	headers, err := HeadersFromTar(reader)
+	require.NoError(t, err)
+	require.Len(t, headers, 3)
+
+	assert.Equal(t, "etc/", headers[0].Name)
+	assert.Equal(t, "etc/hosts", headers[1].Name)
+	assert.Equal(t, 1337, headers[1].Uid)
+	assert.Equal(t, int64(9), headers[1].Size)
+	assert.Equal(t, "hosts", headers[2].Linkname)
+}
+
+func TestIterateTar_UnreadContent(t *testing.T) {
+	var entries []testTarEntry
+	var want []string
+	// sizes around the block size, with PAX headers of varying length in between
+	for i, size := range []int{0, 1, 511, 512, 513, 4096, 70000} {
+		header := tar.Header{Typeflag: tar.TypeReg, Name: fmt.Sprintf("file-%d", i)}
+		if i%2 == 0 {
+			header.Format = tar.FormatPAX
+			header.PAXRecords = map[string]string{"comment": strings.Repeat("c", i*100)}
+		}
+		entries = append(entries, testTarEntry{header: header, content: strings.Repeat("x", size)})
+		want = append(want, header.Name)
+	}
+	archive, err := io.ReadAll(newTestTar(t, entries...))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{name: "no options"},
+		{name: "PAX size validation", options: []Option{WithPAXSizeValidation(true)}},
+		{name: "required terminator", options: []Option{WithRequiredTerminator(true)}},
+		{name: "content type detection", options: []Option{WithContentTypeDetection(true)}},
+		{name: "entry consumption handler", options: []Option{WithEntryConsumptionHandler(func(TarEntryConsumption) {})}},
+		{name: "entry error handler", options: []Option{WithEntryErrorHandler(func(TarFileEntry, error) {})}},
+		{name: "deadline", options: []Option{WithDeadline(time.Now().Add(time.Hour))}},
+	}
+	for _, tt := range tests {
+		for _, streaming := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s streaming=%t", tt.name, streaming), func(t *testing.T) {
+				var reader io.Reader = bytes.NewReader(archive)
+				if streaming {
+					reader = &slowReader{reader: reader, chunk: 100}
+				}
+				var got []string
+				visitor := func(entry TarFileEntry) error {
+					got = append(got, entry.Header.Name)
+					return nil
+				}
+				require.NoError(t, IterateTar(reader, visitor, tt.options...))
+				assert.Equal(t, want, got)
+			})
+		}
+	}
+}
+
+func BenchmarkHeadersFromTar(b *testing.B) {
+	const entries = 1000
+	testEntries := make([]testTarEntry, entries)
+	content := strings.Repeat("x", 64*KB)
+	for i := range testEntries {
+		testEntries[i] = testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: fmt.Sprintf("dir/file-%d.bin", i)}, content: content}
+	}
+	archive, err := io.ReadAll(newTestTar(b, testEntries...))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, streaming := range []bool{false, true} {
+		b.Run(fmt.Sprintf("streaming=%t", streaming), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				// when streaming, unread content must still be read (and discarded) to reach the next header
+				var reader io.Reader = bytes.NewReader(archive)
+				if streaming {
+					reader = &slowReader{reader: reader, chunk: 32 * KB}
+				}
+				headers, err := HeadersFromTar(reader)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(headers) != entries {
+					b.Fatalf("unexpected number of headers: %d", len(headers))
+				}
+			}
+		})
+	}
+}
+
+func TestTarFormats(t *testing.T) {
+	reg := func(name string, format tar.Format) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name, Format: format}, content: name}
+	}
+	reader := newTestTar(t,
+		reg("ustar", tar.FormatUSTAR),
+		// the reader only reports PAX for entries with PAX records, which are written where ustar is insufficient
+		reg("pax-ünicode", tar.FormatPAX),
+		testTarEntry{header: tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       "pax-xattrs",
+			Format:     tar.FormatPAX,
+			PAXRecords: map[string]string{"SCHILY.xattr.user.key": "value"},
+		}, content: "content"},
+		reg("gnu", tar.FormatGNU),
+		reg(strings.Repeat("long-", 60)+"pax", tar.FormatPAX),
+	)
+
+	formats, err := TarFormats(reader)
+	require.NoError(t, err)
+	assert.Equal(t, map[tar.Format]int{
+		tar.FormatUSTAR: 1,
+		tar.FormatPAX:   3,
+		tar.FormatGNU:   1,
+	}, formats)
+}
+
+func TestTopLevelEntries(t *testing.T) {
+	dir := func(name string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755}}
+	}
+	reg := func(name string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name}, content: name}
+	}
+	reader := newTestTar(t,
+		dir("./"),
+		dir("./usr/"),
+		dir("./usr/bin/"),
+		reg("./usr/bin/env"),
+		dir("usr/lib/"),
+		reg("usr/lib/libc.so"),
+		dir("etc/"),
+		reg("etc/hosts"),
+		reg("/etc/passwd"),
+		dir("etc/ssl/"),
+		reg("etc/ssl/cert.pem"),
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "bin", Linkname: "usr/bin"}},
+	)
+
+	entries, err := TopLevelEntries(reader)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bin", "etc", "usr"}, entries)
+}
+
+func TestBytesFromTar(t *testing.T) {
+	reg := func(name, content string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name}, content: content}
+	}
+	newReader := func() io.ReadCloser {
+		return io.NopCloser(newTestTar(t,
+			reg("etc/small.conf", "key=value"),
+			reg("etc/exact.conf", "0123456789"),
+			reg("etc/large.conf", "0123456789a"),
+			reg("etc/empty.conf", ""),
+		))
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "small file",
+			path:    "etc/small.conf",
+			want:    "key=value",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "file at the cap",
+			path:    "etc/exact.conf",
+			want:    "0123456789",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "empty file",
+			path:    "etc/empty.conf",
+			wantErr: require.NoError,
+		},
+		{
+			name: "file over the cap",
+			path: "etc/large.conf",
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var target *ErrFileTooLarge
+				require.ErrorAs(t, err, &target)
+				assert.Equal(t, &ErrFileTooLarge{Path: "etc/large.conf", Size: 11, Limit: 10}, target)
+			},
+		},
+		{
+			name: "missing file",
+			path: "etc/missing.conf",
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var target *ErrFileNotFound
+				require.ErrorAs(t, err, &target)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BytesFromTar(newReader(), tt.path, 10)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+// slowReader delays every read, returning at most chunk bytes at a time.
+type slowReader struct {
+	reader io.Reader
+	chunk  int
+	delay  time.Duration
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	if len(p) > s.chunk {
+		p = p[:s.chunk]
+	}
+	return s.reader.Read(p)
+}
+
+func TestIterateTar_Deadline(t *testing.T) {
+	var entries []testTarEntry
+	for i := 0; i < 100; i++ {
+		entries = append(entries, testTarEntry{
+			header:  tar.Header{Typeflag: tar.TypeReg, Name: fmt.Sprintf("file-%d.txt", i)},
+			content: strings.Repeat("x", 1024),
+		})
+	}
+	reader := &slowReader{reader: newTestTar(t, entries...), chunk: 512, delay: 5 * time.Millisecond}
+
+	start := time.Now()
+	err := UntarToDirectory(reader, t.TempDir(), func(o *UntarOptions) {
+		o.Deadline = time.Now().Add(50 * time.Millisecond)
+	})
+
+	var deadlineErr *ErrDeadlineExceeded
+	require.ErrorAs(t, err, &deadlineErr)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestUntarToDirectory_DirectoryModes(t *testing.T) {
+	newReader := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0700}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "locked/", Mode: 0555}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "locked/file.txt"}, content: "still written"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0750}},
+		)
+	}
+
+	modeOf := func(t *testing.T, p string) os.FileMode {
+		info, err := os.Stat(p)
+		require.NoError(t, err)
+		return info.Mode().Perm()
+	}
+
+	t.Run("last mode wins", func(t *testing.T) {
+		dst := t.TempDir()
+		require.NoError(t, UntarToDirectory(newReader(), dst, WithDirectoryModes(true)))
+		t.Cleanup(func() {
+			// allow the temp dir to be removed
+			_ = os.Chmod(filepath.Join(dst, "locked"), 0755)
+		})
+
+		assert.Equal(t, os.FileMode(0750), modeOf(t, filepath.Join(dst, "dir")))
+		assert.Equal(t, os.FileMode(0555), modeOf(t, filepath.Join(dst, "locked")))
+		assert.FileExists(t, filepath.Join(dst, "locked", "file.txt"))
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		dst := t.TempDir()
+		require.NoError(t, UntarToDirectory(newReader(), dst))
+		assert.Equal(t, os.FileMode(0755), modeOf(t, filepath.Join(dst, "dir")))
+	})
+}
+
+func TestUntarToDirectory_DirectoryTimes(t *testing.T) {
+	older := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	newer := time.Date(2011, 2, 3, 4, 5, 6, 0, time.UTC)
+	newReader := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755, ModTime: newer}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/ssl/", Mode: 0755, ModTime: older}},
+			// extracting contents updates the modification time of the directories these are within
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/ssl/cert.pem"}, content: "cert"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts"}, content: "localhost"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755, ModTime: older}},
+		)
+	}
+
+	modTimeOf := func(t *testing.T, p string) time.Time {
+		info, err := os.Stat(p)
+		require.NoError(t, err)
+		return info.ModTime().UTC()
+	}
+
+	t.Run("directory times survive extraction", func(t *testing.T) {
+		dst := t.TempDir()
+		require.NoError(t, UntarToDirectory(newReader(), dst, WithDirectoryTimes(true)))
+
+		assert.Equal(t, older, modTimeOf(t, filepath.Join(dst, "etc", "ssl")))
+		// the last entry wins
+		assert.Equal(t, older, modTimeOf(t, filepath.Join(dst, "etc")))
+	})
+
+	t.Run("applied along with directory modes", func(t *testing.T) {
+		dst := t.TempDir()
+		reader := newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "locked/", Mode: 0555, ModTime: older}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "locked/file.txt"}, content: "still written"},
+		)
+		require.NoError(t, UntarToDirectory(reader, dst, WithDirectoryModes(true), WithDirectoryTimes(true)))
+		t.Cleanup(func() {
+			// allow the temp dir to be removed
+			_ = os.Chmod(filepath.Join(dst, "locked"), 0755)
+		})
+		assert.Equal(t, older, modTimeOf(t, filepath.Join(dst, "locked")))
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		dst := t.TempDir()
+		require.NoError(t, UntarToDirectory(newReader(), dst))
+		assert.NotEqual(t, older, modTimeOf(t, filepath.Join(dst, "etc")))
+	})
+}
+
+func TestUntarToDirectory_ForceMode(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "bin/", Mode: 0777}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "bin/tool", Mode: 04777}, content: "#!/bin/sh\n"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "private/", Mode: 0700}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "private/key", Mode: 0600}, content: "secret"},
+	)
+
+	dst := t.TempDir()
+	require.NoError(t, UntarToDirectory(reader, dst, WithPreserveSpecialBits(true), WithForceMode(0644, 0755)))
+
+	for p, expected := range map[string]os.FileMode{
+		"bin":         os.ModeDir | 0755,
+		"bin/tool":    0644,
+		"private":     os.ModeDir | 0755,
+		"private/key": 0644,
+	} {
+		info, err := os.Stat(filepath.Join(dst, p))
+		require.NoError(t, err)
+		assert.Equal(t, expected, info.Mode(), "mode of %q", p)
+	}
+}
+
+func TestUntarToDirectory_ModeMask(t *testing.T) {
+	newReader := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "bin/", Mode: 0777}},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "bin/tool", Mode: 0777}, content: "#!/bin/sh\n"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "bin/setuid", Mode: 04777}, content: "#!/bin/sh\n"},
+		)
+	}
+
+	tests := []struct {
+		name string
+		mask os.FileMode
+		want map[string]os.FileMode
+	}{
+		{
+			name: "drops group and other write",
+			mask: 0755,
+			want: map[string]os.FileMode{
+				"bin":        os.ModeDir | 0755,
+				"bin/tool":   0755,
+				"bin/setuid": 0755,
+			},
+		},
+		{
+			name: "drops all group and other bits",
+			mask: 0700,
+			want: map[string]os.FileMode{
+				"bin":        os.ModeDir | 0700,
+				"bin/tool":   0700,
+				"bin/setuid": 0700,
+			},
+		},
+		{
+			name: "keeps special bits within the mask",
+			mask: os.ModeSetuid | 0755,
+			want: map[string]os.FileMode{
+				"bin":        os.ModeDir | 0755,
+				"bin/tool":   0755,
+				"bin/setuid": os.ModeSetuid | 0755,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			require.NoError(t, UntarToDirectory(newReader(), dst, WithModeMask(tt.mask), WithDirectoryModes(true), WithPreserveSpecialBits(true)))
+
+			for p, expected := range tt.want {
+				info, err := os.Stat(filepath.Join(dst, p))
+				require.NoError(t, err)
+				assert.Equal(t, expected, info.Mode(), "mode of %q", p)
+			}
+		})
+	}
+}
+
+func TestUntarToDirectory_ExtractBudget(t *testing.T) {
+	newReader := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "a.txt"}, content: "1234"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "b.txt"}, content: "5678"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "c.txt"}, content: "9012"},
+		)
+	}
+
+	tests := []struct {
+		name      string
+		options   []Option
+		wantErr   require.ErrorAssertionFunc
+		wantFiles []string
+	}{
+		{
+			name:      "within budget",
+			options:   []Option{WithExtractBudget(12, true)},
+			wantErr:   require.NoError,
+			wantFiles: []string{"a.txt", "b.txt", "c.txt"},
+		},
+		{
+			name:      "stops cleanly",
+			options:   []Option{WithExtractBudget(10, false)},
+			wantErr:   require.NoError,
+			wantFiles: []string{"a.txt", "b.txt"},
+		},
+		{
+			name:    "errors on exceed",
+			options: []Option{WithExtractBudget(10, true)},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var budgetErr *ErrExtractBudgetExceeded
+				require.ErrorAs(t, err, &budgetErr)
+				assert.Equal(t, int64(10), budgetErr.Budget)
+			},
+			wantFiles: []string{"a.txt", "b.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			tt.wantErr(t, UntarToDirectory(newReader(), dst, tt.options...))
+
+			entries, err := os.ReadDir(dst)
+			require.NoError(t, err)
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			assert.Equal(t, tt.wantFiles, names)
+		})
+	}
+}
+
+func TestUntarToDirectory_LargeFileHandler(t *testing.T) {
+	newReader := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "small.txt"}, content: "1234"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "large.bin"}, content: strings.Repeat("x", 1024)},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "limit.txt"}, content: "12345678"},
+		)
+	}
+
+	tests := []struct {
+		name      string
+		skip      bool
+		wantFiles []string
+	}{
+		{
+			name:      "skips large files",
+			skip:      true,
+			wantFiles: []string{"limit.txt", "small.txt"},
+		},
+		{
+			name:      "writes large files not skipped",
+			wantFiles: []string{"large.bin", "limit.txt", "small.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			large := make(map[string]int64)
+			handler := func(name string, size int64) bool {
+				large[name] = size
+				return tt.skip
+			}
+			dst := t.TempDir()
+			require.NoError(t, UntarToDirectory(newReader(), dst, WithLargeFileHandler(8, handler)))
+
+			// only files exceeding the threshold are passed to the handler
+			assert.Equal(t, map[string]int64{"large.bin": 1024}, large)
+			entries, err := os.ReadDir(dst)
+			require.NoError(t, err)
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			assert.Equal(t, tt.wantFiles, names)
+		})
+	}
+}
+
+func TestUntarToDirectory_DigestManifest(t *testing.T) {
+	digest := func(content string) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content)))
+	}
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/"}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "./etc/hosts"}, content: "original"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/passwd"}, content: "root:x:0:0"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/link", Linkname: "hosts"}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "empty"}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts"}, content: "replaced"},
+	)
+
+	manifest := make(map[string]string)
+	require.NoError(t, UntarToDirectory(reader, t.TempDir(), WithDigestManifest(manifest)))
+	assert.Equal(t, map[string]string{
+		"etc/hosts":  digest("replaced"),
+		"etc/passwd": digest("root:x:0:0"),
+		"empty":      digest(""),
+	}, manifest)
+}
+
+func TestUntarToDirectory_LegacyDigestManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		newHash func() hash.Hash
+		want    string
+	}{
+		{name: "md5", newHash: md5.New, want: "md5:5d41402abc4b2a76b9719d911017c592"},
+		{name: "sha1", newHash: sha1.New, want: "sha1:aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newTestTar(t,
+				testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "greeting"}, content: "hello"},
+			)
+			manifest := make(map[string]string)
+			require.NoError(t, UntarToDirectory(reader, t.TempDir(), WithHashAlgorithm("", tt.newHash), WithDigestManifest(manifest)))
+			assert.Equal(t, map[string]string{"greeting": tt.want}, manifest)
+		})
+	}
+}
+
+func TestUntarToDirectoryResult(t *testing.T) {
+	entries := []testTarEntry{
+		{header: tar.Header{Typeflag: tar.TypeDir, Name: "a/"}},
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "a/one.txt"}, content: "one"},
+		{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "a/link", Linkname: "one.txt"}},
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "a/large.bin"}, content: strings.Repeat("x", 1500)},
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "empty.txt"}},
+	}
+	archive, err := io.ReadAll(newTestTar(t, entries...))
+	require.NoError(t, err)
+	// writers commonly pad archives beyond the end-of-archive marker to a multiple of the tar record size
+	padded := append(bytes.Clone(archive), make([]byte, 10*tarBlockSize)...)
+
+	tests := []struct {
+		name    string
+		archive []byte
+		options []Option
+		want    UntarResult
+	}{
+		{
+			name:    "content size",
+			archive: archive,
+			want:    UntarResult{Entries: 5, Size: 1503},
+		},
+		{
+			name:    "stream size",
+			archive: archive,
+			options: []Option{WithHeaderOverhead(true)},
+			want:    UntarResult{Entries: 5, Size: int64(len(archive))},
+		},
+		{
+			name:    "padded stream size",
+			archive: padded,
+			options: []Option{WithHeaderOverhead(true)},
+			want:    UntarResult{Entries: 5, Size: int64(len(padded))},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UntarToDirectoryResult(bytes.NewReader(tt.archive), t.TempDir(), tt.options...)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// slowCreateFs delays creating the named file, as a slow disk might.
+type slowCreateFs struct {
+	afero.Fs
+	name  string
+	delay time.Duration
+}
+
+func (f slowCreateFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if name == f.name {
+		time.Sleep(f.delay)
+	}
+	return f.Fs.OpenFile(name, flag, perm)
+}
+
+func TestUntarToDirectoryResult_Stats(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "small.txt"}, content: "small"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "large.bin"}, content: strings.Repeat("x", 1500)},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "slow.txt"}, content: "slow"},
+	)
+	extractor := NewExtractor(WithExtractStats(true))
+	extractor.fs = slowCreateFs{Fs: afero.NewMemMapFs(), name: "/dst/slow.txt", delay: delay}
+
+	result, err := extractor.UntarWithResult(reader, "/dst")
+	require.NoError(t, err)
+	require.NotNil(t, result.Stats)
+
+	assert.Equal(t, "large.bin", result.Stats.Largest.Name)
+	assert.Equal(t, int64(1500), result.Stats.Largest.Size)
+	assert.Equal(t, "slow.txt", result.Stats.Slowest.Name)
+	assert.Equal(t, int64(4), result.Stats.Slowest.Size)
+	assert.GreaterOrEqual(t, result.Stats.Slowest.Duration, delay)
+	assert.GreaterOrEqual(t, result.Stats.Duration, result.Stats.Slowest.Duration)
+
+	// stats are only collected when requested
+	result, err = UntarToDirectoryResult(newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "a"}}), t.TempDir())
+	require.NoError(t, err)
+	assert.Nil(t, result.Stats)
+}
+
+func TestUntarToDirectory_WithDeadline(t *testing.T) {
+	reader := newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file.txt"}, content: "hi"})
+
+	err := UntarToDirectory(reader, t.TempDir(), WithDeadline(time.Now().Add(-time.Second)))
+
+	var deadlineErr *ErrExtractionDeadlineExceeded
+	require.ErrorAs(t, err, &deadlineErr)
+
+	// no deadline is the default
+	reader = newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file.txt"}, content: "hi"})
+	require.NoError(t, UntarToDirectory(reader, t.TempDir(), WithDeadline(time.Time{})))
+}
+
+func TestUntarToDirectory_Destination(t *testing.T) {
+	newReader := func() io.Reader {
+		return newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file.txt"}, content: "hi"})
+	}
+
+	t.Run("missing destination is created", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "does", "not", "exist")
+		require.NoError(t, UntarToDirectory(newReader(), dst))
+		assert.FileExists(t, filepath.Join(dst, "file.txt"))
+	})
+
+	t.Run("destination that is a file is rejected", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "a-file")
+		require.NoError(t, os.WriteFile(dst, []byte("not a dir"), 0644))
+
+		var notDir *ErrDestinationNotDirectory
+		require.ErrorAs(t, UntarToDirectory(newReader(), dst), &notDir)
+		assert.Equal(t, dst, notDir.Path)
+	})
+}
+
+func TestIterateTar_DuplicatePathHandler(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/passwd"}, content: "1"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts"}, content: "2"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "./etc/passwd"}, content: "3"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/passwd"}, content: "4"},
+	)
+
+	type duplicate struct {
+		name            string
+		first, sequence int64
+	}
+	var got []duplicate
+	err := IterateTar(reader, func(TarFileEntry) error { return nil }, WithDuplicatePathHandler(func(name string, first, sequence int64) {
+		got = append(got, duplicate{name: name, first: first, sequence: sequence})
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []duplicate{
+		{name: "etc/passwd", first: 0, sequence: 2},
+		{name: "etc/passwd", first: 0, sequence: 3},
+	}, got)
+}
+
+func TestIterateTar_PAXSizeValidation(t *testing.T) {
+	// newPAXTar writes a single entry carrying a PAX record, optionally rewriting that record into a size record which
+	// disagrees with the ustar header
+	newPAXTar := func(t *testing.T, smuggle bool) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		content := "hello world!!"
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:       "file.txt",
+			Typeflag:   tar.TypeReg,
+			Mode:       0644,
+			Size:       int64(len(content)),
+			Format:     tar.FormatPAX,
+			PAXRecords: map[string]string{"comment": "1"},
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		if !smuggle {
+			return buf.Bytes()
+		}
+		// the replacement record is the same length, so no other header fields need to change
+		smuggled := bytes.Replace(buf.Bytes(), []byte("13 comment=1\n"), []byte("13 size=0005\n"), 1)
+		require.NotEqual(t, buf.Bytes(), smuggled)
+		return smuggled
+	}
+
+	tests := []struct {
+		name      string
+		smuggle   bool
+		streaming bool
+		options   []Option
+		wantErr   require.ErrorAssertionFunc
+	}{
+		{
+			name:    "agreeing sizes",
+			options: []Option{WithPAXSizeValidation(true)},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "disagreeing sizes without validation",
+			smuggle: true,
+			wantErr: require.NoError,
+		},
+		{
+			name:    "disagreeing sizes",
+			smuggle: true,
+			options: []Option{WithPAXSizeValidation(true)},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var target *ErrSizeDisagreement
+				require.ErrorAs(t, err, &target)
+				assert.Equal(t, &ErrSizeDisagreement{Name: "file.txt", PAXSize: 5, USTARSize: 13}, target)
+			},
+		},
+		{
+			name:      "disagreeing sizes from a stream",
+			smuggle:   true,
+			streaming: true,
+			options:   []Option{WithPAXSizeValidation(true)},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var target *ErrSizeDisagreement
+				require.ErrorAs(t, err, &target)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reader io.Reader = bytes.NewReader(newPAXTar(t, tt.smuggle))
+			if tt.streaming {
+				reader = &slowReader{reader: reader, chunk: 100}
+			}
+			err := IterateTar(reader, func(TarFileEntry) error { return nil }, tt.options...)
+			tt.wantErr(t, err)
+		})
+	}
+}
+
+func TestIterateTar_RequiredTerminator(t *testing.T) {
+	// newTerminatedTar writes a single entry followed by the given number of zero blocks (a complete archive has two)
+	newTerminatedTar := func(t *testing.T, zeroBlocks int) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		content := "hello world!!"
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     "file.txt",
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(content)),
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+		// flushing pads the entry content without writing the end-of-archive marker
+		require.NoError(t, tw.Flush())
+		buf.Write(make([]byte, zeroBlocks*tarBlockSize))
+		return buf.Bytes()
+	}
+
+	missingTerminator := func(zeroBlocks int) require.ErrorAssertionFunc {
+		return func(t require.TestingT, err error, _ ...interface{}) {
+			var target *ErrMissingTerminator
+			require.ErrorAs(t, err, &target)
+			assert.Equal(t, zeroBlocks, target.ZeroBlocks)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		zeroBlocks int
+		streaming  bool
+		options    []Option
+		wantErr    require.ErrorAssertionFunc
+	}{
+		{
+			name:       "terminated",
+			zeroBlocks: 2,
+			options:    []Option{WithRequiredTerminator(true)},
+			wantErr:    require.NoError,
+		},
+		{
+			name:       "terminated stream",
+			zeroBlocks: 2,
+			streaming:  true,
+			options:    []Option{WithRequiredTerminator(true)},
+			wantErr:    require.NoError,
+		},
+		{
+			name:    "unterminated without the requirement",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "unterminated",
+			options: []Option{WithRequiredTerminator(true)},
+			wantErr: missingTerminator(0),
+		},
+		{
+			name:      "unterminated stream",
+			streaming: true,
+			options:   []Option{WithRequiredTerminator(true)},
+			wantErr:   missingTerminator(0),
+		},
+		{
+			name:       "single zero block",
+			zeroBlocks: 1,
+			options:    []Option{WithRequiredTerminator(true)},
+			wantErr:    missingTerminator(1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reader io.Reader = bytes.NewReader(newTerminatedTar(t, tt.zeroBlocks))
+			if tt.streaming {
+				reader = &slowReader{reader: reader, chunk: 100}
+			}
+			// the visitor leaves the content unread, so seekable archives skip over it
+			err := IterateTar(reader, func(TarFileEntry) error { return nil }, tt.options...)
+			tt.wantErr(t, err)
+		})
+	}
+}
+
+func TestIterateTar_UnsafeLinkRejection(t *testing.T) {
+	link := func(typeflag byte, name, target string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: typeflag, Name: name, Linkname: target}}
+	}
+	unsafeLink := func(name, target string) require.ErrorAssertionFunc {
+		return func(t require.TestingT, err error, _ ...interface{}) {
+			var linkErr *ErrUnsafeLinkTarget
+			require.ErrorAs(t, err, &linkErr)
+			assert.Equal(t, &ErrUnsafeLinkTarget{Name: name, Linkname: target}, linkErr)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		entry   testTarEntry
+		options []Option
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "absolute hardlink without rejection",
+			entry:   link(tar.TypeLink, "etc/shadow", "/etc/shadow"),
+			wantErr: require.NoError,
+		},
+		{
+			name:    "absolute hardlink",
+			entry:   link(tar.TypeLink, "etc/shadow", "/etc/shadow"),
+			options: []Option{WithUnsafeLinkRejection(true)},
+			wantErr: unsafeLink("etc/shadow", "/etc/shadow"),
+		},
+		{
+			name:    "escaping hardlink",
+			entry:   link(tar.TypeLink, "a/b/secret", "../../secret"),
+			options: []Option{WithUnsafeLinkRejection(true)},
+			wantErr: unsafeLink("a/b/secret", "../../secret"),
+		},
+		{
+			name:    "hardlink within the archive",
+			entry:   link(tar.TypeLink, "a/b/link", "a/target"),
+			options: []Option{WithUnsafeLinkRejection(true)},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "absolute symlink",
+			entry:   link(tar.TypeSymlink, "shadow", "/etc/shadow"),
+			options: []Option{WithUnsafeLinkRejection(true)},
+			wantErr: unsafeLink("shadow", "/etc/shadow"),
+		},
+		{
+			name:    "escaping symlink",
+			entry:   link(tar.TypeSymlink, "a/secret", "../../secret"),
+			options: []Option{WithUnsafeLinkRejection(true)},
+			wantErr: unsafeLink("a/secret", "../../secret"),
+		},
+		{
+			name:    "symlink within the archive",
+			entry:   link(tar.TypeSymlink, "a/b/secret", "../../secret"),
+			options: []Option{WithUnsafeLinkRejection(true)},
+			wantErr: require.NoError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := IterateTar(newTestTar(t, tt.entry), func(TarFileEntry) error { return nil }, tt.options...)
+			tt.wantErr(t, err)
+		})
+	}
+}
+
+func TestIterateTar_DeviceRejection(t *testing.T) {
+	device := func(typeflag byte, name string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: typeflag, Name: name, Devmajor: 1, Devminor: 3}}
+	}
+	rejectedDevice := func(name string, ty Type) require.ErrorAssertionFunc {
+		return func(t require.TestingT, err error, _ ...interface{}) {
+			var deviceErr *ErrDeviceEntry
+			require.ErrorAs(t, err, &deviceErr)
+			assert.Equal(t, &ErrDeviceEntry{Name: name, Type: ty}, deviceErr)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		entry   testTarEntry
+		options []Option
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "character device without rejection",
+			entry:   device(tar.TypeChar, "dev/null"),
+			wantErr: require.NoError,
+		},
+		{
+			name:    "character device",
+			entry:   device(tar.TypeChar, "dev/null"),
+			options: []Option{WithDeviceRejection(true)},
+			wantErr: rejectedDevice("dev/null", TypeCharacterDevice),
+		},
+		{
+			name:    "block device",
+			entry:   device(tar.TypeBlock, "dev/sda"),
+			options: []Option{WithDeviceRejection(true)},
+			wantErr: rejectedDevice("dev/sda", TypeBlockDevice),
+		},
+		{
+			name:    "fifo",
+			entry:   testTarEntry{header: tar.Header{Typeflag: tar.TypeFifo, Name: "run/pipe"}},
+			options: []Option{WithDeviceRejection(true)},
+			wantErr: require.NoError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := IterateTar(newTestTar(t, tt.entry), func(TarFileEntry) error { return nil }, tt.options...)
+			tt.wantErr(t, err)
+		})
+	}
+}
+
+func TestIterateTar_RequireSorted(t *testing.T) {
+	reg := func(name string) testTarEntry {
+		return testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name}}
+	}
+	unsorted := func(previous, name string) require.ErrorAssertionFunc {
+		return func(t require.TestingT, err error, _ ...interface{}) {
+			var unsortedErr *ErrUnsortedArchive
+			require.ErrorAs(t, err, &unsortedErr)
+			assert.Equal(t, &ErrUnsortedArchive{Previous: previous, Name: name}, unsortedErr)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		entries []testTarEntry
+		options []Option
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "unsorted without the requirement",
+			entries: []testTarEntry{reg("b"), reg("a")},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "sorted",
+			entries: []testTarEntry{reg("a"), reg("a/b"), reg("b")},
+			options: []Option{WithRequireSorted(true)},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "duplicate names",
+			entries: []testTarEntry{reg("a"), reg("./a"), reg("b")},
+			options: []Option{WithRequireSorted(true)},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "names the first out of order pair",
+			entries: []testTarEntry{reg("a"), reg("c"), reg("./b"), reg("a")},
+			options: []Option{WithRequireSorted(true)},
+			wantErr: unsorted("c", "b"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := IterateTar(newTestTar(t, tt.entries...), func(TarFileEntry) error { return nil }, tt.options...)
+			tt.wantErr(t, err)
+		})
+	}
+}
+
+func TestIterateTar_RequireSortedAfterRewrite(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "usr/bin/env"}, content: "env"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: "etc/"}},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "etc/hosts"}, content: "hosts"},
+	)
+	sorted := &bytes.Buffer{}
+	require.NoError(t, RewriteTar(reader, sorted, nil, WithSortEntries(true)))
+
+	err := IterateTar(sorted, func(TarFileEntry) error { return nil }, WithRequireSorted(true))
+	require.NoError(t, err)
+}
+
+func TestUntarToDirectory_DeviceRejection(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file"}, content: "content"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeChar, Name: "null", Devmajor: 1, Devminor: 3}},
+	)
+
+	err := UntarToDirectory(reader, t.TempDir(), WithDeviceRejection(true))
+	var deviceErr *ErrDeviceEntry
+	require.ErrorAs(t, err, &deviceErr)
+	assert.Equal(t, "null", deviceErr.Name)
+}
+
+func TestContainsDevices(t *testing.T) {
+	reg := testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file"}, content: "content"}
+	tests := []struct {
+		name    string
+		entries []testTarEntry
+		want    bool
+	}{
+		{
+			name:    "no devices",
+			entries: []testTarEntry{reg, {header: tar.Header{Typeflag: tar.TypeFifo, Name: "pipe"}}},
+		},
+		{
+			name:    "character device",
+			entries: []testTarEntry{reg, {header: tar.Header{Typeflag: tar.TypeChar, Name: "dev/null"}}},
+			want:    true,
+		},
+		{
+			name:    "block device",
+			entries: []testTarEntry{{header: tar.Header{Typeflag: tar.TypeBlock, Name: "dev/sda"}}, reg},
+			want:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ContainsDevices(newTestTar(t, tt.entries...))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestIterateTar_StopSemantics(t *testing.T) {
+	errBanned := errors.New("found a banned file")
+	reader := func() io.Reader {
+		return newTestTar(t,
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "first"}, content: "content"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "banned"}, content: "content"},
+			testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "last"}, content: "content"},
+		)
+	}
+
+	tests := []struct {
+		name        string
+		visitErr    error
+		wantVisited []string
+		wantErr     require.ErrorAssertionFunc
+	}{
+		{
+			name:        "continue",
+			wantVisited: []string{"first", "banned", "last"},
+			wantErr:     require.NoError,
+		},
+		{
+			name:        "clean stop",
+			visitErr:    ErrTarStopIteration,
+			wantVisited: []string{"first", "banned"},
+			wantErr:     require.NoError,
+		},
+		{
+			name:        "error stop",
+			visitErr:    errBanned,
+			wantVisited: []string{"first", "banned"},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				require.ErrorIs(t, err, errBanned)
+				assert.Contains(t, err.Error(), `failed to visit tar entry="banned"`)
+			},
+		},
+		{
+			name:        "error stop without entry context",
+			visitErr:    StopIterationWithError(errBanned),
+			wantVisited: []string{"first", "banned"},
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				assert.Equal(t, errBanned, err)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var visited []string
+			visitor := func(entry TarFileEntry) error {
+				visited = append(visited, entry.Header.Name)
+				if entry.Header.Name == "banned" {
+					return tt.visitErr
+				}
+				return nil
+			}
+			tt.wantErr(t, IterateTar(reader(), visitor))
+			assert.Equal(t, tt.wantVisited, visited)
+		})
+	}
+}
+
+func TestStopIterationWithError(t *testing.T) {
+	errBanned := errors.New("found a banned file")
+	err := StopIterationWithError(errBanned)
+	assert.ErrorIs(t, err, ErrTarStopIteration)
+	assert.ErrorIs(t, err, errBanned)
+	assert.Equal(t, errBanned.Error(), err.Error())
+}
+
+// nilHeaderTarReader yields the given number of nil headers without an error before each of the given headers.
+type nilHeaderTarReader struct {
+	nils    int
+	headers []*tar.Header
+	pending int
+}
+
+func (r *nilHeaderTarReader) Next() (*tar.Header, error) {
+	if r.pending < r.nils {
+		r.pending++
+		return nil, nil
+	}
+	if len(r.headers) == 0 {
+		return nil, io.EOF
+	}
+	hdr := r.headers[0]
+	r.headers = r.headers[1:]
+	r.pending = 0
+	return hdr, nil
+}
+
+func (r *nilHeaderTarReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func TestIterateTar_NilHeaders(t *testing.T) {
+	tests := []struct {
+		name          string
+		nils          int
+		wantSequences []int64
+		wantErr       require.ErrorAssertionFunc
+	}{
+		{
+			name:          "occasional nil headers are skipped",
+			nils:          maxConsecutiveNilHeaders,
+			wantSequences: []int64{0, 1},
+			wantErr:       require.NoError,
+		},
+		{
+			name: "endless nil headers abort iteration",
+			nils: math.MaxInt,
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var malformed *ErrMalformedArchive
+				require.ErrorAs(t, err, &malformed)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := newTarReader
+			newTarReader = func(io.Reader) tarEntryReader {
+				return &nilHeaderTarReader{
+					nils: tt.nils,
+					headers: []*tar.Header{
+						{Typeflag: tar.TypeDir, Name: "a/"},
+						{Typeflag: tar.TypeDir, Name: "b/"},
+					},
+				}
+			}
+			t.Cleanup(func() {
+				newTarReader = original
+			})
+
+			var sequences []int64
+			err := IterateTar(bytes.NewReader(nil), func(entry TarFileEntry) error {
+				sequences = append(sequences, entry.Sequence)
+				return nil
+			})
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.wantSequences, sequences)
+		})
+	}
+}
+
+func TestIterateTar_GlobalHeader(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		Name:       "pax_global_header",
+		PAXRecords: map[string]string{"comment": "archive-wide"},
+	}))
+	for _, name := range []string{"a.txt", "b.txt"} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644}))
+	}
+	require.NoError(t, tw.Close())
+
+	type visited struct {
+		name     string
+		sequence int64
+	}
+
+	t.Run("with callback", func(t *testing.T) {
+		var globals []map[string]string
+		var entries []visited
+		err := IterateTar(bytes.NewReader(buf.Bytes()), func(entry TarFileEntry) error {
+			entries = append(entries, visited{name: entry.Header.Name, sequence: entry.Sequence})
+			return nil
+		}, WithGlobalHeader(func(records map[string]string) {
+			globals = append(globals, records)
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, []map[string]string{{"comment": "archive-wide"}}, globals)
+		assert.Equal(t, []visited{{name: "a.txt", sequence: 0}, {name: "b.txt", sequence: 1}}, entries)
+	})
+
+	t.Run("without callback", func(t *testing.T) {
+		var entries []visited
+		err := IterateTar(bytes.NewReader(buf.Bytes()), func(entry TarFileEntry) error {
+			entries = append(entries, visited{name: entry.Header.Name, sequence: entry.Sequence})
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []visited{{name: "pax_global_header", sequence: 0}, {name: "a.txt", sequence: 1}, {name: "b.txt", sequence: 2}}, entries)
+	})
+}
+
+func TestIterateTar_EntryConsumptionHandler(t *testing.T) {
+	reader := newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "read.txt"}, content: "fully read"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "partial.txt"}, content: "partially read"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "unread.txt"}, content: "never read"},
+	)
+
+	var got []TarEntryConsumption
+	err := IterateTar(reader, func(entry TarFileEntry) error {
+		switch entry.Header.Name {
+		case "read.txt":
+			_, err := io.ReadAll(entry.Reader)
+			return err
+		case "partial.txt":
+			_, err := io.ReadFull(entry.Reader, make([]byte, 4))
+			return err
+		}
+		return nil
+	}, WithContentTypeDetection(true), WithEntryConsumptionHandler(func(c TarEntryConsumption) {
+		got = append(got, c)
+	}))
+	require.NoError(t, err)
+
+	// note: bytes read for content type detection are not attributed to the visitor
+	assert.Equal(t, []TarEntryConsumption{
+		{Sequence: 0, Name: "read.txt", Size: 10, Consumed: 10},
+		{Sequence: 1, Name: "partial.txt", Size: 14, Consumed: 4},
+		{Sequence: 2, Name: "unread.txt", Size: 10, Consumed: 0},
+	}, got)
+}
+
+// corruptRangeReader fails any read overlapping the given byte range.
+type corruptRangeReader struct {
+	*bytes.Reader
+	start, end int64
+}
+
+func (c *corruptRangeReader) Read(p []byte) (int, error) {
+	pos, err := c.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if pos < c.end && pos+int64(len(p)) > c.start {
+		return 0, fmt.Errorf("corrupt content")
+	}
+	return c.Reader.Read(p)
+}
+
+func TestIterateTar_EntryErrorHandler(t *testing.T) {
+	archive, err := io.ReadAll(newTestTar(t,
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "first.txt"}, content: "first"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "corrupt.txt"}, content: "corrupt"},
+		testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "last.txt"}, content: "last"},
+	))
+	require.NoError(t, err)
+
+	// each entry is a header block followed by a single content block, so the second entry content is the 4th block
+	newReader := func() *corruptRangeReader {
+		return &corruptRangeReader{Reader: bytes.NewReader(archive), start: 3 * 512, end: 4 * 512}
+	}
+
+	visitor := func(contents map[string]string) TarFileVisitor {
+		return func(entry TarFileEntry) error {
+			content, err := io.ReadAll(entry.Reader)
+			if err != nil {
+				return err
+			}
+			contents[entry.Header.Name] = string(content)
+			return nil
+		}
+	}
+
+	t.Run("continues past a corrupt entry", func(t *testing.T) {
+		var failed []int64
+		contents := make(map[string]string)
+		err := IterateTar(newReader(), visitor(contents), WithEntryErrorHandler(func(entry TarFileEntry, err error) {
+			assert.ErrorContains(t, err, "corrupt content")
+			failed = append(failed, entry.Sequence)
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1}, failed)
+		assert.Equal(t, map[string]string{"first.txt": "first", "last.txt": "last"}, contents)
+	})
+
+	t.Run("aborts without a handler", func(t *testing.T) {
+		err := IterateTar(newReader(), visitor(make(map[string]string)))
+		require.ErrorContains(t, err, "corrupt content")
+	})
+
+	t.Run("aborts when the stream cannot be resumed", func(t *testing.T) {
+		var failed int
+		stream := struct{ io.Reader }{newReader()}
+		err := IterateTar(stream, visitor(make(map[string]string)), WithEntryErrorHandler(func(TarFileEntry, error) {
+			failed++
+		}))
+		require.ErrorContains(t, err, "corrupt content")
+		assert.Equal(t, 1, failed)
+	})
+}
+
+func TestSafeJoin(t *testing.T) {
+	traversal := func(t require.TestingT, err error, _ ...interface{}) {
+		var target *ErrPathTraversal
+		require.ErrorAs(t, err, &target)
+	}
+
+	tests := []struct {
+		name      string
+		dst       string
+		entryName string
+		want      string
+		wantErr   require.ErrorAssertionFunc
+	}{
+		{name: "nested path", dst: "/tmp/dst", entryName: "etc/apk/repositories", want: "/tmp/dst/etc/apk/repositories"},
+		{name: "leading dot slash", dst: "/tmp/dst", entryName: "./etc/passwd", want: "/tmp/dst/etc/passwd"},
+		{name: "directory entry", dst: "/tmp/dst", entryName: "etc/", want: "/tmp/dst/etc"},
+		{name: "dot root", dst: "/tmp/dst", entryName: ".", want: "/tmp/dst"},
+		{name: "dot slash root", dst: "/tmp/dst", entryName: "./", want: "/tmp/dst"},
+		{name: "absolute path is anchored", dst: "/tmp/dst", entryName: "/etc/passwd", want: "/tmp/dst/etc/passwd"},
+		{name: "inner parent reference", dst: "/tmp/dst", entryName: "etc/../var/log", want: "/tmp/dst/var/log"},
+		{name: "unclean destination", dst: "/tmp/dst/", entryName: "etc/passwd", want: "/tmp/dst/etc/passwd"},
+		{name: "relative destination", dst: "dst", entryName: "etc/passwd", want: "dst/etc/passwd"},
+		{name: "parent escape", dst: "/tmp/dst", entryName: "../etc/passwd", wantErr: traversal},
+		{name: "bare parent", dst: "/tmp/dst", entryName: "..", wantErr: traversal},
+		{name: "nested escape", dst: "/tmp/dst", entryName: "etc/../../../etc/passwd", wantErr: traversal},
+		{name: "sibling with same prefix", dst: "/tmp/dst", entryName: "../dst-other/file", wantErr: traversal},
+		{name: "absolute escape", dst: "/tmp/dst", entryName: "/../../etc/passwd", wantErr: traversal},
+		{name: "relative destination escape", dst: "dst", entryName: "../file", wantErr: traversal},
+		{name: "dotdot prefixed name is not an escape", dst: "/tmp/dst", entryName: "..file", want: "/tmp/dst/..file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			got, err := SafeJoin(tt.dst, tt.entryName)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTarRootEntry(t *testing.T) {
+	for _, root := range []string{".", "./", "/"} {
+		newReader := func() io.ReadCloser {
+			return io.NopCloser(newTestTar(t,
+				testTarEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: root, Mode: 0700}},
+				testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file.txt"}, content: "hi"},
+			))
+		}
+
+		t.Run(fmt.Sprintf("root=%q", root), func(t *testing.T) {
+			for _, requested := range []string{".", "./", "/"} {
+				rc, err := ReaderFromTar(newReader(), requested)
+				require.NoError(t, err, "requested=%q", requested)
+				content, err := io.ReadAll(rc)
+				require.NoError(t, err)
+				assert.Empty(t, content)
+
+				metadata, err := MetadataFromTar(newReader(), requested)
+				require.NoError(t, err, "requested=%q", requested)
+				assert.Equal(t, "/", metadata.Path)
+				assert.Equal(t, TypeDirectory, metadata.Type)
+			}
+
+			dst := t.TempDir()
+			before, err := os.Stat(dst)
+			require.NoError(t, err)
+			require.NoError(t, UntarToDirectory(newReader(), dst, WithDirectoryModes(true)))
+			entries, err := os.ReadDir(dst)
+			require.NoError(t, err)
+			require.Len(t, entries, 1, "no spurious root directory should be created")
+			assert.Equal(t, "file.txt", entries[0].Name())
+
+			after, err := os.Stat(dst)
+			require.NoError(t, err)
+			assert.Equal(t, before.Mode(), after.Mode(), "the destination mode should be left as-is")
+		})
+	}
+
+	t.Run("other names must match exactly", func(t *testing.T) {
+		reader := io.NopCloser(newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "./file.txt"}, content: "hi"}))
+		_, err := ReaderFromTar(reader, "file.txt")
+		var notFound *ErrFileNotFound
+		require.ErrorAs(t, err, &notFound)
+	})
+}
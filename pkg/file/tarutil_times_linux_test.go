@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package file
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUntarToDirectory_SubSecondFileTimes(t *testing.T) {
+	// PAX records times as decimal seconds, which may carry a fractional part
+	modTime := time.Unix(1700000000, 123456789)
+	atime := time.Unix(1700000100, 987654321)
+	reader := newTestTar(t, testTarEntry{
+		header: tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       "file.txt",
+			ModTime:    modTime,
+			AccessTime: atime,
+			Format:     tar.FormatPAX,
+		},
+		content: "content",
+	})
+
+	dst := t.TempDir()
+	require.NoError(t, UntarToDirectory(reader, dst, WithFileTimes(true)))
+
+	info, err := os.Stat(filepath.Join(dst, "file.txt"))
+	require.NoError(t, err)
+	if info.ModTime().Equal(modTime.Truncate(time.Second)) {
+		t.Skip("the temp dir filesystem does not support sub-second timestamps")
+	}
+	assert.Equal(t, modTime.UnixNano(), info.ModTime().UnixNano())
+	assert.Equal(t, 123456789, info.ModTime().Nanosecond())
+}
@@ -0,0 +1,73 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// WriteEntryToFs writes the given entry beneath dst within the given filesystem, applying the same safety checks as
+// extraction, for use by custom visitors that build their own extraction loop. The entry name is joined to dst with
+// SafeJoin (failing with ErrPathTraversal for names escaping dst), missing parent directories are created, and regular
+// file content is copied subject to the given limit (failing with ErrReadLimitExceeded, where a limit of 0 or less is
+// the default per-file read limit). The mode from the header, including setuid, setgid, and sticky bits, is applied
+// to the written file or directory. As with extraction, all other entry types (links, devices, etc.) are skipped.
+func WriteEntryToFs(fs afero.Fs, dst string, entry TarFileEntry, limit int64) error {
+	target, err := SafeJoin(dst, entry.Header.Name)
+	if err != nil {
+		return err
+	}
+	if limit <= 0 {
+		limit = perFileReadLimit
+	}
+
+	mode := entry.Header.FileInfo().Mode()
+	mode = mode.Perm() | mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)
+	switch entry.Header.Typeflag {
+	case tar.TypeDir:
+		if err := fs.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+	case tar.TypeReg:
+		if err := writeEntryContent(fs, target, entry, limit); err != nil {
+			return err
+		}
+	default:
+		logSkippedEntry(entry, "non-regular file")
+		return nil
+	}
+
+	if err := fs.Chmod(target, mode); err != nil {
+		return fmt.Errorf("unable to set mode: %w", err)
+	}
+	return nil
+}
+
+// writeEntryContent writes the content of the given regular file entry to the target, removing the partially written
+// file on failure.
+func writeEntryContent(fs afero.Fs, target string, entry TarFileEntry, limit int64) error {
+	if err := fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := fs.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	content := entry.Reader
+	if content == nil {
+		content = bytes.NewReader(nil)
+	}
+	err = copyWithLimit(f, content, make([]byte, copyBufferSize), limit)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = fs.Remove(target)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteEntryToFs(t *testing.T) {
+	entry := func(typeflag byte, name, content string, mode int64) TarFileEntry {
+		return TarFileEntry{
+			Header: tar.Header{Typeflag: typeflag, Name: name, Mode: mode, Size: int64(len(content))},
+			Reader: strings.NewReader(content),
+		}
+	}
+
+	tests := []struct {
+		name     string
+		entry    TarFileEntry
+		limit    int64
+		wantErr  require.ErrorAssertionFunc
+		wantMode os.FileMode
+		want     string
+	}{
+		{
+			name:     "regular file",
+			entry:    entry(tar.TypeReg, "a/b/file.txt", "content", 0640),
+			limit:    7,
+			wantErr:  require.NoError,
+			wantMode: 0640,
+			want:     "content",
+		},
+		{
+			name:     "regular file with the default limit",
+			entry:    entry(tar.TypeReg, "file.txt", "content", 04755),
+			wantErr:  require.NoError,
+			wantMode: 0755 | os.ModeSetuid,
+			want:     "content",
+		},
+		{
+			name:     "directory",
+			entry:    entry(tar.TypeDir, "a/dir/", "", 0700),
+			wantErr:  require.NoError,
+			wantMode: os.ModeDir | 0700,
+		},
+		{
+			name:  "limit exceeded",
+			entry: entry(tar.TypeReg, "a/large.bin", "content", 0644),
+			limit: 6,
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				require.ErrorIs(t, err, ErrReadLimitExceeded)
+			},
+		},
+		{
+			name:  "path traversal",
+			entry: entry(tar.TypeReg, "../escape.txt", "content", 0644),
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				var traversal *ErrPathTraversal
+				require.ErrorAs(t, err, &traversal)
+			},
+		},
+		{
+			name:    "symlinks are skipped",
+			entry:   TarFileEntry{Header: tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/etc/passwd"}},
+			wantErr: require.NoError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			require.NoError(t, fs.MkdirAll("/dst", 0755))
+
+			err := WriteEntryToFs(fs, "/dst", tt.entry, tt.limit)
+			tt.wantErr(t, err)
+
+			target := "/dst/" + strings.TrimSuffix(tt.entry.Header.Name, "/")
+			info, statErr := fs.Stat(target)
+			if tt.wantMode == 0 {
+				assert.True(t, os.IsNotExist(statErr), "nothing should be written to %q", target)
+				return
+			}
+			require.NoError(t, statErr)
+			assert.Equal(t, tt.wantMode, info.Mode())
+			if !info.IsDir() {
+				content, err := afero.ReadFile(fs, target)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, string(content))
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package file
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"time"
+
+	"github.com/anchore/stereoscope/internal/log"
+)
+
+// writeRetry retries filesystem operations that fail transiently, such as those interrupted by a signal or hitting a
+// temporary I/O error on networked or overlay filesystems. The zero value does not retry.
+type writeRetry struct {
+	attempts int
+	backoff  time.Duration
+}
+
+// do invokes op until it succeeds, fails with an error that is not transient, or the attempts are used up. The wait
+// between attempts starts at the backoff and doubles after each attempt.
+func (r writeRetry) do(op func() error) error {
+	wait := r.backoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= r.attempts || !isTransientWriteError(err) {
+			return err
+		}
+		log.WithFields("attempt", attempt, "error", err).Trace("retrying transient write error")
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (r writeRetry) enabled() bool {
+	return r.attempts > 1
+}
+
+// isTransientWriteError reports whether the given error may succeed when retried. Errors such as EACCES or ENOSPC are
+// not expected to be resolved by retrying and are therefore not considered transient.
+func isTransientWriteError(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EIO)
+}
+
+// retryingWriter retries transiently failing writes for the bytes not yet written, leaving the content already
+// consumed from the source intact (an entry's content cannot be read again).
+type retryingWriter struct {
+	writer io.Writer
+	retry  writeRetry
+}
+
+func (w retryingWriter) Write(p []byte) (int, error) {
+	written := 0
+	err := w.retry.do(func() error {
+		n, err := w.writer.Write(p[written:])
+		written += n
+		return err
+	})
+	return written, err
+}
@@ -0,0 +1,128 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"archive/tar"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyFs fails the first opens and the first writes of each file with the given errors.
+type flakyFs struct {
+	afero.Fs
+	openErrs  []error
+	writeErrs []error
+	opens     int
+	writes    int
+}
+
+func (f *flakyFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f.opens++
+	if len(f.openErrs) > 0 {
+		err := f.openErrs[0]
+		f.openErrs = f.openErrs[1:]
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	file, err := f.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyFile{File: file, fs: f}, nil
+}
+
+type flakyFile struct {
+	afero.File
+	fs *flakyFs
+}
+
+func (f *flakyFile) Write(p []byte) (int, error) {
+	f.fs.writes++
+	if len(f.fs.writeErrs) > 0 {
+		err := f.fs.writeErrs[0]
+		f.fs.writeErrs = f.fs.writeErrs[1:]
+		// a partial write before failing
+		n, _ := f.File.Write(p[:len(p)/2])
+		return n, &os.PathError{Op: "write", Path: f.Name(), Err: err}
+	}
+	return f.File.Write(p)
+}
+
+func TestWithWriteRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   []Option
+		openErrs  []error
+		writeErrs []error
+		wantOpens int
+		wantErr   require.ErrorAssertionFunc
+		// wantContent is the content expected to be extracted, if any
+		wantContent string
+	}{
+		{
+			name:      "transient errors fail without retries",
+			openErrs:  []error{syscall.EINTR},
+			wantOpens: 1,
+			wantErr:   require.Error,
+		},
+		{
+			name:        "transient open and write errors are retried",
+			options:     []Option{WithWriteRetry(3, 0)},
+			openErrs:    []error{syscall.EINTR},
+			writeErrs:   []error{syscall.EIO, syscall.EAGAIN},
+			wantOpens:   2,
+			wantErr:     require.NoError,
+			wantContent: "some content",
+		},
+		{
+			name:      "attempts are bounded",
+			options:   []Option{WithWriteRetry(2, 0)},
+			openErrs:  []error{syscall.EIO, syscall.EIO, syscall.EIO},
+			wantOpens: 2,
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				require.ErrorIs(t, err, syscall.EIO)
+			},
+		},
+		{
+			name:      "permission errors are not retried",
+			options:   []Option{WithWriteRetry(3, 0)},
+			openErrs:  []error{syscall.EACCES},
+			wantOpens: 1,
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				require.ErrorIs(t, err, syscall.EACCES)
+			},
+		},
+		{
+			name:      "out of space errors are not retried",
+			options:   []Option{WithWriteRetry(3, 0)},
+			writeErrs: []error{syscall.ENOSPC},
+			wantOpens: 1,
+			wantErr: func(t require.TestingT, err error, _ ...interface{}) {
+				require.ErrorIs(t, err, syscall.ENOSPC)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &flakyFs{Fs: afero.NewMemMapFs(), openErrs: tt.openErrs, writeErrs: tt.writeErrs}
+			extractor := NewExtractor(tt.options...)
+			extractor.fs = fs
+
+			reader := newTestTar(t, testTarEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: "file"}, content: "some content"})
+			tt.wantErr(t, extractor.Untar(reader, "/dst"))
+			assert.Equal(t, tt.wantOpens, fs.opens)
+
+			if tt.wantContent != "" {
+				content, err := afero.ReadFile(fs.Fs, "/dst/file")
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantContent, string(content), "retried writes should complete the content")
+			}
+		})
+	}
+}
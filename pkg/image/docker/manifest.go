@@ -3,9 +3,11 @@ package docker
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
@@ -57,7 +59,12 @@ func extractManifest(tarPath string) (*dockerManifest, error) {
 		}
 	}()
 
-	manifestReader, err := file.ReaderFromTar(f, "manifest.json")
+	return readManifest(f)
+}
+
+// readManifest finds and parses the manifest.json within the given docker image tar.
+func readManifest(reader io.ReadCloser) (*dockerManifest, error) {
+	manifestReader, err := file.ReaderFromTar(reader, "manifest.json")
 	if err != nil {
 		return nil, err
 	}
@@ -69,6 +76,117 @@ func extractManifest(tarPath string) (*dockerManifest, error) {
 	return newManifest(contents)
 }
 
+// RepoTagsFromTar returns the image tags referenced within the manifest.json of the given docker image tar (e.g. from
+// "docker save"). For archives with multiple images the tags of all images are returned.
+func RepoTagsFromTar(reader io.ReaderAt, size int64) ([]string, error) {
+	manifest, err := readManifest(io.NopCloser(io.NewSectionReader(reader, 0, size)))
+	if err != nil {
+		return nil, err
+	}
+	return manifest.allTags(), nil
+}
+
+// LegacyRepositoriesFromTar returns the mapping of repository to tag to layer ID from the legacy "repositories" file at
+// the root of the given docker image tar, as written by older versions of "docker save". Archives without the file
+// fail with a file.ErrFileNotFound.
+func LegacyRepositoriesFromTar(reader io.Reader) (map[string]map[string]string, error) {
+	repositoriesReader, err := file.ReaderFromTar(io.NopCloser(reader), "repositories")
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := io.ReadAll(repositoriesReader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read repositories: %w", err)
+	}
+
+	var repositories map[string]map[string]string
+	if err := json.Unmarshal(contents, &repositories); err != nil {
+		return nil, fmt.Errorf("unable to parse repositories: %w", err)
+	}
+	return repositories, nil
+}
+
+// maxOCIIndexDepth bounds how many nested image indexes are followed when locating an image within an OCI layout.
+const maxOCIIndexDepth = 8
+
+// ImageConfigFromTar returns the raw image config JSON within the given image tar without loading the image. Both
+// docker archives (e.g. from "docker save", where the config is referenced by manifest.json) and OCI image layouts
+// (where the config is referenced by the image manifest within index.json) are supported. Archives with more than
+// one image are rejected with ErrMultipleManifests.
+func ImageConfigFromTar(reader io.ReaderAt, size int64) (json.RawMessage, error) {
+	manifest, err := readManifest(io.NopCloser(io.NewSectionReader(reader, 0, size)))
+	var notFound *file.ErrFileNotFound
+	switch {
+	case errors.As(err, &notFound):
+		return ociImageConfigFromTar(reader, size)
+	case err != nil:
+		return nil, err
+	}
+
+	if len(manifest.parsed) != 1 {
+		return nil, ErrMultipleManifests
+	}
+	return readJSONFromTar(reader, size, manifest.parsed[0].Config)
+}
+
+// ociImageConfigFromTar locates the image config within an OCI image layout by following index.json to the image
+// manifest (through any nested indexes).
+func ociImageConfigFromTar(reader io.ReaderAt, size int64) (json.RawMessage, error) {
+	contents, err := readJSONFromTar(reader, size, "index.json")
+	if err != nil {
+		return nil, err
+	}
+
+	for depth := 0; depth < maxOCIIndexDepth; depth++ {
+		index, err := v1.ParseIndexManifest(bytes.NewReader(contents))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse OCI index: %w", err)
+		}
+		if len(index.Manifests) != 1 {
+			return nil, ErrMultipleManifests
+		}
+
+		descriptor := index.Manifests[0]
+		contents, err = readJSONFromTar(reader, size, ociBlobPath(descriptor.Digest))
+		if err != nil {
+			return nil, err
+		}
+		if descriptor.MediaType.IsIndex() {
+			continue
+		}
+
+		manifest, err := v1.ParseManifest(bytes.NewReader(contents))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse OCI manifest: %w", err)
+		}
+		return readJSONFromTar(reader, size, ociBlobPath(manifest.Config.Digest))
+	}
+	return nil, fmt.Errorf("exceeded max OCI index depth of %d", maxOCIIndexDepth)
+}
+
+// ociBlobPath returns the path of the blob with the given digest within an OCI image layout.
+func ociBlobPath(digest v1.Hash) string {
+	return path.Join("blobs", digest.Algorithm, digest.Hex)
+}
+
+// readJSONFromTar reads the JSON file at the given path within the tar.
+func readJSONFromTar(reader io.ReaderAt, size int64, tarPath string) (json.RawMessage, error) {
+	contentReader, err := file.ReaderFromTar(io.NopCloser(io.NewSectionReader(reader, 0, size)), tarPath)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := io.ReadAll(contentReader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", tarPath, err)
+	}
+	if !json.Valid(contents) {
+		return nil, fmt.Errorf("invalid JSON in %s", tarPath)
+	}
+	return contents, nil
+}
+
 // generateOCIManifest takes a docker manifest and a path to the tar and generates an OCI manifest derived from the given arguments and the docker config.
 func generateOCIManifest(tarPath string, manifest *dockerManifest) (*v1.Manifest, []byte, error) {
 	f, err := os.Open(tarPath)
@@ -1,17 +1,23 @@
 package docker
 
 import (
+	"archive/tar"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"io"
 	"os"
+	"sort"
 	"testing"
 
 	"github.com/go-test/deep"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/sergi/go-diff/diffmatchpatch"
 
 	"github.com/anchore/go-testutils"
+	"github.com/anchore/stereoscope/pkg/file"
 )
 
 var update = flag.Bool("update", false, "update the *.golden files for the oci manifest assembly test")
@@ -123,6 +129,188 @@ func TestManifestTags(t *testing.T) {
 	}
 }
 
+func TestRepoTagsFromTar(t *testing.T) {
+	manifest, err := os.ReadFile("test-fixtures/valid-multi-manifest-with-tags.json")
+	if err != nil {
+		t.Fatalf("could not read fixture: %+v", err)
+	}
+
+	archive := newTestDockerArchive(t, map[string][]byte{
+		"881a352c4517dbf5e561a08dd1c7cf65f6c4349d3ab9b13e95210800e12b14a8.json": []byte("{}"),
+		"manifest.json": manifest,
+	})
+
+	tags, err := RepoTagsFromTar(archive, archive.Size())
+	if err != nil {
+		t.Fatalf("unable to get tags: %+v", err)
+	}
+
+	for _, d := range deep.Equal(tags, []string{"anchore/anchore-engine:latest", "anchore/anchore-engine:v0.8.2"}) {
+		t.Errorf("diff: %s", d)
+	}
+}
+
+func TestRepoTagsFromTar_MissingManifest(t *testing.T) {
+	archive := newTestDockerArchive(t, map[string][]byte{"other.json": []byte("{}")})
+
+	if _, err := RepoTagsFromTar(archive, archive.Size()); err == nil {
+		t.Fatal("expected an error for an archive without a manifest")
+	}
+}
+
+func TestLegacyRepositoriesFromTar(t *testing.T) {
+	repositories, err := os.ReadFile("test-fixtures/legacy-repositories.json")
+	if err != nil {
+		t.Fatalf("could not read fixture: %+v", err)
+	}
+
+	archive := newTestDockerArchive(t, map[string][]byte{
+		"2b8fd9751c4c0f5dd266fcae00707e67a2545ef34f9a29354585f93dac906749/json":      []byte("{}"),
+		"2b8fd9751c4c0f5dd266fcae00707e67a2545ef34f9a29354585f93dac906749/layer.tar": nil,
+		"repositories": repositories,
+	})
+
+	actual, err := LegacyRepositoriesFromTar(archive)
+	if err != nil {
+		t.Fatalf("unable to get repositories: %+v", err)
+	}
+
+	expected := map[string]map[string]string{
+		"busybox": {
+			"latest": "2b8fd9751c4c0f5dd266fcae00707e67a2545ef34f9a29354585f93dac906749",
+			"1.36":   "2b8fd9751c4c0f5dd266fcae00707e67a2545ef34f9a29354585f93dac906749",
+		},
+		"alpine": {
+			"3.18": "d4ff818577bc193b309b355b02ebc9220427090057b54a59e73b79bdfe139b83",
+		},
+	}
+	for _, d := range deep.Equal(actual, expected) {
+		t.Errorf("diff: %s", d)
+	}
+}
+
+func TestLegacyRepositoriesFromTar_MissingRepositories(t *testing.T) {
+	archive := newTestDockerArchive(t, map[string][]byte{"manifest.json": []byte("[]")})
+
+	_, err := LegacyRepositoriesFromTar(archive)
+	var notFound *file.ErrFileNotFound
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected ErrFileNotFound, got: %+v", err)
+	}
+}
+
+func TestImageConfigFromTar(t *testing.T) {
+	config, err := os.ReadFile("test-fixtures/engine-config.json")
+	if err != nil {
+		t.Fatalf("could not read fixture: %+v", err)
+	}
+	configDigest, _, err := v1.SHA256(bytes.NewReader(config))
+	if err != nil {
+		t.Fatalf("could not digest config: %+v", err)
+	}
+
+	ociManifest := mustMarshal(t, v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		Config:        v1.Descriptor{MediaType: types.OCIConfigJSON, Size: int64(len(config)), Digest: configDigest},
+	})
+	manifestDigest, _, err := v1.SHA256(bytes.NewReader(ociManifest))
+	if err != nil {
+		t.Fatalf("could not digest manifest: %+v", err)
+	}
+
+	tests := []struct {
+		name    string
+		archive *bytes.Reader
+	}{
+		{
+			name: "docker archive",
+			archive: newTestDockerArchive(t, map[string][]byte{
+				"config.json":   config,
+				"manifest.json": []byte(`[{"Config":"config.json","RepoTags":["test:latest"],"Layers":[]}]`),
+			}),
+		},
+		{
+			name: "oci layout",
+			archive: newTestDockerArchive(t, map[string][]byte{
+				"oci-layout": []byte(`{"imageLayoutVersion":"1.0.0"}`),
+				"index.json": mustMarshal(t, v1.IndexManifest{
+					SchemaVersion: 2,
+					Manifests:     []v1.Descriptor{{MediaType: types.OCIManifestSchema1, Size: int64(len(ociManifest)), Digest: manifestDigest}},
+				}),
+				"blobs/sha256/" + manifestDigest.Hex: ociManifest,
+				"blobs/sha256/" + configDigest.Hex:   config,
+			}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := ImageConfigFromTar(test.archive, test.archive.Size())
+			if err != nil {
+				t.Fatalf("unable to get config: %+v", err)
+			}
+
+			var parsed map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &parsed); err != nil {
+				t.Fatalf("unable to parse config: %+v", err)
+			}
+			for _, key := range []string{"architecture", "config", "rootfs"} {
+				if _, ok := parsed[key]; !ok {
+					t.Errorf("missing expected key %q", key)
+				}
+			}
+		})
+	}
+}
+
+func TestImageConfigFromTar_MultipleManifests(t *testing.T) {
+	manifest, err := os.ReadFile("test-fixtures/valid-multi-manifest-with-tags.json")
+	if err != nil {
+		t.Fatalf("could not read fixture: %+v", err)
+	}
+	archive := newTestDockerArchive(t, map[string][]byte{"manifest.json": manifest})
+
+	if _, err := ImageConfigFromTar(archive, archive.Size()); !errors.Is(err, ErrMultipleManifests) {
+		t.Fatalf("expected ErrMultipleManifests, got: %+v", err)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("unable to marshal: %+v", err)
+	}
+	return raw
+}
+
+// newTestDockerArchive creates an in-memory tar with the given files (written in sorted order).
+func newTestDockerArchive(t *testing.T, files map[string][]byte) *bytes.Reader {
+	t.Helper()
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(files[name]))}); err != nil {
+			t.Fatalf("unable to write header: %+v", err)
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			t.Fatalf("unable to write content: %+v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unable to close tar: %+v", err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
 func TestAssembleOCIManifest(t *testing.T) {
 	// note: this is a
 	fh, err := os.Open("test-fixtures/engine-config.json")
@@ -0,0 +1,157 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/scylladb/go-set/strset"
+
+	"github.com/anchore/stereoscope/pkg/file"
+)
+
+// ErrInconsistentManifest is returned when the blobs present within a docker image tar do not match those referenced
+// by its manifest.json.
+type ErrInconsistentManifest struct {
+	// Missing are the paths referenced by the manifest (configs and layers) that are not present within the archive.
+	Missing []string
+	// Extra are the layer blobs present within the archive that are not referenced by any manifest.
+	Extra []string
+}
+
+func (e *ErrInconsistentManifest) Error() string {
+	return fmt.Sprintf("manifest.json is inconsistent with the archive contents (missing=%v extra=%v)", e.Missing, e.Extra)
+}
+
+// ValidateManifestConsistency cross-checks the manifest.json of the given docker image tar (e.g. from "docker save")
+// against the blobs actually present, returning ErrInconsistentManifest when any referenced config or layer is missing,
+// or when layer blobs are present that nothing references. Layer blobs are "<id>/layer.tar" entries (the legacy
+// layout) and all entries within "blobs/" (the OCI-compatible layout written by newer docker versions), where blobs
+// referenced by index.json (such as image manifests) are also considered referenced. This catches corrupted or tampered
+// archives before extraction, and only reads headers (besides the manifests).
+func ValidateManifestConsistency(reader io.ReaderAt, size int64) error {
+	manifest, err := readManifest(io.NopCloser(io.NewSectionReader(reader, 0, size)))
+	if err != nil {
+		return err
+	}
+
+	present := strset.New()
+	err = file.IterateTar(io.NewSectionReader(reader, 0, size), func(entry file.TarFileEntry) error {
+		if entry.Header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		present.Add(path.Clean(entry.Header.Name))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	referenced := strset.New()
+	for _, m := range manifest.parsed {
+		referenced.Add(path.Clean(m.Config))
+		for _, layer := range m.Layers {
+			referenced.Add(path.Clean(layer))
+		}
+	}
+	ociReferenced, err := ociReferencedBlobs(reader, size, present)
+	if err != nil {
+		return err
+	}
+
+	inconsistency := &ErrInconsistentManifest{}
+	for _, name := range referenced.List() {
+		if !present.Has(name) {
+			inconsistency.Missing = append(inconsistency.Missing, name)
+		}
+	}
+	for _, name := range present.List() {
+		if isLayerBlob(name) && !referenced.Has(name) && !ociReferenced.Has(name) {
+			inconsistency.Extra = append(inconsistency.Extra, name)
+		}
+	}
+	if len(inconsistency.Missing) == 0 && len(inconsistency.Extra) == 0 {
+		return nil
+	}
+	sort.Strings(inconsistency.Missing)
+	sort.Strings(inconsistency.Extra)
+	return inconsistency
+}
+
+func isLayerBlob(name string) bool {
+	return path.Base(name) == "layer.tar" || strings.HasPrefix(name, "blobs/")
+}
+
+// ociReferencedBlobs returns the paths of all blobs reachable from the index.json within the given tar (if any),
+// following nested indexes to the image manifests, configs, and layers.
+func ociReferencedBlobs(reader io.ReaderAt, size int64, present *strset.Set) (*strset.Set, error) {
+	referenced := strset.New()
+	if !present.Has("index.json") {
+		return referenced, nil
+	}
+
+	contents, err := readJSONFromTar(reader, size, "index.json")
+	if err != nil {
+		return nil, err
+	}
+	indexes := [][]byte{contents}
+	for depth := 0; len(indexes) > 0; depth++ {
+		if depth >= maxOCIIndexDepth {
+			return nil, fmt.Errorf("exceeded max OCI index depth of %d", maxOCIIndexDepth)
+		}
+		var next [][]byte
+		for _, raw := range indexes {
+			nested, err := addOCIIndexReferences(reader, size, raw, referenced)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, nested...)
+		}
+		indexes = next
+	}
+	return referenced, nil
+}
+
+// addOCIIndexReferences adds the blobs referenced by the given index (and by the image manifests it references) to
+// referenced, returning the contents of any nested indexes. Blobs that are not present are skipped, since an index may
+// describe more platforms than were exported.
+func addOCIIndexReferences(reader io.ReaderAt, size int64, raw []byte, referenced *strset.Set) ([][]byte, error) {
+	index, err := v1.ParseIndexManifest(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse OCI index: %w", err)
+	}
+
+	var nested [][]byte
+	for _, descriptor := range index.Manifests {
+		blobPath := ociBlobPath(descriptor.Digest)
+		contents, err := readJSONFromTar(reader, size, blobPath)
+		var notFound *file.ErrFileNotFound
+		switch {
+		case errors.As(err, &notFound):
+			continue
+		case err != nil:
+			return nil, err
+		}
+		referenced.Add(blobPath)
+
+		if descriptor.MediaType.IsIndex() {
+			nested = append(nested, contents)
+			continue
+		}
+		manifest, err := v1.ParseManifest(bytes.NewReader(contents))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse OCI manifest: %w", err)
+		}
+		referenced.Add(ociBlobPath(manifest.Config.Digest))
+		for _, layer := range manifest.Layers {
+			referenced.Add(ociBlobPath(layer.Digest))
+		}
+	}
+	return nested, nil
+}
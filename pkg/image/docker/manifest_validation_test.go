@@ -0,0 +1,117 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/go-test/deep"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestValidateManifestConsistency(t *testing.T) {
+	legacyManifest := []byte(`[{"Config":"config.json","RepoTags":["test:latest"],"Layers":["a/layer.tar","b/layer.tar"]}]`)
+
+	tests := []struct {
+		name        string
+		files       map[string][]byte
+		wantMissing []string
+		wantExtra   []string
+	}{
+		{
+			name: "consistent",
+			files: map[string][]byte{
+				"manifest.json": legacyManifest,
+				"config.json":   []byte("{}"),
+				"a/layer.tar":   []byte("a"),
+				"a/json":        []byte("{}"),
+				"b/layer.tar":   []byte("b"),
+			},
+		},
+		{
+			name: "missing a referenced layer",
+			files: map[string][]byte{
+				"manifest.json": legacyManifest,
+				"config.json":   []byte("{}"),
+				"a/layer.tar":   []byte("a"),
+			},
+			wantMissing: []string{"b/layer.tar"},
+		},
+		{
+			name: "extra layer",
+			files: map[string][]byte{
+				"manifest.json": legacyManifest,
+				"config.json":   []byte("{}"),
+				"a/layer.tar":   []byte("a"),
+				"b/layer.tar":   []byte("b"),
+				"c/layer.tar":   []byte("c"),
+			},
+			wantExtra: []string{"c/layer.tar"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := newTestDockerArchive(t, test.files)
+			err := ValidateManifestConsistency(archive, archive.Size())
+			if test.wantMissing == nil && test.wantExtra == nil {
+				if err != nil {
+					t.Fatalf("expected a consistent archive, got: %+v", err)
+				}
+				return
+			}
+
+			var inconsistent *ErrInconsistentManifest
+			if !errors.As(err, &inconsistent) {
+				t.Fatalf("expected ErrInconsistentManifest, got: %+v", err)
+			}
+			for _, d := range deep.Equal(inconsistent.Missing, test.wantMissing) {
+				t.Errorf("missing diff: %s", d)
+			}
+			for _, d := range deep.Equal(inconsistent.Extra, test.wantExtra) {
+				t.Errorf("extra diff: %s", d)
+			}
+		})
+	}
+}
+
+func TestValidateManifestConsistency_OCILayout(t *testing.T) {
+	config := []byte("{}")
+	layer := []byte("layer")
+	configDigest, _, err := v1.SHA256(bytes.NewReader(config))
+	if err != nil {
+		t.Fatalf("could not digest config: %+v", err)
+	}
+	layerDigest, _, err := v1.SHA256(bytes.NewReader(layer))
+	if err != nil {
+		t.Fatalf("could not digest layer: %+v", err)
+	}
+	ociManifest := mustMarshal(t, v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		Config:        v1.Descriptor{MediaType: types.OCIConfigJSON, Size: int64(len(config)), Digest: configDigest},
+		Layers:        []v1.Descriptor{{MediaType: types.OCILayer, Size: int64(len(layer)), Digest: layerDigest}},
+	})
+	manifestDigest, _, err := v1.SHA256(bytes.NewReader(ociManifest))
+	if err != nil {
+		t.Fatalf("could not digest manifest: %+v", err)
+	}
+
+	// the layout written by newer docker versions, where manifest.json references blobs also reachable from index.json
+	archive := newTestDockerArchive(t, map[string][]byte{
+		"manifest.json": []byte(`[{"Config":"blobs/sha256/` + configDigest.Hex + `","Layers":["blobs/sha256/` + layerDigest.Hex + `"]}]`),
+		"oci-layout":    []byte(`{"imageLayoutVersion":"1.0.0"}`),
+		"index.json": mustMarshal(t, v1.IndexManifest{
+			SchemaVersion: 2,
+			Manifests:     []v1.Descriptor{{MediaType: types.OCIManifestSchema1, Size: int64(len(ociManifest)), Digest: manifestDigest}},
+		}),
+		"blobs/sha256/" + manifestDigest.Hex: ociManifest,
+		"blobs/sha256/" + configDigest.Hex:   config,
+		"blobs/sha256/" + layerDigest.Hex:    layer,
+	})
+
+	if err := ValidateManifestConsistency(archive, archive.Size()); err != nil {
+		t.Fatalf("expected a consistent archive, got: %+v", err)
+	}
+}